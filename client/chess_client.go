@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -202,7 +203,7 @@ func getForm(krbFile string) url.Values {
 }
 
 // helper function to place request to chess data management system
-func placeRequest(schemaName, uri, fileName, krbFile string, verbose int) error {
+func placeRequest(schemaName, uri, fileName, parent, processing string, wait bool, krbFile string, verbose int) error {
 
 	// if we'll pass yaml file we'll need to convert it to json
 	// if we'll pass json data we should probably read it via
@@ -214,6 +215,12 @@ func placeRequest(schemaName, uri, fileName, krbFile string, verbose int) error
 	form := getForm(krbFile)
 	form.Add("record", string(record))
 	form.Add("SchemaName", schemaName)
+	if parent != "" {
+		form.Add("parent", parent)
+	}
+	if processing != "" {
+		form.Add("processing", processing)
+	}
 	rurl := fmt.Sprintf("%s/api", uri)
 	req, err := http.NewRequest("POST", rurl, strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
@@ -225,6 +232,9 @@ func placeRequest(schemaName, uri, fileName, krbFile string, verbose int) error
 	servercrt := getCertificate()
 	client := httpClient(servercrt)
 	resp, err := client.Do(req)
+	if err != nil {
+		exit("Fail to place request", err)
+	}
 	defer resp.Body.Close()
 	if verbose > 1 {
 		if resp != nil {
@@ -237,14 +247,100 @@ func placeRequest(schemaName, uri, fileName, krbFile string, verbose int) error
 	}
 	response, _ := ioutil.ReadAll(resp.Body)
 	fmt.Println(string(response))
+	if wait {
+		var rec map[string]any
+		if err := json.Unmarshal(response, &rec); err == nil {
+			if jid, ok := rec["job"].(string); ok && jid != "" {
+				jobStatus(uri, jid, true, krbFile, verbose)
+			}
+		}
+	}
 	return err
 }
 
+// helper function to insert multiple records (JSON array or NDJSON file)
+// into chess data management system
+func placeBulkRequest(schemaName, uri, fileName, krbFile string, verbose int) {
+	records, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		exit("unable to read records file", err)
+	}
+	form := getForm(krbFile)
+	form.Add("records", string(records))
+	form.Add("SchemaName", schemaName)
+	rurl := fmt.Sprintf("%s/api/bulk", uri)
+	req, err := http.NewRequest("POST", rurl, strings.NewReader(form.Encode()))
+	if err != nil {
+		exit("bulk insert method fails", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if verbose > 1 {
+		dump, err := httputil.DumpRequestOut(req, false)
+		log.Printf("http request %+v, rurl %v, dump %v, error %v\n", req, rurl, string(dump), err)
+	}
+	servercrt := getCertificate()
+	client := httpClient(servercrt)
+	resp, err := client.Do(req)
+	if err != nil {
+		exit("Fail to place request", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		exit(fmt.Sprintf("read response body failure, error: %v", resp.Status), nil)
+	}
+	// the response contains status of every record, we print it even if
+	// some records are failed
+	fmt.Println(string(data))
+	if resp.StatusCode != http.StatusOK {
+		exit(fmt.Sprintf("request fails with status: %v", resp.Status), nil)
+	}
+}
+
+// helper function to check dataset patterns of the query, e.g.
+// dataset:/2022-3/3A/*/Ti*, they should have /cycle/beamline/BTR/sample form
+func checkDatasetQuery(query string) error {
+	for _, item := range strings.Fields(query) {
+		if !strings.HasPrefix(item, "dataset:") {
+			continue
+		}
+		pattern := strings.TrimPrefix(item, "dataset:")
+		parts := strings.Split(pattern, "/")
+		if len(parts) != 5 || parts[0] != "" {
+			return fmt.Errorf("invalid dataset pattern '%s', should be /cycle/beamline/BTR/sample", pattern)
+		}
+		for _, p := range parts[1:] {
+			if p == "" {
+				return fmt.Errorf("invalid dataset pattern '%s', empty segment", pattern)
+			}
+		}
+	}
+	return nil
+}
+
 // helper function to look-up records in chess data management system
-func findRecords(uri, query, krbFile string, verbose int) {
+func findRecords(uri, query, sort string, filters []string, facets bool, idx, limit int, krbFile string, verbose int) {
+	if err := checkDatasetQuery(query); err != nil {
+		exit("invalid query", err)
+	}
 	form := getForm(krbFile)
 	form.Add("query", string(query))
 	form.Add("client", "cli")
+	for _, f := range filters {
+		form.Add("filter", f)
+	}
+	if facets {
+		form.Add("facets", "true")
+	}
+	if sort != "" {
+		form.Add("sort", sort)
+	}
+	if idx > 0 {
+		form.Add("idx", fmt.Sprintf("%d", idx))
+	}
+	if limit > 0 {
+		form.Add("limit", fmt.Sprintf("%d", limit))
+	}
 	rurl := fmt.Sprintf("%s/search", uri)
 	req, err := http.NewRequest("POST", rurl, strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
@@ -278,10 +374,14 @@ func findRecords(uri, query, krbFile string, verbose int) {
 	fmt.Println(string(data))
 }
 
-// helper function to look-up records in chess data management system
-func findFiles(uri string, did int64, krbFile string, verbose int) {
+// helper function to look-up files of given did, optionally restricted to
+// given data tier
+func findFiles(uri string, did int64, tier, krbFile string, verbose int) {
 	form := getForm(krbFile)
 	form.Add("did", fmt.Sprintf("%d", did))
+	if tier != "" {
+		form.Add("tier", tier)
+	}
 	rurl := fmt.Sprintf("%s/files", uri)
 	req, err := http.NewRequest("POST", rurl, strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
@@ -315,21 +415,172 @@ func findFiles(uri string, did int64, krbFile string, verbose int) {
 	fmt.Println(string(data))
 }
 
+// helper function to rescan directory of given did and print report of
+// changed files
+func rescanFiles(uri, did, krbFile string, verbose int) {
+	form := getForm(krbFile)
+	form.Add("did", did)
+	rurl := fmt.Sprintf("%s/rescan", uri)
+	req, err := http.NewRequest("POST", rurl, strings.NewReader(form.Encode()))
+	if err != nil {
+		exit("rescan method fails", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	if verbose > 1 {
+		dump, err := httputil.DumpRequestOut(req, true)
+		log.Printf("http request %+v, rurl %v, dump %v, error %v\n", req, rurl, string(dump), err)
+	}
+	servercrt := getCertificate()
+	client := httpClient(servercrt)
+	resp, err := client.Do(req)
+	if err != nil {
+		exit("Fail to place request", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		exit(fmt.Sprintf("read response body failure, error: %v", resp.Status), nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		exit(fmt.Sprintf("request fails with status: %v, %s", resp.Status, string(data)), nil)
+	}
+	fmt.Println(string(data))
+}
+
+// helper function to look-up ancestry and descendants of given dataset
+func findLineage(uri, dataset, krbFile string, verbose int) {
+	form := getForm(krbFile)
+	form.Add("dataset", dataset)
+	rurl := fmt.Sprintf("%s/lineage", uri)
+	req, err := http.NewRequest("POST", rurl, strings.NewReader(form.Encode()))
+	if err != nil {
+		exit("find lineage method fails", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	if verbose > 1 {
+		dump, err := httputil.DumpRequestOut(req, true)
+		log.Printf("http request %+v, rurl %v, dump %v, error %v\n", req, rurl, string(dump), err)
+	}
+	servercrt := getCertificate()
+	client := httpClient(servercrt)
+	resp, err := client.Do(req)
+	if err != nil {
+		exit("Fail to place request", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		exit(fmt.Sprintf("read response body failure, error: %v", resp.Status), nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		exit(fmt.Sprintf("request fails with status: %v, %s", resp.Status, string(data)), nil)
+	}
+	fmt.Println(string(data))
+}
+
+// helper function to get status of the job which registers dataset files,
+// it waits for job completion if requested
+func jobStatus(uri, jid string, wait bool, krbFile string, verbose int) {
+	rurl := fmt.Sprintf("%s/jobs/%s", uri, jid)
+	servercrt := getCertificate()
+	client := httpClient(servercrt)
+	for {
+		form := getForm(krbFile)
+		req, err := http.NewRequest("POST", rurl, strings.NewReader(form.Encode()))
+		if err != nil {
+			exit("job status method fails", err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("Accept", "application/json")
+		if verbose > 1 {
+			dump, err := httputil.DumpRequestOut(req, true)
+			log.Printf("http request %+v, rurl %v, dump %v, error %v\n", req, rurl, string(dump), err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			exit("Fail to place request", err)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			exit(fmt.Sprintf("read response body failure, error: %v", resp.Status), nil)
+		}
+		if resp.StatusCode != http.StatusOK {
+			exit(fmt.Sprintf("request fails with status: %v, %s", resp.Status, string(data)), nil)
+		}
+		var job map[string]any
+		if err := json.Unmarshal(data, &job); err != nil {
+			exit("unable to parse job status", err)
+		}
+		status := fmt.Sprintf("%v", job["status"])
+		if !wait || status == "done" || status == "failed" {
+			fmt.Println(string(data))
+			return
+		}
+		if verbose > 0 {
+			log.Printf("job %s status %s, found %v files, registered %v files", jid, status, job["files"], job["registered"])
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
 func info() string {
 	goVersion := runtime.Version()
 	tstamp := time.Now()
 	return fmt.Sprintf("git={{VERSION}} go=%s date=%s", goVersion, tstamp)
 }
 
+// filterList represents list of search filters provided via repeated flag
+type filterList []string
+
+// String implements flag.Value interface
+func (f *filterList) String() string {
+	return strings.Join(*f, " ")
+}
+
+// Set implements flag.Value interface
+func (f *filterList) Set(val string) error {
+	*f = append(*f, val)
+	return nil
+}
+
 func main() {
 	var schema string
 	flag.StringVar(&schema, "schema", "", "schema name for your data")
 	var query string
 	flag.StringVar(&query, "query", "", "query string to look-up your data")
+	var sort string
+	flag.StringVar(&sort, "sort", "", "sort query results, e.g. Cycle:desc,SampleName:asc")
+	var filters filterList
+	flag.Var(&filters, "filter", "refine query results by facet value, e.g. Beamline:3A (can be repeated)")
+	var facets bool
+	flag.BoolVar(&facets, "facets", false, "show facet counts of query results")
+	var idx int
+	flag.IntVar(&idx, "idx", 0, "index of first record to return")
+	var limit int
+	flag.IntVar(&limit, "limit", 0, "maximum number of records to return, 0 means all records")
 	var did int64
 	flag.Int64Var(&did, "did", 0, "show files for given dataset-id")
+	var tier string
+	flag.StringVar(&tier, "tier", "", "show files of given data tier: RAW, META, REDUCED, SCRATCH or NOTES")
 	var record string
 	flag.StringVar(&record, "insert", "", "insert record to the server")
+	var parent string
+	flag.StringVar(&parent, "parent", "", "parent dataset the inserted record is derived from")
+	var processing string
+	flag.StringVar(&processing, "processing", "", "name of processing which produced the inserted record")
+	var lineage string
+	flag.StringVar(&lineage, "lineage", "", "show ancestry and descendants of given dataset")
+	var rescan string
+	flag.StringVar(&rescan, "rescan", "", "rescan directory of given did and register new or changed files")
+	var job string
+	flag.StringVar(&job, "job", "", "show status of the job which registers files of inserted record")
+	var wait bool
+	flag.BoolVar(&wait, "wait", false, "wait until files of inserted record or given job are registered")
+	var bulk string
+	flag.StringVar(&bulk, "bulk", "", "insert multiple records (JSON array or NDJSON file) to the server")
 	var krbFile string
 	flag.StringVar(&krbFile, "krbFile", "", "kerberos file")
 	flag.BoolVar(&devMode, "devMode", false, "run dev mode")
@@ -348,12 +599,32 @@ func main() {
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n\n# inject new record into the system using lite schema")
 		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -insert record.json -schema lite", client)
+		fmt.Fprintf(os.Stderr, "\n\n# inject reduced data record derived from raw dataset")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -insert reduced.json -schema lite -parent /2022-3/3A/123/sample -processing tomo-recon", client)
+		fmt.Fprintf(os.Stderr, "\n\n# inject new record and wait until its files are registered by the server")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -insert record.json -schema ID3A -wait", client)
+		fmt.Fprintf(os.Stderr, "\n\n# show status of the job which registers files of inserted record")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -job 0b1c2d3e4f", client)
+		fmt.Fprintf(os.Stderr, "\n\n# inject multiple records (JSON array or one JSON record per line) using ID3A schema")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -bulk records.ndjson -schema ID3A", client)
 		fmt.Fprintf(os.Stderr, "\n\n# look-up data from the system using free text-search")
 		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -query=\"search words\"", client)
 		fmt.Fprintf(os.Stderr, "\n\n# look-up data from the system using keyword search")
 		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -query=\"proposal:123\"", client)
-		fmt.Fprintf(os.Stderr, "\n\n# look-up files for specific dataset-id")
-		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -did=1570563920579312510\n", client)
+		fmt.Fprintf(os.Stderr, "\n\n# look-up records of datasets using wildcards in /cycle/beamline/BTR/sample segments")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -query=\"dataset:/2022-3/3A/*/Ti*\"", client)
+		fmt.Fprintf(os.Stderr, "\n\n# look-up first 10 records sorted by cycle in descending order")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -query=\"proposal:123\" -sort Cycle:desc -limit 10", client)
+		fmt.Fprintf(os.Stderr, "\n\n# look-up records along with facet counts and refine them by facet value")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -query=\"proposal:123\" -facets -filter Beamline:3A", client)
+		fmt.Fprintf(os.Stderr, "\n\n# look-up ancestry and descendants of a dataset")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -lineage /2022-3/3A/123/sample", client)
+		fmt.Fprintf(os.Stderr, "\n\n# rescan directory of a dataset and show report of new, changed and removed files")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -rescan 0b1c2d3e4f", client)
+		fmt.Fprintf(os.Stderr, "\n\n# look-up files (type, tier, size, mtime, checksum and name) for specific dataset-id")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -did=1570563920579312510", client)
+		fmt.Fprintf(os.Stderr, "\n\n# look-up only reduced files for specific dataset-id")
+		fmt.Fprintf(os.Stderr, "\n%s -krbFile krb5cc_ccache -did=1570563920579312510 -tier REDUCED\n", client)
 	}
 	flag.Parse()
 	if version {
@@ -375,12 +646,28 @@ func main() {
 		}
 	}
 	if did > 0 {
-		findFiles(uri, did, krbFile, verbose)
+		findFiles(uri, did, tier, krbFile, verbose)
+		return
+	}
+	if query != "" || len(filters) > 0 {
+		findRecords(uri, query, sort, filters, facets, idx, limit, krbFile, verbose)
+		return
+	}
+	if job != "" {
+		jobStatus(uri, job, wait, krbFile, verbose)
+		return
+	}
+	if rescan != "" {
+		rescanFiles(uri, rescan, krbFile, verbose)
+		return
+	}
+	if lineage != "" {
+		findLineage(uri, lineage, krbFile, verbose)
 		return
 	}
-	if query != "" {
-		findRecords(uri, query, krbFile, verbose)
+	if bulk != "" {
+		placeBulkRequest(schema, uri, bulk, krbFile, verbose)
 		return
 	}
-	placeRequest(schema, uri, record, krbFile, verbose)
+	placeRequest(schema, uri, record, parent, processing, wait, krbFile, verbose)
 }
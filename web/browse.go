@@ -0,0 +1,484 @@
+package main
+
+// browse module provides FilesDB browsing APIs for datasets and their files
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BrowseLimit defines default number of datasets or files per page
+var BrowseLimit = 50
+
+// BrowseMaxLimit defines maximum number of datasets or files per page
+var BrowseMaxLimit = 1000
+
+// DatasetInfo represents FilesDB dataset
+type DatasetInfo struct {
+	Dataset  string `json:"dataset"`
+	Did      string `json:"did"`
+	Cycle    string `json:"cycle"`
+	Beamline string `json:"beamline"`
+	Btr      string `json:"btr"`
+	Sample   string `json:"sample"`
+	Files    int    `json:"files,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	CreateAt int64  `json:"create_at"`
+	CreateBy string `json:"create_by"`
+}
+
+// Page represents single page of browsing results
+type Page struct {
+	Datasets []DatasetInfo `json:"datasets,omitempty"`
+	Files    []FileInfo    `json:"files,omitempty"`
+	Total    int           `json:"total"`
+	Idx      int           `json:"idx"`
+	Limit    int           `json:"limit"`
+}
+
+// helper function to split dataset into /cycle/beamline/BTR/sample parts
+func datasetParts(dataset string) []string {
+	parts := strings.Split(strings.TrimPrefix(dataset, "/"), "/")
+	if !strings.HasPrefix(dataset, "/") || len(parts) != 4 {
+		return nil
+	}
+	for _, p := range parts {
+		if p == "" {
+			return nil
+		}
+	}
+	return parts
+}
+
+// globToLike converts glob pattern into SQL LIKE pattern with ! escape
+// character, e.g. /2022-3/3A/*/Ti* becomes /2022-3/3A/%/Ti%
+func globToLike(pattern string) string {
+	var sb strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '!', '%', '_':
+			sb.WriteRune('!')
+			sb.WriteRune(c)
+		case '*':
+			sb.WriteRune('%')
+		case '?':
+			sb.WriteRune('_')
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// globToRegexp converts glob pattern into anchored regular expression with
+// the same semantics as globToLike pattern
+func globToRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// globMatcher matches names against glob pattern with the same semantics as
+// globToLike pattern, the set of pattern positions reached by a part of the
+// name is kept as string of flags which allows to count names sharing given
+// prefix without building them
+type globMatcher []rune
+
+// helper function to extend set of positions over stars of the pattern
+func (g globMatcher) closure(set []byte) string {
+	for i, c := range g {
+		if set[i] == 1 && c == '*' {
+			set[i+1] = 1
+		}
+	}
+	return string(set)
+}
+
+// helper function to get set of positions before matching a name
+func (g globMatcher) start() string {
+	set := make([]byte, len(g)+1)
+	set[0] = 1
+	return g.closure(set)
+}
+
+// helper function to advance set of positions by given character
+func (g globMatcher) step(set string, r rune) string {
+	next := make([]byte, len(g)+1)
+	for i, c := range g {
+		if set[i] == 0 {
+			continue
+		}
+		if c == '*' {
+			next[i] = 1
+		} else if c == '?' || c == r {
+			next[i+1] = 1
+		}
+	}
+	return g.closure(next)
+}
+
+// helper function to advance set of positions by given string
+func (g globMatcher) feed(set, s string) string {
+	for _, r := range s {
+		if g.dead(set) {
+			break
+		}
+		set = g.step(set, r)
+	}
+	return set
+}
+
+// helper function to check if no name can match from given set of positions
+func (g globMatcher) dead(set string) bool {
+	return strings.IndexByte(set, 1) < 0
+}
+
+// helper function to check if given set of positions matches whole pattern
+func (g globMatcher) accepts(set string) bool {
+	return set[len(g)] == 1
+}
+
+// datasetPattern builds dataset glob pattern from dataset or its
+// cycle/beamline/btr/sample parameters, missing parts match any value
+func datasetPattern(r *http.Request) (string, error) {
+	if pattern := r.FormValue("dataset"); pattern != "" {
+		if !PatternDataset.MatchString(pattern) || datasetParts(pattern) == nil {
+			return "", fmt.Errorf("invalid dataset pattern '%s', should be /cycle/beamline/btr/sample", pattern)
+		}
+		return pattern, nil
+	}
+	var parts []string
+	for _, key := range []string{"cycle", "beamline", "btr", "sample"} {
+		val := r.FormValue(key)
+		if val == "" {
+			val = "*"
+		}
+		if strings.Contains(val, "/") {
+			return "", fmt.Errorf("invalid %s pattern '%s'", key, val)
+		}
+		parts = append(parts, val)
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
+
+// pageParams parses idx and limit parameters of HTTP request
+func pageParams(r *http.Request) (int, int, error) {
+	idx, limit := 0, BrowseLimit
+	if val := r.FormValue("idx"); val != "" {
+		v, err := strconv.Atoi(val)
+		if err != nil || v < 0 {
+			return idx, limit, fmt.Errorf("invalid idx value '%s'", val)
+		}
+		idx = v
+	}
+	if val := r.FormValue("limit"); val != "" {
+		v, err := strconv.Atoi(val)
+		if err != nil || v <= 0 {
+			return idx, limit, fmt.Errorf("invalid limit value '%s'", val)
+		}
+		limit = v
+	}
+	if limit > BrowseMaxLimit {
+		limit = BrowseMaxLimit
+	}
+	return idx, limit, nil
+}
+
+// datasetCondition translates dataset glob pattern into SQL condition on
+// cycle, beamline, btr and sample columns of datasets table, the segments
+// without wildcards are matched exactly and segments with only * are omitted
+func datasetCondition(pattern string) (string, []any) {
+	parts := datasetParts(pattern)
+	if parts == nil {
+		return "D.dataset LIKE ? ESCAPE '!'", []any{globToLike(pattern)}
+	}
+	conds := []string{"1=1"}
+	var args []any
+	for i, col := range []string{"cycle", "beamline", "btr", "sample"} {
+		part := parts[i]
+		if part == "*" {
+			continue
+		}
+		if strings.ContainsAny(part, "*?") {
+			conds = append(conds, fmt.Sprintf("D.%s LIKE ? ESCAPE '!'", col))
+			args = append(args, globToLike(part))
+		} else {
+			conds = append(conds, fmt.Sprintf("D.%s=?", col))
+			args = append(args, part)
+		}
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// findDatasets returns datasets matching given glob pattern along with
+// total number of matching datasets, datasets of deleted records are omitted
+func findDatasets(pattern string, idx, limit int) ([]DatasetInfo, int, error) {
+	var out []DatasetInfo
+	cond, args := datasetCondition(pattern)
+	// datasets of deleted records are not shown
+	cond += " AND D.is_deleted=0"
+	var total int
+	stmt := "SELECT COUNT(*) FROM datasets D JOIN metadata M ON M.meta_id=D.meta_id WHERE " + cond
+	if err := FilesDB.QueryRow(rebind(stmt), args...).Scan(&total); err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return out, 0, err
+	}
+	stmt = "SELECT D.dataset, M.did, D.create_at, D.create_by FROM datasets D JOIN metadata M ON M.meta_id=D.meta_id WHERE " + cond + " ORDER BY D.dataset LIMIT ? OFFSET ?"
+	res, err := FilesDB.Query(rebind(stmt), append(args, limit, idx)...)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return out, total, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var info DatasetInfo
+		var createAt sql.NullInt64
+		var createBy sql.NullString
+		if err := res.Scan(&info.Dataset, &info.Did, &createAt, &createBy); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return out, total, err
+		}
+		info.CreateAt = createAt.Int64
+		info.CreateBy = createBy.String
+		info.setParts()
+		out = append(out, info)
+	}
+	return out, total, res.Err()
+}
+
+// helper function to fill dataset parts
+func (d *DatasetInfo) setParts() {
+	if parts := datasetParts(d.Dataset); parts != nil {
+		d.Cycle, d.Beamline, d.Btr, d.Sample = parts[0], parts[1], parts[2], parts[3]
+	}
+}
+
+// getDatasetInfo returns dataset with its number and size of valid files
+func getDatasetInfo(dataset string) (DatasetInfo, error) {
+	info := DatasetInfo{Dataset: dataset}
+	var createAt sql.NullInt64
+	var createBy sql.NullString
+	stmt := "SELECT M.did, D.create_at, D.create_by FROM datasets D JOIN metadata M ON M.meta_id=D.meta_id WHERE D.dataset=?"
+	err := FilesDB.QueryRow(rebind(stmt), dataset).Scan(&info.Did, &createAt, &createBy)
+	if err == sql.ErrNoRows {
+		return info, fmt.Errorf("dataset %s is not found", dataset)
+	} else if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return info, err
+	}
+	info.CreateAt = createAt.Int64
+	info.CreateBy = createBy.String
+	info.setParts()
+	var size sql.NullInt64
+	stmt = "SELECT COUNT(F.file_id), SUM(F.size) FROM files F JOIN datasets D ON D.dataset_id=F.dataset_id WHERE D.dataset=? AND F.is_file_valid=1"
+	if err := FilesDB.QueryRow(rebind(stmt), dataset).Scan(&info.Files, &size); err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return info, err
+	}
+	info.Size = size.Int64
+	// every frame of a sequence counts as a file
+	var frames sql.NullInt64
+	stmt = "SELECT SUM(S.frames), SUM(S.size) FROM sequences S JOIN datasets D ON D.dataset_id=S.dataset_id WHERE D.dataset=? AND S.is_file_valid=1"
+	if err := FilesDB.QueryRow(rebind(stmt), dataset).Scan(&frames, &size); err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return info, err
+	}
+	info.Files += int(frames.Int64)
+	info.Size += size.Int64
+	return info, nil
+}
+
+// findFiles returns valid files of given dataset which match given glob
+// pattern along with total number of matching files, frames of sequences
+// are matched individually
+func findFiles(dataset, pattern string, idx, limit int) ([]FileInfo, int, error) {
+	var files []FileInfo
+	like := "%"
+	if pattern != "" {
+		like = globToLike(pattern)
+	}
+	seqs, err := datasetSequences(dataset)
+	if err != nil {
+		return files, 0, err
+	}
+	cond := "FROM files F JOIN datasets D ON D.dataset_id=F.dataset_id WHERE D.dataset=? AND F.is_file_valid=1 AND F.file LIKE ? ESCAPE '!'"
+	if len(seqs) > 0 {
+		return findFrames(seqs, cond, dataset, pattern, like, idx, limit)
+	}
+	var total int
+	stmt := "SELECT COUNT(*) " + cond
+	if err := FilesDB.QueryRow(rebind(stmt), dataset, like).Scan(&total); err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return files, 0, err
+	}
+	stmt = "SELECT F.file, F.size, F.mtime, F.checksum, F.entry_type, F.tier " + cond + " ORDER BY F.file LIMIT ? OFFSET ?"
+	res, err := FilesDB.Query(rebind(stmt), dataset, like, limit, idx)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return files, total, err
+	}
+	files, err = scanFileInfos(res)
+	return files, total, err
+}
+
+// helper function to find files of a dataset with sequences, the matching
+// files and frames are merged and paginated in order of their names. Frames
+// of a sequence are listed together at the position of its first frame and
+// only sequences within requested page are expanded.
+func findFrames(seqs []FileSequence, cond, dataset, pattern, like string, idx, limit int) ([]FileInfo, int, error) {
+	stmt := "SELECT F.file, F.size, F.mtime, F.checksum, F.entry_type, F.tier " + cond
+	res, err := FilesDB.Query(rebind(stmt), dataset, like)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return nil, 0, err
+	}
+	files, err := scanFileInfos(res)
+	if err != nil {
+		return nil, 0, err
+	}
+	if pattern == "" {
+		pattern = "*"
+	}
+	type entry struct {
+		Name  string
+		File  *FileInfo
+		Seq   *FileSequence
+		Count int64
+	}
+	var entries []entry
+	for i := range files {
+		entries = append(entries, entry{Name: files[i].Name, File: &files[i], Count: 1})
+	}
+	for i, s := range seqs {
+		if n, _ := s.MatchFrames(pattern, 0, 0); n > 0 {
+			entries = append(entries, entry{Name: s.FrameName(s.First), Seq: &seqs[i], Count: n})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	var total int64
+	for _, e := range entries {
+		total += e.Count
+	}
+	var out []FileInfo
+	first, end := int64(idx), int64(idx)+int64(limit)
+	var offset int64
+	for _, e := range entries {
+		if offset >= end {
+			break
+		}
+		if offset+e.Count > first {
+			if e.File != nil {
+				out = append(out, *e.File)
+			} else {
+				skip := first - offset
+				if skip < 0 {
+					skip = 0
+				}
+				_, frames := e.Seq.MatchFrames(pattern, skip, end-offset-skip)
+				out = append(out, frames...)
+			}
+		}
+		offset += e.Count
+	}
+	return out, int(total), nil
+}
+
+// helper function to write browsing results
+func browseResponse(w http.ResponseWriter, result any) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// DatasetsHandler provides paginated list of FilesDB datasets matching glob
+// filters, e.g. /datasets?dataset=/2022-3/3A/*/Ti* or /datasets?cycle=2022-*&beamline=3A
+func DatasetsHandler(w http.ResponseWriter, r *http.Request) {
+	pattern, err := datasetPattern(r)
+	if err != nil {
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	idx, limit, err := pageParams(r)
+	if err != nil {
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	datasets, total, err := findDatasets(pattern, idx, limit)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	browseResponse(w, Page{Datasets: datasets, Total: total, Idx: idx, Limit: limit})
+}
+
+// DatasetHandler provides single FilesDB dataset with its meta-data did and
+// number of files, e.g. /datasets/info?dataset=/2022-3/3A/123/sample
+func DatasetHandler(w http.ResponseWriter, r *http.Request) {
+	dataset := r.FormValue("dataset")
+	if datasetParts(dataset) == nil {
+		jsonResponse(w, fmt.Errorf("invalid dataset '%s'", dataset), http.StatusBadRequest)
+		return
+	}
+	if err := checkActiveDataset(r.Context(), dataset); err != nil {
+		jsonResponse(w, err, http.StatusNotFound)
+		return
+	}
+	info, err := getDatasetInfo(dataset)
+	if err != nil {
+		jsonResponse(w, err, http.StatusNotFound)
+		return
+	}
+	browseResponse(w, info)
+}
+
+// DatasetFilesHandler provides paginated list of dataset files matching
+// optional glob pattern, e.g. /datasets/files?dataset=/2022-3/3A/123/sample&path=*.tiff
+func DatasetFilesHandler(w http.ResponseWriter, r *http.Request) {
+	dataset := r.FormValue("dataset")
+	if datasetParts(dataset) == nil {
+		err := errors.New("no valid dataset found in HTTP request")
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	idx, limit, err := pageParams(r)
+	if err != nil {
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := checkActiveDataset(r.Context(), dataset); err != nil {
+		jsonResponse(w, err, http.StatusNotFound)
+		return
+	}
+	files, total, err := findFiles(dataset, r.FormValue("path"), idx, limit)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	browseResponse(w, Page{Files: files, Total: total, Idx: idx, Limit: limit})
+}
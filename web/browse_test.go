@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBrowse
+func TestBrowse(t *testing.T) {
+	initMetaDataService()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+
+	datasets := map[string]string{
+		"browse-did-1": "/browse_1/3A/btr/Ti-1",
+		"browse-did-2": "/browse_1/3B/btr/Al-1",
+		"browse-did-3": "/browseX1/3A/btr/Ti-2",
+	}
+	for did, dataset := range datasets {
+		// files are unique, therefore every dataset has its own directory
+		dir := t.TempDir()
+		for _, name := range []string{"a.tiff", "b.tiff", "c.txt"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := InsertFiles(did, dataset, dir, DiscoveryRules{}); err != nil {
+			t.Fatal(err)
+		}
+		defer deleteDID(did)
+	}
+
+	// underscore of dataset pattern is not a wildcard
+	dsets, total, err := findDatasets("/browse_1/*/*/*", 0, 10)
+	if err != nil || total != 2 || len(dsets) != 2 {
+		t.Errorf("wrong datasets %+v, total %d, error %v", dsets, total, err)
+	}
+	dsets, total, err = findDatasets("/browse*/3A/*/Ti*", 0, 1)
+	if err != nil || total != 2 || len(dsets) != 1 || dsets[0].Dataset != "/browseX1/3A/btr/Ti-2" || dsets[0].Did != "browse-did-3" {
+		t.Errorf("wrong page of datasets %+v, total %d, error %v", dsets, total, err)
+	}
+
+	var beamline string
+	stmt := "SELECT beamline FROM datasets WHERE dataset=?"
+	if err := FilesDB.QueryRow(stmt, "/browse_1/3B/btr/Al-1").Scan(&beamline); err != nil || beamline != "3B" {
+		t.Errorf("wrong dataset beamline %s, error %v", beamline, err)
+	}
+
+	// datasets via HTTP using parts of dataset
+	form := url.Values{"cycle": {"browse_1"}, "sample": {"Ti*"}}
+	rr, err := respRecorder("GET", "/datasets?"+form.Encode(), nil, DatasetsHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page Page
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil || page.Total != 1 || page.Datasets[0].Beamline != "3A" {
+		t.Errorf("wrong datasets page %+v, error %v", page, err)
+	}
+	form = url.Values{"dataset": {"/browse_1/3A"}}
+	if _, err := respRecorder("GET", "/datasets?"+form.Encode(), nil, DatasetsHandler); err == nil {
+		t.Error("no error for invalid dataset pattern")
+	}
+
+	// single dataset
+	info, err := getDatasetInfo("/browse_1/3A/btr/Ti-1")
+	if err != nil || info.Did != "browse-did-1" || info.Files != 4 || info.Size == 0 {
+		t.Errorf("wrong dataset info %+v, error %v", info, err)
+	}
+	form = url.Values{"dataset": {"/browse_1/3A/btr/none"}}
+	if _, err := respRecorder("GET", "/datasets/info?"+form.Encode(), nil, DatasetHandler); err == nil {
+		t.Error("no error for unknown dataset")
+	}
+
+	// files of dataset
+	form = url.Values{"dataset": {"/browse_1/3A/btr/Ti-1"}, "path": {"*.tiff"}, "limit": {"1"}}
+	rr, err = respRecorder("GET", "/datasets/files?"+form.Encode(), nil, DatasetFilesHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = Page{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil || page.Total != 2 || len(page.Files) != 1 {
+		t.Errorf("wrong files page %+v, error %v", page, err)
+	}
+}
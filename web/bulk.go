@@ -0,0 +1,159 @@
+package main
+
+// bulk module provides insertion of multiple meta-data records
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// BulkStatus represents status of single record of bulk insert
+type BulkStatus struct {
+	Index   int    `json:"index"`
+	Dataset string `json:"dataset,omitempty"`
+	Did     string `json:"did,omitempty"`
+	Status  string `json:"status"` // ok or failed
+	Error   string `json:"error,omitempty"`
+}
+
+// helper function to mark bulk status as failed
+func (s *BulkStatus) fail(err error) {
+	s.Status = "failed"
+	s.Error = err.Error()
+}
+
+// parseBulkRecords parses either JSON array or NDJSON (one JSON record per
+// line) data, it returns list of records along with their parsing errors
+func parseBulkRecords(data []byte) ([]Record, []error, error) {
+	var records []Record
+	var errs []error
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return records, errs, errors.New("no records provided")
+	}
+	if data[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return records, errs, err
+		}
+		for _, item := range items {
+			rec := make(Record)
+			err := json.Unmarshal(item, &rec)
+			records = append(records, rec)
+			errs = append(errs, err)
+		}
+		return records, errs, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rec := make(Record)
+		err := json.Unmarshal(line, &rec)
+		records = append(records, rec)
+		errs = append(errs, err)
+	}
+	return records, errs, scanner.Err()
+}
+
+// insertRecords validates and inserts given records of the schema, the files
+// of valid records are registered in FilesDB using batches of transactions
+// and records are written to meta-data store with bulk write. It returns
+// status of every record and an error if meta-data store can't be used
+func insertRecords(ctx context.Context, sname string, records []Record, perrs []error) ([]BulkStatus, error) {
+	statuses := make([]BulkStatus, len(records))
+	var entries []FilesEntry
+	var indexes []int
+	datasets := make(map[string]int)
+	for idx, rec := range records {
+		status := &statuses[idx]
+		status.Index = idx
+		status.Status = "ok"
+		if idx < len(perrs) && perrs[idx] != nil {
+			status.fail(perrs[idx])
+			continue
+		}
+		if _, err := prepareRecord(sname, rec); err != nil {
+			status.fail(err)
+			continue
+		}
+		status.Dataset = rec["dataset"].(string)
+		status.Did = rec["did"].(string)
+		if prev, ok := datasets[status.Dataset]; ok {
+			status.fail(fmt.Errorf("dataset %s is already used by record %d", status.Dataset, prev))
+			continue
+		}
+		parent, _, err := lineageKeys(rec)
+		if err != nil {
+			status.fail(err)
+			continue
+		}
+		// parent dataset may be registered by preceding record
+		if _, ok := datasets[parent]; parent != "" && !ok {
+			if err := checkParent(status.Dataset, parent); err != nil {
+				status.fail(err)
+				continue
+			}
+		}
+		datasets[status.Dataset] = idx
+		entries = append(entries, FilesEntry{Did: status.Did, Dataset: status.Dataset, Locations: recordLocations(rec), Rules: discoveryRules(rec)})
+		indexes = append(indexes, idx)
+	}
+
+	// register files of valid records
+	var valid []Record
+	var validIndexes []int
+	var events []Event
+	for i, err := range InsertFilesBatch(entries) {
+		idx := indexes[i]
+		if err != nil {
+			log.Printf("ERROR: unable to insert files of dataset %s, error %v", statuses[idx].Dataset, err)
+			statuses[idx].fail(err)
+			continue
+		}
+		if parent, processing, _ := lineageKeys(records[idx]); parent != "" || processing != "" {
+			if err := setLineage(statuses[idx].Dataset, parent, processing); err != nil {
+				log.Printf("ERROR: unable to set lineage of dataset %s, error %v", statuses[idx].Dataset, err)
+				statuses[idx].fail(err)
+				continue
+			}
+		}
+		valid = append(valid, records[idx])
+		validIndexes = append(validIndexes, idx)
+		evt := recordEvent(EventFilesRegistered, records[idx])
+		evt.Path, _ = records[idx]["path"].(string)
+		events = append(events, evt)
+	}
+
+	// write records into meta-data store, events are emitted only for
+	// records which are written
+	var berr *BulkError
+	err := upsertRecords(ctx, valid)
+	if err != nil && !errors.As(err, &berr) {
+		for _, idx := range validIndexes {
+			statuses[idx].fail(err)
+		}
+		return statuses, err
+	}
+	var written []Event
+	for i, idx := range validIndexes {
+		if err := recordError(err, i); err != nil {
+			statuses[idx].fail(err)
+			continue
+		}
+		written = append(written, events[i])
+	}
+	emitEvents(ctx, written...)
+	return statuses, nil
+}
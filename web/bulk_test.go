@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestParseBulkRecords
+func TestParseBulkRecords(t *testing.T) {
+	records, errs, err := parseBulkRecords([]byte(`[{"a": 1}, {"b": 2}]`))
+	if err != nil || len(records) != 2 || errs[0] != nil || errs[1] != nil {
+		t.Errorf("wrong parsing of JSON array, records %+v errors %v error %v", records, errs, err)
+	}
+	ndjson := "{\"a\": 1}\n\n{bad record}\n{\"c\": 3}\n"
+	records, errs, err = parseBulkRecords([]byte(ndjson))
+	if err != nil || len(records) != 3 {
+		t.Fatalf("wrong parsing of NDJSON, records %+v error %v", records, err)
+	}
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("wrong NDJSON parsing errors %v", errs)
+	}
+	if _, _, err := parseBulkRecords([]byte("  ")); err == nil {
+		t.Error("no error for empty input")
+	}
+}
+
+// TestBulkHandler
+func TestBulkHandler(t *testing.T) {
+	initMetaDataService()
+	var err error
+	FilesDB, err = InitFilesDB()
+	defer FilesDB.Close()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+
+	valid := `{"StringKey": "bulk","StrKeyMultipleValues": "3A","ListKey": ["3A"],"FloatKey": 1.1,"BoolKey": true}`
+	invalid := `{"StringKey": true}`
+	records := strings.Join([]string{valid, invalid, "{bad record}", valid}, "\n")
+	form := url.Values{}
+	form.Add("records", records)
+	form.Add("SchemaName", "test")
+	rr, err := respRecorder("POST", "/api/bulk", strings.NewReader(form.Encode()), BulkHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Inserted int          `json:"inserted"`
+		Failed   int          `json:"failed"`
+		Records  []BulkStatus `json:"records"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Inserted != 2 || resp.Failed != 2 || len(resp.Records) != 4 {
+		t.Fatalf("wrong bulk response %+v", resp)
+	}
+	for _, idx := range []int{0, 3} {
+		s := resp.Records[idx]
+		if s.Status != "ok" || s.Did == "" {
+			t.Errorf("record %d is not inserted %+v", idx, s)
+			continue
+		}
+		// files are shared with other tests, therefore we clean them up
+		defer deleteDID(s.Did)
+		// both records refer to the same directory whose files are
+		// registered once by the first record
+		files, err := getFiles(s.Did)
+		if err != nil || (idx == 0 && len(files) == 0) {
+			t.Errorf("no files registered for record %+v, error %v", s, err)
+		}
+	}
+	for _, idx := range []int{1, 2} {
+		if s := resp.Records[idx]; s.Status != "failed" || s.Error == "" {
+			t.Errorf("invalid record %d is not reported %+v", idx, s)
+		}
+	}
+}
+
+// helper store which fails upsert of the last of several meta-data records
+type failingStore struct {
+	MetadataStore
+}
+
+// Upsert implements MetadataStore interface
+func (s failingStore) Upsert(ctx context.Context, dbname, collname, attr string, records []Record) error {
+	if collname != Config.DBColl || len(records) < 2 {
+		return s.MetadataStore.Upsert(ctx, dbname, collname, attr, records)
+	}
+	last := len(records) - 1
+	if err := s.MetadataStore.Upsert(ctx, dbname, collname, attr, records[:last]); err != nil {
+		return err
+	}
+	return &BulkError{Errors: map[int]error{last: errors.New("write failure")}}
+}
+
+// TestBulkEvents
+func TestBulkEvents(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = failingStore{NewMemoryStore()}
+	defer func() { MetaStore = NewMemoryStore() }()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+
+	valid := `{"StringKey": "events","StrKeyMultipleValues": "3A","ListKey": ["3A"],"FloatKey": 1.1,"BoolKey": true}`
+	records, perrs, err := parseBulkRecords([]byte(valid + "\n" + valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := insertRecords(ctx, schemaFileName("test"), records, perrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.Did != "" {
+			defer deleteDID(s.Did)
+		}
+	}
+	if statuses[0].Status != "ok" || statuses[1].Status != "failed" {
+		t.Fatalf("wrong statuses %+v", statuses)
+	}
+	// files are registered only for records which are written
+	events, err := getEvents(ctx, 0, []string{EventFilesRegistered}, 0)
+	if err != nil || len(events) != 1 || events[0].Did != statuses[0].Did {
+		t.Errorf("wrong events %+v, error %v", events, err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+// bulkfiles module provides high-throughput registration of dataset files:
+// checksums are computed by pool of workers and files are inserted into
+// FilesDB via prepared multi-row insert statements
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WalkWorkers defines default number of concurrent directory walkers and
+// checksum workers
+var WalkWorkers = 8
+
+// InsertChunkSize defines default number of rows of multi-row insert
+var InsertChunkSize = 1000
+
+// fileColumns lists columns of files table set by multi-row insert
+var fileColumns = []string{"file", "size", "mtime", "checksum", "entry_type", "tier", "meta_id", "dataset_id", "create_at", "create_by", "modify_at", "modify_by"}
+
+// helper function to get number of walk workers
+func walkWorkers() int {
+	if Config.WalkWorkers > 0 {
+		return Config.WalkWorkers
+	}
+	return WalkWorkers
+}
+
+// helper function to get number of rows of multi-row insert of given
+// number of columns, the number of rows is limited by maximal number of
+// placeholders of single statement supported by FilesDB driver
+func insertChunkSize(ncols int) int {
+	rows := InsertChunkSize
+	if Config.InsertChunkSize > 0 {
+		rows = Config.InsertChunkSize
+	}
+	// SQLite supports 32766 host parameters since 3.32, MySQL and
+	// PostgreSQL protocols limit number of parameters to 65535
+	limit := 65535
+	if filesDBDriver() == "sqlite3" {
+		limit = 32766
+	}
+	if rows*ncols > limit {
+		rows = limit / ncols
+	}
+	return rows
+}
+
+// insertIgnore builds multi-row insert statement of given number of rows
+// which skips rows duplicating unique key of the table, any other violation
+// of constraints fails the statement. The id column is primary key of the
+// table required by MySQL syntax.
+func insertIgnore(table, key, id string, columns []string, nrows int) string {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+	values := strings.TrimSuffix(strings.Repeat(row+",", nrows), ",")
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ","), values)
+	if filesDBDriver() == "mysql" {
+		stmt += fmt.Sprintf(" ON DUPLICATE KEY UPDATE %s=%s", id, id)
+	} else {
+		stmt += fmt.Sprintf(" ON CONFLICT(%s) DO NOTHING", key)
+	}
+	return rebind(stmt)
+}
+
+// helper function to get multi-row insert statement of given number of files
+func insertFilesStmt(nrows int) string {
+	return insertIgnore("files", "file", "file_id", fileColumns, nrows)
+}
+
+// prepareFilesBulk prepares multi-row insert statement of full chunk of
+// files, the statement is reused by insertFilesBulk of all transactions of
+// the registration
+func prepareFilesBulk() (*sql.Stmt, error) {
+	stmt := insertFilesStmt(insertChunkSize(len(fileColumns)))
+	full, err := FilesDB.Prepare(stmt)
+	if err != nil {
+		log.Printf("ERROR: unable to prepare multi-row insert of files, error=%v", err)
+	}
+	return full, err
+}
+
+// insertFilesBulk inserts files within transaction using multi-row insert
+// statements, full chunks are inserted by given statement prepared via
+// prepareFilesBulk, or by statement prepared within transaction if it is
+// nil. Files which are already registered are skipped. It returns number of
+// inserted files.
+func insertFilesBulk(tx *sql.Tx, full *sql.Stmt, files []FileInfo, metaId, datasetId int64) (int, error) {
+	create_at := time.Now().Unix()
+	create_by := "MetaData server"
+	chunk := insertChunkSize(len(fileColumns))
+	var stmt *sql.Stmt
+	defer func() {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}()
+	var inserted int
+	for idx := 0; idx < len(files); idx += chunk {
+		end := idx + chunk
+		if end > len(files) {
+			end = len(files)
+		}
+		args := make([]any, 0, (end-idx)*len(fileColumns))
+		for _, f := range files[idx:end] {
+			tier := f.Tier
+			if tier == "" {
+				tier = TierRaw
+			}
+			args = append(args, f.Name, f.Size, f.Mtime, f.Checksum, f.Type, tier, metaId, datasetId, create_at, create_by, create_at, create_by)
+		}
+		var res sql.Result
+		var err error
+		if end-idx == chunk {
+			if stmt == nil {
+				if full != nil {
+					stmt = tx.Stmt(full)
+				} else if stmt, err = tx.Prepare(insertFilesStmt(chunk)); err != nil {
+					log.Printf("ERROR: unable to prepare multi-row insert of %d files, error=%v", chunk, err)
+					return inserted, err
+				}
+			}
+			res, err = stmt.Exec(args...)
+		} else {
+			res, err = tx.Exec(insertFilesStmt(end-idx), args...)
+		}
+		if err != nil {
+			log.Printf("ERROR: unable to insert %d files with meta_id=%v, error=%v", end-idx, metaId, err)
+			return inserted, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return inserted, err
+		}
+		inserted += int(n)
+	}
+	return inserted, nil
+}
+
+// setChecksums computes checksums of given files by pool of walk workers
+func setChecksums(files []FileInfo) {
+	if checksumType() == "none" {
+		return
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < walkWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				files[idx].setChecksum()
+			}
+		}()
+	}
+	for idx := range files {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// RegistrationStats represents throughput metrics of file registration
+type RegistrationStats struct {
+	Files        int     `json:"files"`         // number of found files
+	Registered   int     `json:"registered"`    // number of registered files
+	Skipped      int     `json:"skipped"`       // number of files which are already registered
+	WalkTime     float64 `json:"walk_time"`     // time in seconds spent walking directories
+	ChecksumTime float64 `json:"checksum_time"` // time in seconds spent computing checksums
+	InsertTime   float64 `json:"insert_time"`   // time in seconds spent inserting files
+	Rate         float64 `json:"rate"`          // number of processed files per second
+}
+
+// helper function to add elapsed time since start to one of the timers
+// and update the rate
+func (s *RegistrationStats) measure(timer *float64, start time.Time) {
+	*timer += time.Since(start).Seconds()
+	s.setRate()
+}
+
+// helper function to add metrics of another stage of registration
+func (s *RegistrationStats) add(o RegistrationStats) {
+	s.Registered += o.Registered
+	s.Skipped += o.Skipped
+	s.WalkTime += o.WalkTime
+	s.ChecksumTime += o.ChecksumTime
+	s.InsertTime += o.InsertTime
+	s.setRate()
+}
+
+// helper function to compute rate of processed files
+func (s *RegistrationStats) setRate() {
+	elapsed := s.WalkTime + s.ChecksumTime + s.InsertTime
+	if elapsed > 0 {
+		s.Rate = float64(s.Registered+s.Skipped) / elapsed
+	}
+}
+
+// String returns summary of the metrics
+func (s RegistrationStats) String() string {
+	return fmt.Sprintf("files=%d registered=%d skipped=%d walk=%.3fs checksum=%.3fs insert=%.3fs rate=%.1f files/s",
+		s.Files, s.Registered, s.Skipped, s.WalkTime, s.ChecksumTime, s.InsertTime, s.Rate)
+}
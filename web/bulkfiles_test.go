@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBulkFiles
+func TestBulkFiles(t *testing.T) {
+	initMetaDataService()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+	chunkSize := Config.InsertChunkSize
+	workers := Config.WalkWorkers
+	defer func() {
+		Config.InsertChunkSize = chunkSize
+		Config.WalkWorkers = workers
+	}()
+
+	// chunks are limited by number of placeholders of the driver
+	Config.InsertChunkSize = 100000
+	if n := insertChunkSize(len(fileColumns)); n*len(fileColumns) > 32766 {
+		t.Errorf("wrong chunk size %d", n)
+	}
+	stmt := insertIgnore("files", "file", "file_id", []string{"file", "size"}, 2)
+	if stmt != "INSERT INTO files (file,size) VALUES (?,?),(?,?) ON CONFLICT(file) DO NOTHING" {
+		t.Errorf("wrong statement %s", stmt)
+	}
+
+	// nested directories are walked concurrently
+	Config.InsertChunkSize = 3
+	Config.WalkWorkers = 3
+	dir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("d%d", i), "sub")
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a.h5", "b.txt"} {
+			if err := os.WriteFile(filepath.Join(sub, name), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	files, err := DiscoverFiles(dir, DiscoveryRules{}, false)
+	if err != nil || len(files) != 17 {
+		t.Fatalf("wrong discovered files %+v, error %v", files, err)
+	}
+	for i := 1; i < len(files); i++ {
+		if files[i-1].Name >= files[i].Name {
+			t.Errorf("files are not ordered %s %s", files[i-1].Name, files[i].Name)
+		}
+	}
+	if _, err := DiscoverFiles(dir, DiscoveryRules{MaxFiles: 5}, false); err == nil {
+		t.Error("no error for too many entries")
+	}
+
+	// files are inserted in chunks and registered files are skipped, the
+	// directories are registered by the batch
+	did := "bulk-files-did"
+	dataset := "/bulk/files"
+	locations := []DataLocation{{Tier: TierRaw, Path: dir}}
+	stats, err := RegisterLocations(did, dataset, locations, DiscoveryRules{SkipDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+	if stats.Files != 8 || stats.Registered != 8 || stats.Skipped != 0 || stats.Rate <= 0 {
+		t.Errorf("wrong registration stats %+v", stats)
+	}
+	if !strings.Contains(stats.String(), "registered=8") {
+		t.Errorf("wrong stats summary %s", stats)
+	}
+	infos, err := getFileInfos(did, "", false)
+	if err != nil || len(infos) != 8 || !strings.HasPrefix(infos[0].Checksum, "adler32:") {
+		t.Errorf("wrong registered files %+v, error %v", infos, err)
+	}
+	batch, err := registerBatch(did, nil, files)
+	if err != nil || batch.Registered != 9 || batch.Skipped != 8 {
+		t.Errorf("wrong batch stats %+v, error %v", batch, err)
+	}
+
+	// statement prepared once is reused by transactions of several batches
+	var names []FileInfo
+	for i := 0; i < 7; i++ {
+		names = append(names, FileInfo{Name: fmt.Sprintf("/bulk/batch/file%d", i), Type: EntryFile, Tier: TierRaw})
+	}
+	full, err := prepareFilesBulk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer full.Close()
+	var registered int
+	for _, files := range [][]FileInfo{names[:4], names[2:]} {
+		batch, err := registerBatch(did, full, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registered += batch.Registered
+	}
+	var nfiles int
+	err = FilesDB.QueryRow("SELECT COUNT(*) FROM files WHERE file LIKE '/bulk/batch/%'").Scan(&nfiles)
+	if err != nil || registered != 7 || nfiles != 7 {
+		t.Errorf("wrong number of registered files %d, number of rows %d, error %v", registered, nfiles, err)
+	}
+
+	// only duplicate files are skipped, other violations fail the insert
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	stmt = insertIgnore("files", "file", "file_id", []string{"file"}, 2)
+	if _, err := tx.Exec(stmt, "/bulk/batch/file0", nil); err == nil {
+		t.Error("NOT NULL violation is ignored")
+	}
+}
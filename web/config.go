@@ -16,10 +16,12 @@ import (
 // Configuration stores server configuration parameters
 type Configuration struct {
 	Port                int                 `json:"port"`                // server port number
-	URI                 string              `json:"uri"`                 // server mongodb URI
+	URI                 string              `json:"uri"`                 // server mongodb URI, use memory:// for in-memory store
 	Base                string              `json:"base"`                // base path
 	DBName              string              `json:"dbname"`              // mongo db name
 	DBColl              string              `json:"dbcoll"`              // mongo db name
+	HistoryColl         string              `json:"historyColl"`         // mongo db collection for records history
+	MongoTimeout        int                 `json:"mongoTimeout"`        // timeout of mongo db operations in seconds
 	FilesDBUri          string              `json:"filesdburi"`          // server FilesDB URI
 	Templates           string              `json:"templates"`           // location of server templates
 	Jscripts            string              `json:"jscripts"`            // location of server JavaScript files
@@ -39,6 +41,24 @@ type Configuration struct {
 	SchemaRenewInterval int                 `json:"schemaRenewInterval"` // schema renew interval
 	SchemaSections      []string            `json:"schemaSections"`      // logical schema section list
 	WebSectionKeys      map[string][]string `json:"webSectionKeys"`      // section order dict
+	Admins              []string            `json:"admins"`              // list of admin users
+	StatsKeys           []string            `json:"statsKeys"`           // default group keys of statistics
+	StatsInterval       int                 `json:"statsInterval"`       // statistics refresh interval in seconds
+	EventsColl          string              `json:"eventsColl"`          // mongo db collection for events
+	DeliveriesColl      string              `json:"deliveriesColl"`      // mongo db collection for webhook delivery log
+	Webhooks            []Webhook           `json:"webhooks"`            // list of webhooks to deliver events to
+	WebhookRetries      int                 `json:"webhookRetries"`      // number of webhook delivery attempts
+	Checksum            string              `json:"checksum"`            // checksum of registered files: adler32 (default), sha256 or none
+	RescanInterval      int                 `json:"rescanInterval"`      // interval in seconds of periodic rescan of datasets, 0 disables it
+	MigrateFilesDB      bool                `json:"migrateFilesDB"`      // apply pending FilesDB migrations at startup
+	JobsColl            string              `json:"jobsColl"`            // mongo db collection for file registration jobs
+	JobWorkers          int                 `json:"jobWorkers"`          // number of workers which register dataset files
+	Discovery           DiscoveryConfig     `json:"discovery"`           // rules of file discovery per beamline or schema
+	Site                string              `json:"site"`                // name of site where files are registered, local by default
+	Sites               map[string]Site     `json:"sites"`               // sites of file replicas with their access URL templates
+	SequenceMinFiles    int                 `json:"sequenceMinFiles"`    // minimal number of numbered files stored as sequence, sequences are disabled by default
+	WalkWorkers         int                 `json:"walkWorkers"`         // number of concurrent directory walkers and checksum workers, 8 by default
+	InsertChunkSize     int                 `json:"insertChunkSize"`     // number of rows of multi-row insert of files, 1000 by default
 }
 
 // Config variable represents configuration object
@@ -52,6 +72,13 @@ func (c *Configuration) String() string {
 	if len(dbAttrs) > 1 {
 		cc.FilesDBUri = dbAttrs[1]
 	}
+	cc.Webhooks = nil
+	for _, hook := range c.Webhooks {
+		if hook.Secret != "" {
+			hook.Secret = "****"
+		}
+		cc.Webhooks = append(cc.Webhooks, hook)
+	}
 	data, _ := json.MarshalIndent(cc, "", "    ")
 	return fmt.Sprintf(string(data))
 }
@@ -66,9 +93,27 @@ func ParseConfig(configFile string) {
 	if err != nil {
 		log.Fatalf("Unable to parse logfile %s, error %v", configFile, err)
 	}
+	if Config.HistoryColl == "" {
+		Config.HistoryColl = fmt.Sprintf("%s_history", Config.DBColl)
+	}
+	if Config.EventsColl == "" {
+		Config.EventsColl = fmt.Sprintf("%s_events", Config.DBColl)
+	}
+	if Config.DeliveriesColl == "" {
+		Config.DeliveriesColl = fmt.Sprintf("%s_deliveries", Config.DBColl)
+	}
+	if Config.JobsColl == "" {
+		Config.JobsColl = fmt.Sprintf("%s_jobs", Config.DBColl)
+	}
 	if Config.SchemaRenewInterval == 0 {
 		Config.SchemaRenewInterval = 600
 	}
+	if !InList(checksumType(), []string{"adler32", "sha256", "none"}) {
+		log.Fatalf("Unsupported checksum type %s, should be adler32, sha256 or none", Config.Checksum)
+	}
+	if err := Config.Discovery.Validate(); err != nil {
+		log.Fatalf("Invalid discovery rules, error %v", err)
+	}
 	SchemaRenewInterval = time.Duration(Config.SchemaRenewInterval) * time.Second
 }
 
@@ -0,0 +1,268 @@
+package main
+
+// consistency module cross-checks meta-data records of MongoDB with FilesDB
+// datasets and optionally repairs found problems
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// types of consistency problems
+const (
+	ProblemOrphanDataset   = "orphan_dataset"   // FilesDB dataset without meta-data record
+	ProblemOrphanRecord    = "orphan_record"    // meta-data record without FilesDB dataset
+	ProblemDuplicateDid    = "duplicate_did"    // several meta-data records with the same did
+	ProblemMissingPath     = "missing_path"     // record path does not exist on disk
+	ProblemDatasetMismatch = "dataset_mismatch" // record and FilesDB datasets of did differ
+)
+
+// Problem represents single inconsistency between MongoDB and FilesDB
+type Problem struct {
+	Type     string   `json:"type"`
+	Did      string   `json:"did"`
+	Dataset  string   `json:"dataset,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Records  []string `json:"records,omitempty"` // ids of meta-data records
+	Detail   string   `json:"detail"`
+	Repair   string   `json:"repair,omitempty"` // repair action, empty if problem can't be repaired
+	Repaired bool     `json:"repaired"`
+	Error    string   `json:"error,omitempty"`
+
+	record Record // meta-data record whose files are registered on repair
+}
+
+// String returns single line representation of the problem
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s did=%s %s", p.Type, p.Did, p.Detail)
+	if p.Repair != "" {
+		s += fmt.Sprintf(", repair: %s", p.Repair)
+		if p.Repaired {
+			s += " (done)"
+		}
+	}
+	if p.Error != "" {
+		s += fmt.Sprintf(", error: %s", p.Error)
+	}
+	return s
+}
+
+// ConsistencyReport represents result of consistency check
+type ConsistencyReport struct {
+	Records   int       `json:"records"`  // number of meta-data records with did
+	Datasets  int       `json:"datasets"` // number of FilesDB dids
+	Problems  []Problem `json:"problems"`
+	DryRun    bool      `json:"dry_run"`
+	Timestamp int64     `json:"timestamp"`
+	Elapsed   string    `json:"elapsed"`
+}
+
+// String returns report in human readable form
+func (r ConsistencyReport) String() string {
+	var lines []string
+	for _, p := range r.Problems {
+		lines = append(lines, p.String())
+	}
+	lines = append(lines, fmt.Sprintf("checked %d records and %d FilesDB dids, found %d problems, dry-run %v, elapsed time %s",
+		r.Records, r.Datasets, len(r.Problems), r.DryRun, r.Elapsed))
+	return strings.Join(lines, "\n")
+}
+
+// helper function to get FilesDB datasets of all dids, dids registered
+// without dataset have empty dataset
+func filesDBDatasets() (map[string]string, error) {
+	out := make(map[string]string)
+	stmt := "SELECT M.did, D.dataset FROM metadata M LEFT JOIN datasets D ON D.meta_id=M.meta_id"
+	res, err := FilesDB.Query(stmt)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return out, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var did string
+		var dataset sql.NullString
+		if err := res.Scan(&did, &dataset); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return out, err
+		}
+		out[did] = dataset.String
+	}
+	return out, res.Err()
+}
+
+// helper function to get meta-data records of all dids, including deleted
+// records which still own their FilesDB datasets
+func didRecords(ctx context.Context) (map[string][]Record, int, error) {
+	out := make(map[string][]Record)
+	var nrec int
+	spec := bson.M{"did": bson.M{"$exists": true}}
+	for idx := 0; ; idx += RescanBatchSize {
+		records, err := GetSorted(ctx, Config.DBName, Config.DBColl, spec, []string{"_id"}, idx, RescanBatchSize)
+		if err != nil {
+			return out, nrec, err
+		}
+		for _, rec := range records {
+			did := fmt.Sprintf("%v", rec["did"])
+			out[did] = append(out[did], rec)
+			nrec++
+		}
+		if len(records) < RescanBatchSize {
+			break
+		}
+	}
+	return out, nrec, nil
+}
+
+// checkConsistency cross-checks meta-data records with FilesDB datasets
+func checkConsistency(ctx context.Context) (ConsistencyReport, error) {
+	time0 := time.Now()
+	report := ConsistencyReport{DryRun: true, Timestamp: time0.Unix()}
+	datasets, err := filesDBDatasets()
+	if err != nil {
+		return report, err
+	}
+	records, nrec, err := didRecords(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Records = nrec
+	report.Datasets = len(datasets)
+
+	// FilesDB datasets without meta-data records
+	for _, did := range SortedKeys(datasets) {
+		if _, ok := records[did]; !ok {
+			report.Problems = append(report.Problems, Problem{
+				Type:    ProblemOrphanDataset,
+				Did:     did,
+				Dataset: datasets[did],
+				Detail:  fmt.Sprintf("FilesDB dataset %s has no meta-data record", datasets[did]),
+				Repair:  "delete FilesDB dataset and its files",
+			})
+		}
+	}
+
+	for _, did := range SortedKeys(records) {
+		recs := records[did]
+		rec := recs[0]
+		var ids []string
+		for _, r := range recs {
+			ids = append(ids, recordID(r))
+		}
+		dataset := fmt.Sprintf("%v", rec["dataset"])
+		path, _ := rec["path"].(string)
+		if len(recs) > 1 {
+			report.Problems = append(report.Problems, Problem{
+				Type:    ProblemDuplicateDid,
+				Did:     did,
+				Records: ids,
+				Detail:  fmt.Sprintf("%d meta-data records share the did", len(recs)),
+			})
+			continue
+		}
+		fdataset, ok := datasets[did]
+		if !ok {
+			p := Problem{
+				Type:    ProblemOrphanRecord,
+				Did:     did,
+				Dataset: dataset,
+				Path:    path,
+				Records: ids,
+				Detail:  fmt.Sprintf("meta-data record of dataset %s has no FilesDB dataset", dataset),
+				record:  rec,
+			}
+			if _, err := os.Stat(path); path != "" && err == nil && !isDeleted(rec) {
+				p.Repair = fmt.Sprintf("register files of %s", path)
+			}
+			report.Problems = append(report.Problems, p)
+			continue
+		}
+		if fdataset != dataset {
+			report.Problems = append(report.Problems, Problem{
+				Type:    ProblemDatasetMismatch,
+				Did:     did,
+				Dataset: dataset,
+				Records: ids,
+				Detail:  fmt.Sprintf("meta-data record dataset %s differs from FilesDB dataset %s", dataset, fdataset),
+				Repair:  fmt.Sprintf("rename FilesDB dataset %s to %s", fdataset, dataset),
+			})
+		}
+		// we do not check paths of deleted records
+		if isDeleted(rec) {
+			continue
+		}
+		if _, err := os.Stat(path); path == "" || err != nil {
+			report.Problems = append(report.Problems, Problem{
+				Type:    ProblemMissingPath,
+				Did:     did,
+				Dataset: dataset,
+				Path:    path,
+				Records: ids,
+				Detail:  fmt.Sprintf("path '%s' of dataset %s does not exist", path, dataset),
+			})
+		}
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		return report.Problems[i].Type < report.Problems[j].Type
+	})
+	report.Elapsed = time.Since(time0).String()
+	return report, nil
+}
+
+// helper function to rename FilesDB dataset of given did
+func renameDataset(did, dataset string) error {
+	cycle, beamline, btr, sample := datasetColumns(dataset)
+	stmt := "UPDATE datasets SET dataset=?,cycle=?,beamline=?,btr=?,sample=?,modify_at=?,modify_by=? WHERE meta_id IN (SELECT meta_id FROM metadata WHERE did=?)"
+	_, err := FilesDB.Exec(rebind(stmt), dataset, cycle, beamline, btr, sample, time.Now().Unix(), "MetaData server", did)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+	}
+	return err
+}
+
+// helper function to repair single problem
+func repairProblem(p Problem) error {
+	switch p.Type {
+	case ProblemOrphanDataset:
+		return deleteDID(p.Did)
+	case ProblemOrphanRecord:
+		return InsertLocations(p.Did, p.Dataset, recordLocations(p.record), discoveryRules(p.record))
+	case ProblemDatasetMismatch:
+		return renameDataset(p.Did, p.Dataset)
+	}
+	return fmt.Errorf("problem %s can't be repaired", p.Type)
+}
+
+// repairConsistency checks consistency of MongoDB and FilesDB and repairs
+// found problems, in dry-run mode it only reports repair actions
+func repairConsistency(ctx context.Context, dryRun bool) (ConsistencyReport, error) {
+	report, err := checkConsistency(ctx)
+	if err != nil || dryRun {
+		return report, err
+	}
+	report.DryRun = false
+	for i, p := range report.Problems {
+		if p.Repair == "" {
+			continue
+		}
+		if err := repairProblem(p); err != nil {
+			log.Printf("ERROR: unable to repair %s, error %v", p.String(), err)
+			report.Problems[i].Error = err.Error()
+			continue
+		}
+		log.Printf("repaired %s", p.String())
+		report.Problems[i].Repaired = true
+	}
+	return report, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// TestConsistency
+func TestConsistency(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+
+	// use dedicated FilesDB to not repair entries of other tests
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "files.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := MigrateFilesDB(db, true); err != nil {
+		t.Fatal(err)
+	}
+	filesDB := FilesDB
+	FilesDB = db
+	defer func() { FilesDB = filesDB }()
+
+	register := func(did, dataset string) string {
+		dir := t.TempDir()
+		if err := InsertFiles(did, dataset, dir, DiscoveryRules{}); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	var records []Record
+	dir := register("ok", "/c/b/t/ok")
+	records = append(records, Record{"did": "ok", "dataset": "/c/b/t/ok", "path": dir})
+	register("orphan", "/c/b/t/orphan")
+	records = append(records, Record{"did": "record", "dataset": "/c/b/t/record", "path": t.TempDir()})
+	records = append(records, Record{"did": "dup", "dataset": "/c/b/t/dup1", "path": t.TempDir()})
+	records = append(records, Record{"did": "dup", "dataset": "/c/b/t/dup2", "path": t.TempDir()})
+	dir = register("mismatch", "/c/b/t/old")
+	records = append(records, Record{"did": "mismatch", "dataset": "/c/b/t/new", "path": dir})
+	register("missing", "/c/b/t/missing")
+	records = append(records, Record{"did": "missing", "dataset": "/c/b/t/missing", "path": "/nonexistent/path"})
+	if err := Insert(ctx, Config.DBName, Config.DBColl, records); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := func(report ConsistencyReport) map[string]string {
+		out := make(map[string]string)
+		for _, p := range report.Problems {
+			out[p.Did] = p.Type
+		}
+		return out
+	}
+	expect := map[string]string{
+		"orphan":   ProblemOrphanDataset,
+		"record":   ProblemOrphanRecord,
+		"dup":      ProblemDuplicateDid,
+		"mismatch": ProblemDatasetMismatch,
+		"missing":  ProblemMissingPath,
+	}
+	report, err := checkConsistency(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := problems(report); len(found) != len(expect) {
+		t.Errorf("wrong problems %+v", report.Problems)
+	} else {
+		for did, ptype := range expect {
+			if found[did] != ptype {
+				t.Errorf("wrong problem of did %s: %s, expect %s", did, found[did], ptype)
+			}
+		}
+	}
+
+	// dry-run does not change anything
+	report, err = repairConsistency(ctx, true)
+	if err != nil || !report.DryRun || len(report.Problems) != len(expect) {
+		t.Errorf("wrong dry-run report %+v, error %v", report, err)
+	}
+	for _, p := range report.Problems {
+		if p.Repaired {
+			t.Errorf("problem is repaired in dry-run mode %+v", p)
+		}
+	}
+	report, err = checkConsistency(ctx)
+	if err != nil || len(report.Problems) != len(expect) {
+		t.Errorf("dry-run changes databases %+v, error %v", report, err)
+	}
+
+	// repair leaves only problems which can't be repaired
+	if _, err := repairConsistency(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	report, err = checkConsistency(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := problems(report); len(found) != 2 || found["dup"] != ProblemDuplicateDid || found["missing"] != ProblemMissingPath {
+		t.Errorf("wrong problems after repair %+v", report.Problems)
+	}
+	if exists, _ := datasetExists("/c/b/t/new"); !exists {
+		t.Error("FilesDB dataset is not renamed")
+	}
+
+	// only admins may check consistency
+	form := url.Values{"dryrun": {"false"}}
+	if _, err := respRecorder("POST", "/admin/consistency?"+form.Encode(), nil, ConsistencyHandler); err == nil {
+		t.Error("no error for consistency check by non admin user")
+	}
+}
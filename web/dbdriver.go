@@ -0,0 +1,109 @@
+package main
+
+// dbdriver module provides helpers to deal with differences of FilesDB
+// drivers, e.g. placeholders and errors of SQL statements
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// helper function to check if FilesDB is PostgreSQL database
+func isPostgres() bool {
+	return filesDBDriver() == "postgres"
+}
+
+// rebind converts ? placeholders of SQL statement into placeholders of
+// FilesDB driver, e.g. PostgreSQL uses $1, $2, etc.
+func rebind(stmt string) string {
+	if !isPostgres() {
+		return stmt
+	}
+	var sb strings.Builder
+	idx := 0
+	for _, c := range stmt {
+		if c == '?' {
+			idx++
+			sb.WriteString(fmt.Sprintf("$%d", idx))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// dataSourceName returns data source name of FilesDB driver. PostgreSQL
+// driver expects full connection URL. SQLite transactions acquire write lock
+// when they begin and wait for other writers, otherwise concurrent
+// transactions which read before they write fail with database is locked error.
+func dataSourceName(driver, dsn string) string {
+	switch driver {
+	case "postgres":
+		return Config.FilesDBUri
+	case "sqlite3":
+		var opts []string
+		if !strings.Contains(dsn, "_txlock") {
+			opts = append(opts, "_txlock=immediate")
+		}
+		if !strings.Contains(dsn, "_busy_timeout") {
+			opts = append(opts, "_busy_timeout=30000")
+		}
+		if len(opts) == 0 {
+			return dsn
+		}
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + strings.Join(opts, "&")
+	}
+	return dsn
+}
+
+// isUniqueViolation checks if error is caused by violation of unique constraint
+func isUniqueViolation(err error) bool {
+	var serr sqlite3.Error
+	if errors.As(err, &serr) {
+		return serr.ExtendedCode == sqlite3.ErrConstraintUnique || serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var merr *mysql.MySQLError
+	if errors.As(err, &merr) {
+		return merr.Number == 1062 // ER_DUP_ENTRY
+	}
+	var perr *pq.Error
+	if errors.As(err, &perr) {
+		return perr.Code == "23505" // unique_violation
+	}
+	return false
+}
+
+// insertUnique executes insert statement within transaction and returns
+// false if the row violates unique constraint. PostgreSQL aborts transaction
+// on any error, therefore conflicting rows are skipped by the statement itself.
+func insertUnique(tx *sql.Tx, stmt string, args ...any) (bool, error) {
+	if isPostgres() {
+		res, err := tx.Exec(rebind(stmt+" ON CONFLICT DO NOTHING"), args...)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	}
+	_, err := tx.Exec(rebind(stmt), args...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,373 @@
+package main
+
+// discovery module defines rules which select files of dataset directory
+// registered in FilesDB, the rules are configured per beamline or schema
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// symlink policies of discovery rules
+const (
+	SymlinksRegister = "register" // register symlink itself without following it
+	SymlinksFollow   = "follow"   // register symlink target and walk linked directories
+	SymlinksSkip     = "skip"     // ignore symlinks
+)
+
+// DiscoveryRules defines which entries of dataset directory are registered,
+// zero value registers all entries of the directory
+type DiscoveryRules struct {
+	Source     string   `json:"source,omitempty"`     // origin of the rules, e.g. beamline:3A, schema:ID3A or default
+	Include    []string `json:"include,omitempty"`    // globs of file names or relative paths to register, all files if empty
+	Exclude    []string `json:"exclude,omitempty"`    // globs of file or directory names or relative paths to skip
+	MaxDepth   int      `json:"maxDepth,omitempty"`   // maximum depth of sub-directories to walk, 0 means no limit
+	Symlinks   string   `json:"symlinks,omitempty"`   // symlink policy: register (default), follow or skip
+	SkipHidden bool     `json:"skipHidden,omitempty"` // skip hidden files and directories
+	SkipDirs   bool     `json:"skipDirs,omitempty"`   // do not register directory entries
+	MaxFiles   int      `json:"maxFiles,omitempty"`   // maximum number of registered entries, 0 means no limit
+}
+
+// DiscoveryConfig represents discovery rules of server configuration, rules
+// of record beamline take precedence over rules of its schema
+type DiscoveryConfig struct {
+	Default   DiscoveryRules            `json:"default"`   // rules used when no other rules apply
+	Schemas   map[string]DiscoveryRules `json:"schemas"`   // rules per schema name
+	Beamlines map[string]DiscoveryRules `json:"beamlines"` // rules per beamline
+}
+
+// String returns JSON representation of the rules
+func (r DiscoveryRules) String() string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
+
+// Validate checks glob patterns and policies of the rules
+func (r DiscoveryRules) Validate() error {
+	for _, pat := range append(append([]string{}, r.Include...), r.Exclude...) {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("invalid discovery pattern '%s', error %v", pat, err)
+		}
+	}
+	if r.Symlinks != "" && !InList(r.Symlinks, []string{SymlinksRegister, SymlinksFollow, SymlinksSkip}) {
+		return fmt.Errorf("unsupported symlinks policy '%s', should be register, follow or skip", r.Symlinks)
+	}
+	if r.MaxDepth < 0 || r.MaxFiles < 0 {
+		return fmt.Errorf("discovery maxDepth and maxFiles should not be negative")
+	}
+	return nil
+}
+
+// Validate checks all discovery rules of the configuration
+func (c DiscoveryConfig) Validate() error {
+	if err := c.Default.Validate(); err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+	for key, rules := range c.Schemas {
+		if err := rules.Validate(); err != nil {
+			return fmt.Errorf("schema %s: %v", key, err)
+		}
+	}
+	for key, rules := range c.Beamlines {
+		if err := rules.Validate(); err != nil {
+			return fmt.Errorf("beamline %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// helper function to get beamlines of the record
+func recordBeamlines(rec Record) []string {
+	switch b := rec["Beamline"].(type) {
+	case string:
+		return []string{b}
+	case []string:
+		return b
+	case []any:
+		var out []string
+		for _, v := range b {
+			out = append(out, fmt.Sprintf("%v", v))
+		}
+		return out
+	}
+	return nil
+}
+
+// discoveryRules returns discovery rules of given record, the rules of its
+// first configured beamline are used, then rules of its schema and finally
+// default rules
+func discoveryRules(rec Record) DiscoveryRules {
+	for _, beamline := range recordBeamlines(rec) {
+		if rules, ok := Config.Discovery.Beamlines[beamline]; ok {
+			rules.Source = "beamline:" + beamline
+			return rules
+		}
+	}
+	if sname, ok := rec["Schema"].(string); ok {
+		if rules, ok := Config.Discovery.Schemas[sname]; ok {
+			rules.Source = "schema:" + sname
+			return rules
+		}
+	}
+	rules := Config.Discovery.Default
+	rules.Source = "default"
+	return rules
+}
+
+// helper function to match name or relative path of entry against globs
+func matchGlobs(patterns []string, name, rel string) bool {
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pat, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// helper structure of directory to walk
+type walkDir struct {
+	Dir   string
+	Depth int
+}
+
+// helper structure to keep state of directory walk, directories are queued
+// and walked concurrently by fixed pool of workers
+type discovery struct {
+	Rules    DiscoveryRules
+	Root     string
+	Checksum bool
+	Files    []FileInfo
+	Visited  map[string]bool // real paths of walked directories
+	Err      error           // first error of the walk
+	count    int             // number of accepted entries
+	queue    []walkDir       // directories waiting for a worker
+	pending  int             // number of queued and walked directories
+	mutex    sync.Mutex
+	cond     *sync.Cond // signals new directories and end of the walk
+}
+
+// DiscoverFiles finds entries of root directory which satisfy discovery
+// rules along with their attributes, checksums of the files are computed
+// only if requested. It fails if number of entries exceeds maxFiles. The
+// directories are walked by pool of walkWorkers and found entries are
+// ordered by their names.
+func DiscoverFiles(root string, rules DiscoveryRules, checksum bool) ([]FileInfo, error) {
+	if root == "" {
+		return nil, nil
+	}
+	// root directory is always followed
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	d := &discovery{
+		Rules:    rules,
+		Root:     root,
+		Checksum: checksum,
+		Visited:  make(map[string]bool),
+	}
+	d.cond = sync.NewCond(&d.mutex)
+	d.visit(root, info, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < walkWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work()
+		}()
+	}
+	wg.Wait()
+	if d.Err != nil {
+		return nil, d.Err
+	}
+	sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].Name < d.Files[j].Name })
+	return d.Files, nil
+}
+
+// helper function to check if walk has failed
+func (d *discovery) failed() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.Err != nil
+}
+
+// helper function to walk queued directories until all directories are
+// walked
+func (d *discovery) work() {
+	for {
+		d.mutex.Lock()
+		for len(d.queue) == 0 && d.pending > 0 {
+			d.cond.Wait()
+		}
+		if d.pending == 0 {
+			d.mutex.Unlock()
+			return
+		}
+		next := d.queue[len(d.queue)-1]
+		d.queue = d.queue[:len(d.queue)-1]
+		d.mutex.Unlock()
+
+		d.walk(next.Dir, next.Depth)
+
+		d.mutex.Lock()
+		d.pending--
+		if d.pending == 0 {
+			d.cond.Broadcast()
+		}
+		d.mutex.Unlock()
+	}
+}
+
+// helper function to visit entry of the walk at given depth
+func (d *discovery) visit(fname string, info os.FileInfo, depth int) {
+	name := info.Name()
+	rel, _ := filepath.Rel(d.Root, fname)
+	rel = filepath.ToSlash(rel)
+	// rules apply to entries of the root directory but not to root itself
+	if depth > 0 {
+		if d.Rules.SkipHidden && strings.HasPrefix(name, ".") {
+			return
+		}
+		if matchGlobs(d.Rules.Exclude, name, rel) {
+			return
+		}
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch d.Rules.Symlinks {
+		case SymlinksSkip:
+			return
+		case SymlinksFollow:
+			target, err := os.Stat(fname)
+			if err != nil {
+				log.Printf("WARNING: unable to follow symlink %s, error %v", fname, err)
+				return
+			}
+			info = target
+		}
+	}
+	if !info.IsDir() {
+		if len(d.Rules.Include) > 0 && !matchGlobs(d.Rules.Include, name, rel) {
+			return
+		}
+		d.add(fname, info)
+		return
+	}
+	if !d.Rules.SkipDirs {
+		if !d.add(fname, info) {
+			return
+		}
+	}
+	if d.Rules.MaxDepth > 0 && depth >= d.Rules.MaxDepth {
+		return
+	}
+	// protect against symlink loops
+	if real, err := filepath.EvalSymlinks(fname); err == nil {
+		d.mutex.Lock()
+		visited := d.Visited[real]
+		d.Visited[real] = true
+		d.mutex.Unlock()
+		if visited {
+			return
+		}
+	}
+	// directory is read by one of the workers
+	d.mutex.Lock()
+	d.queue = append(d.queue, walkDir{Dir: fname, Depth: depth})
+	d.pending++
+	d.cond.Signal()
+	d.mutex.Unlock()
+}
+
+// helper function to visit entries of given directory
+func (d *discovery) walk(dir string, depth int) {
+	if d.failed() {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("WARNING: unable to read %s, error %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		if d.failed() {
+			return
+		}
+		child := filepath.Join(dir, entry.Name())
+		cinfo, err := os.Lstat(child)
+		if err != nil {
+			log.Printf("WARNING: unable to access %s, error %v", child, err)
+			continue
+		}
+		d.visit(child, cinfo, depth+1)
+	}
+}
+
+// helper function to add entry to found files, it returns false if walk
+// has failed
+func (d *discovery) add(fname string, info os.FileInfo) bool {
+	d.mutex.Lock()
+	if d.Err == nil && d.Rules.MaxFiles > 0 && d.count >= d.Rules.MaxFiles {
+		d.Err = fmt.Errorf("%s contains more than %d entries allowed by discovery rules %s", d.Root, d.Rules.MaxFiles, d.Rules.Source)
+	}
+	failed := d.Err != nil
+	if !failed {
+		d.count++
+	}
+	d.mutex.Unlock()
+	if failed {
+		return false
+	}
+	// checksum is computed outside of the lock
+	finfo := newFileInfo(fname, info, d.Checksum)
+	d.mutex.Lock()
+	d.Files = append(d.Files, finfo)
+	d.mutex.Unlock()
+	return true
+}
+
+// helper function to get discovery rules stored with dataset of given did,
+// it returns false if dataset was registered without rules
+func didDiscoveryRules(did string) (DiscoveryRules, bool, error) {
+	var rules DiscoveryRules
+	var data sql.NullString
+	stmt := "SELECT D.discovery FROM datasets D JOIN metadata M ON M.meta_id=D.meta_id WHERE M.did=?"
+	err := FilesDB.QueryRow(rebind(stmt), did).Scan(&data)
+	if err == sql.ErrNoRows {
+		return rules, false, fmt.Errorf("did %s is not found in FilesDB", did)
+	} else if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return rules, false, err
+	}
+	if !data.Valid || data.String == "" {
+		return rules, false, nil
+	}
+	if err := json.Unmarshal([]byte(data.String), &rules); err != nil {
+		return rules, false, fmt.Errorf("unable to parse discovery rules of did %s, error %v", did, err)
+	}
+	return rules, true, nil
+}
+
+// recordDiscoveryRules returns discovery rules stored with dataset of the
+// record, datasets registered without rules use configured rules of the record
+func recordDiscoveryRules(rec Record) (DiscoveryRules, error) {
+	did := fmt.Sprintf("%v", rec["did"])
+	rules, ok, err := didDiscoveryRules(did)
+	if err != nil || ok {
+		return rules, err
+	}
+	return discoveryRules(rec), nil
+}
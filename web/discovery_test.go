@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// helper function to get relative names of found files
+func discoveredNames(t *testing.T, root string, rules DiscoveryRules) []string {
+	files, err := DiscoverFiles(root, rules, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(root, f.Name)
+		names = append(names, rel)
+	}
+	return names
+}
+
+// TestDiscoverFiles
+func TestDiscoverFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) {
+		fname := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.tiff")
+	write("a.tiff~")
+	write(".hidden")
+	write("sub/b.tiff")
+	write("sub/deep/c.tiff")
+	write(".snapshot/old.tiff")
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, "d.tiff"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// symlink loop
+	if err := os.Symlink(dir, filepath.Join(dir, "sub", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	// zero rules find all entries including root directory and symlinks
+	if names := discoveredNames(t, dir, DiscoveryRules{}); len(names) != 12 {
+		t.Errorf("wrong entries with zero rules %v", names)
+	}
+
+	tests := []struct {
+		Rules    DiscoveryRules
+		Expected string
+	}{
+		{DiscoveryRules{SkipDirs: true, Symlinks: SymlinksSkip, Exclude: []string{"*~", ".snapshot"}, SkipHidden: false},
+			".hidden,a.tiff,sub/b.tiff,sub/deep/c.tiff"},
+		{DiscoveryRules{SkipDirs: true, Symlinks: SymlinksSkip, SkipHidden: true, Include: []string{"*.tiff"}},
+			"a.tiff,sub/b.tiff,sub/deep/c.tiff"},
+		{DiscoveryRules{SkipDirs: true, Symlinks: SymlinksSkip, SkipHidden: true, MaxDepth: 2, Include: []string{"*.tiff"}},
+			"a.tiff,sub/b.tiff"},
+		{DiscoveryRules{Symlinks: SymlinksSkip, SkipHidden: true, MaxDepth: 1, Exclude: []string{"*~"}},
+			".,a.tiff,sub"},
+		{DiscoveryRules{SkipDirs: true, SkipHidden: true, Include: []string{"sub/*"}},
+			"sub/b.tiff,sub/loop"},
+		{DiscoveryRules{SkipDirs: true, Symlinks: SymlinksFollow, SkipHidden: true, Include: []string{"*.tiff"}},
+			"a.tiff,link/d.tiff,sub/b.tiff,sub/deep/c.tiff"},
+	}
+	for _, test := range tests {
+		if err := test.Rules.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		names := strings.Join(discoveredNames(t, dir, test.Rules), ",")
+		if names != test.Expected {
+			t.Errorf("rules %s found %s, expected %s", test.Rules.String(), names, test.Expected)
+		}
+	}
+
+	// maximum number of files safeguard
+	if _, err := DiscoverFiles(dir, DiscoveryRules{MaxFiles: 3}, false); err == nil {
+		t.Error("no error when number of files exceeds maxFiles")
+	}
+	if _, err := DiscoverFiles(filepath.Join(dir, "missing"), DiscoveryRules{}, false); err == nil {
+		t.Error("no error for missing directory")
+	}
+	for _, rules := range []DiscoveryRules{{Exclude: []string{"[a"}}, {Symlinks: "ignore"}, {MaxDepth: -1}} {
+		if err := rules.Validate(); err == nil {
+			t.Errorf("no error for invalid rules %s", rules.String())
+		}
+	}
+}
+
+// TestDiscoveryRules
+func TestDiscoveryRules(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+	discovery := Config.Discovery
+	defer func() { Config.Discovery = discovery }()
+	Config.Discovery = DiscoveryConfig{
+		Default:   DiscoveryRules{SkipHidden: true},
+		Schemas:   map[string]DiscoveryRules{"ID3A": {Include: []string{"*.h5"}}},
+		Beamlines: map[string]DiscoveryRules{"3A": {Include: []string{"*.tiff"}, SkipDirs: true}},
+	}
+
+	// selection of rules by beamline, schema and default
+	if rules := discoveryRules(Record{"Schema": "ID3A", "Beamline": []any{"1A3", "3A"}}); rules.Source != "beamline:3A" {
+		t.Errorf("wrong rules %s", rules.String())
+	}
+	if rules := discoveryRules(Record{"Schema": "ID3A", "Beamline": "1A3"}); rules.Source != "schema:ID3A" {
+		t.Errorf("wrong rules %s", rules.String())
+	}
+	if rules := discoveryRules(Record{"Schema": "ID1A3"}); rules.Source != "default" || !rules.SkipHidden {
+		t.Errorf("wrong rules %s", rules.String())
+	}
+
+	// rules are stored with the dataset and used by rescan
+	dir := t.TempDir()
+	for _, name := range []string{"a.tiff", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	did := "discovery-did"
+	dataset := "/discovery/a"
+	rec := Record{"did": did, "dataset": dataset, "path": dir, "User": "test", "Schema": "ID3A", "Beamline": "3A"}
+	rules := discoveryRules(rec)
+	if err := InsertFiles(did, dataset, dir, rules); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+	stored, ok, err := didDiscoveryRules(did)
+	if err != nil || !ok || stored.String() != rules.String() {
+		t.Errorf("wrong stored rules %s, error %v", stored.String(), err)
+	}
+	files, err := getFiles(did)
+	if err != nil || len(files) != 1 || filepath.Base(files[0]) != "a.tiff" {
+		t.Errorf("wrong registered files %v, error %v", files, err)
+	}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, []Record{rec}); err != nil {
+		t.Fatal(err)
+	}
+	// change of configuration does not affect registered dataset
+	Config.Discovery = DiscoveryConfig{}
+	if err := os.WriteFile(filepath.Join(dir, "c.tiff"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := rescanDID(ctx, did, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || len(report.Removed) != 0 || report.Unchanged != 1 {
+		t.Errorf("wrong rescan report %+v", report)
+	}
+}
@@ -5,6 +5,11 @@ package main
 // Copyright (c) 2019 - Valentin Kuznetsov <vkuznet@gmail.com>
 //
 
+import "errors"
+
+// ErrStoreUnavailable represents error when meta-data store can not be reached
+var ErrStoreUnavailable = errors.New("meta-data store is unavailable")
+
 // ServerError and others are represent different types of errors
 const (
 	_ = iota
@@ -0,0 +1,344 @@
+package main
+
+// events module provides events about changes of meta-data records and
+// delivers them to webhooks, events are stored in meta-data store such that
+// consumers which can't receive webhooks may pull them via /events API
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// list of event types we emit
+const (
+	EventRecordCreated   = "record.created"
+	EventRecordUpdated   = "record.updated"
+	EventRecordDeleted   = "record.deleted"
+	EventRecordRestored  = "record.restored"
+	EventRecordPurged    = "record.purged"
+	EventFilesRegistered = "files.registered"
+)
+
+// EventLimit defines maximum number of events returned by /events API
+var EventLimit = 1000
+
+// EventDelay defines age of events which are provided by /events API,
+// events get their sequence numbers before they are stored, therefore most
+// recent events are held back until events with smaller numbers are stored
+var EventDelay = 5 * time.Second
+
+// WebhookBackoff defines initial delay between webhook delivery attempts,
+// the delay is doubled after every failed attempt
+var WebhookBackoff = time.Second
+
+// Webhook represents webhook configuration
+type Webhook struct {
+	URL    string   `json:"url"`    // webhook URL
+	Secret string   `json:"secret"` // secret used to sign webhook payload
+	Events []string `json:"events"` // list of event types to deliver, all if empty
+}
+
+// Event represents change of meta-data record
+type Event struct {
+	ID        int64  `json:"id"`   // event sequence number
+	Type      string `json:"type"` // event type
+	Did       string `json:"did"`
+	Dataset   string `json:"dataset"`
+	Schema    string `json:"schema"`
+	RecordID  string `json:"record_id,omitempty"`
+	User      string `json:"user,omitempty"`
+	Path      string `json:"path,omitempty"` // location of registered files
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Record returns record representation of the event
+func (e Event) Record() Record {
+	return Record{
+		"id":        e.ID,
+		"type":      e.Type,
+		"did":       e.Did,
+		"dataset":   e.Dataset,
+		"schema":    e.Schema,
+		"record_id": e.RecordID,
+		"user":      e.User,
+		"path":      e.Path,
+		"timestamp": e.Timestamp,
+	}
+}
+
+// helper function to convert stored record into event
+func eventFromRecord(rec Record) Event {
+	str := func(key string) string {
+		if v, ok := rec[key]; ok && v != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return ""
+	}
+	return Event{
+		ID:        int64(intValue(rec["id"])),
+		Type:      str("type"),
+		Did:       str("did"),
+		Dataset:   str("dataset"),
+		Schema:    str("schema"),
+		RecordID:  str("record_id"),
+		User:      str("user"),
+		Path:      str("path"),
+		Timestamp: int64(intValue(rec["timestamp"])),
+	}
+}
+
+// helper function to create event of given type for meta-data record
+func recordEvent(etype string, rec Record) Event {
+	evt := Event{Type: etype, RecordID: recordID(rec)}
+	for key, val := range map[string]*string{
+		"did": &evt.Did, "dataset": &evt.Dataset, "Schema": &evt.Schema, "User": &evt.User,
+	} {
+		if v, ok := rec[key]; ok && v != nil {
+			*val = fmt.Sprintf("%v", v)
+		}
+	}
+	return evt
+}
+
+// event sequence, we use time in microseconds to keep sequence growing
+// across server restarts
+var _eventSeq struct {
+	sync.Mutex
+	Last int64
+}
+
+// helper function to get next event sequence number
+func nextEventID() int64 {
+	_eventSeq.Lock()
+	defer _eventSeq.Unlock()
+	id := time.Now().UnixMicro()
+	if id <= _eventSeq.Last {
+		id = _eventSeq.Last + 1
+	}
+	_eventSeq.Last = id
+	return id
+}
+
+// emitEvents stores given events and sends them to webhooks, failures are
+// logged since events should not break operations which produced them
+func emitEvents(ctx context.Context, events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	var records []Record
+	for i := range events {
+		events[i].ID = nextEventID()
+		events[i].Timestamp = time.Now().Unix()
+		records = append(records, events[i].Record())
+	}
+	if err := Insert(ctx, Config.DBName, Config.EventsColl, records); err != nil {
+		log.Printf("ERROR: unable to store %d events, error %v", len(events), err)
+	}
+	for _, evt := range events {
+		if Config.Verbose > 0 {
+			log.Printf("event %d %s did=%s dataset=%s", evt.ID, evt.Type, evt.Did, evt.Dataset)
+		}
+		for _, hook := range Config.Webhooks {
+			if len(hook.Events) > 0 && !InList(evt.Type, hook.Events) {
+				continue
+			}
+			storeDelivery(hook, evt, 0)
+			go deliverEvent(hook, evt, 0)
+		}
+	}
+}
+
+// getEvents returns events which happened after given sequence number,
+// events younger than EventDelay are not returned
+func getEvents(ctx context.Context, since int64, etypes []string, limit int) ([]Event, error) {
+	until := time.Now().Add(-EventDelay).UnixMicro()
+	spec := bson.M{"id": bson.M{"$gt": since, "$lte": until}}
+	if len(etypes) > 0 {
+		spec["type"] = bson.M{"$in": etypes}
+	}
+	if limit <= 0 || limit > EventLimit {
+		limit = EventLimit
+	}
+	records, err := GetSorted(ctx, Config.DBName, Config.EventsColl, spec, []string{"id"}, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for _, rec := range records {
+		events = append(events, eventFromRecord(rec))
+	}
+	return events, nil
+}
+
+// helper function to sign webhook payload with given secret
+func signPayload(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverEvent sends event to webhook after given number of attempts,
+// failed deliveries are retried with exponential backoff and every attempt
+// is recorded in delivery log. The delivery is kept as pending in meta-data
+// store until it is completed, see resumeDeliveries.
+func deliverEvent(hook Webhook, evt Event, attempts int) {
+	defer removeDelivery(hook, evt)
+	data, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("ERROR: unable to marshal event %d, error %v", evt.ID, err)
+		return
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	delay := WebhookBackoff
+	retries := webhookRetries()
+	for attempt := attempts + 1; attempt <= retries; attempt++ {
+		status, err := postEvent(&client, hook, evt, data)
+		logDelivery(hook, evt, attempt, status, err)
+		if err == nil {
+			return
+		}
+		log.Printf("ERROR: delivery of event %d to %s failed, attempt %d/%d, error %v", evt.ID, hook.URL, attempt, retries, err)
+		if attempt < retries {
+			storeDelivery(hook, evt, attempt)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// helper function to get key of pending delivery of event to webhook
+func deliveryKey(hook Webhook, evt Event) string {
+	return fmt.Sprintf("%d %s", evt.ID, hook.URL)
+}
+
+// helper function to store pending delivery of event to webhook along with
+// number of attempts made so far
+func storeDelivery(hook Webhook, evt Event, attempts int) {
+	rec := Record{
+		"delivery": deliveryKey(hook, evt),
+		"pending":  true,
+		"url":      hook.URL,
+		"event":    evt.Record(),
+		"attempts": attempts,
+	}
+	ctx := context.Background()
+	if err := MongoUpsert(ctx, Config.DBName, Config.DeliveriesColl, "delivery", []Record{rec}); err != nil {
+		log.Printf("ERROR: unable to store pending delivery of event %d, error %v", evt.ID, err)
+	}
+}
+
+// helper function to remove completed delivery of event to webhook
+func removeDelivery(hook Webhook, evt Event) {
+	spec := bson.M{"delivery": deliveryKey(hook, evt)}
+	if err := Remove(context.Background(), Config.DBName, Config.DeliveriesColl, spec); err != nil {
+		log.Printf("ERROR: unable to remove pending delivery of event %d, error %v", evt.ID, err)
+	}
+}
+
+// resumeDeliveries restarts webhook deliveries which were not completed
+// before server restart, deliveries to webhooks which are no longer
+// configured are dropped
+func resumeDeliveries(ctx context.Context) error {
+	records, err := MongoGet(ctx, Config.DBName, Config.DeliveriesColl, bson.M{"pending": true}, 0, -1)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		erec, _ := toMap(rec["event"])
+		evt := eventFromRecord(Record(erec))
+		url := fmt.Sprintf("%v", rec["url"])
+		var hook *Webhook
+		for i := range Config.Webhooks {
+			if Config.Webhooks[i].URL == url {
+				hook = &Config.Webhooks[i]
+				break
+			}
+		}
+		if hook == nil {
+			log.Printf("WARNING: drop delivery of event %d to %s which is not configured", evt.ID, url)
+			removeDelivery(Webhook{URL: url}, evt)
+			continue
+		}
+		log.Printf("resume delivery of event %d to %s", evt.ID, url)
+		go deliverEvent(*hook, evt, intValue(rec["attempts"]))
+	}
+	return nil
+}
+
+// helper function to post event to webhook
+func postEvent(client *http.Client, hook Webhook, evt Event, data []byte) (int, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewBuffer(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chess-Event", evt.Type)
+	req.Header.Set("X-Chess-Delivery", fmt.Sprintf("%d", evt.ID))
+	if hook.Secret != "" {
+		req.Header.Set("X-Chess-Signature", signPayload(hook.Secret, data))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// helper function to record webhook delivery attempt
+func logDelivery(hook Webhook, evt Event, attempt, status int, err error) {
+	rec := Record{
+		"event_id":  evt.ID,
+		"type":      evt.Type,
+		"url":       hook.URL,
+		"attempt":   attempt,
+		"status":    status,
+		"delivered": err == nil,
+		"timestamp": time.Now().Unix(),
+	}
+	if err != nil {
+		rec["error"] = err.Error()
+	}
+	ctx := context.Background()
+	if e := Insert(ctx, Config.DBName, Config.DeliveriesColl, []Record{rec}); e != nil {
+		log.Printf("ERROR: unable to log delivery of event %d, error %v", evt.ID, e)
+	}
+}
+
+// getDeliveries returns delivery log of given event
+func getDeliveries(ctx context.Context, eid int64) ([]Record, error) {
+	spec := bson.M{"event_id": eid}
+	records, err := GetSorted(ctx, Config.DBName, Config.DeliveriesColl, spec, []string{"timestamp"}, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		delete(rec, "_id")
+	}
+	return records, nil
+}
+
+// helper function to return number of webhook delivery attempts
+func webhookRetries() int {
+	if Config.WebhookRetries > 0 {
+		return Config.WebhookRetries
+	}
+	return 5
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestEvents
+func TestEvents(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+
+	rec := Record{"dataset": "/events/a", "did": "/beamline=events/a", "Schema": "test", "User": "events"}
+	if err := upsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := upsertRecord(ctx, Record{"dataset": "/events/a", "StringKey": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := getEvents(ctx, 0, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != EventRecordCreated || events[1].Type != EventRecordUpdated {
+		t.Fatalf("wrong events %+v", events)
+	}
+	if e := events[1]; e.Did != "/beamline=events/a" || e.Schema != "test" || e.RecordID == "" {
+		t.Errorf("wrong event %+v", e)
+	}
+
+	// consumers get events which happened after given sequence number
+	events, err = getEvents(ctx, events[0].ID, nil, 0)
+	if err != nil || len(events) != 1 || events[0].Type != EventRecordUpdated {
+		t.Errorf("wrong events %+v, error %v", events, err)
+	}
+	events, err = getEvents(ctx, 0, []string{EventRecordCreated}, 0)
+	if err != nil || len(events) != 1 || events[0].Type != EventRecordCreated {
+		t.Errorf("wrong events %+v, error %v", events, err)
+	}
+
+	// recent events are held back until events emitted before are stored
+	EventDelay = time.Hour
+	defer func() { EventDelay = 0 }()
+	if events, err := getEvents(ctx, 0, nil, 0); err != nil || len(events) != 0 {
+		t.Errorf("recent events are provided %+v, error %v", events, err)
+	}
+}
+
+// TestWebhooks
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	WebhookBackoff = time.Millisecond
+
+	var mutex sync.Mutex
+	var calls int
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls++
+		attempt := calls
+		mutex.Unlock()
+		// fail first delivery to check retries
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Chess-Signature") != signPayload("secret", data) {
+			t.Errorf("wrong signature %s", r.Header.Get("X-Chess-Signature"))
+		}
+		var evt Event
+		json.Unmarshal(data, &evt)
+		received <- evt
+	}))
+	defer server.Close()
+	Config.Webhooks = []Webhook{
+		{URL: server.URL, Secret: "secret"},
+		{URL: server.URL, Events: []string{EventFilesRegistered}},
+	}
+	defer func() { Config.Webhooks = nil }()
+
+	rec := Record{"dataset": "/webhooks/a", "did": "/beamline=webhooks/a", "Schema": "test"}
+	if err := upsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	var evt Event
+	select {
+	case evt = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event is not delivered")
+	}
+	if evt.Type != EventRecordCreated || evt.Dataset != "/webhooks/a" {
+		t.Errorf("wrong delivered event %+v", evt)
+	}
+
+	// every delivery attempt is recorded in delivery log
+	time.Sleep(100 * time.Millisecond)
+	records, err := getDeliveries(ctx, evt.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0]["delivered"] != false || records[1]["delivered"] != true {
+		t.Errorf("wrong delivery log %+v", records)
+	}
+	pending := func() int {
+		records, err := MongoGet(ctx, Config.DBName, Config.DeliveriesColl, bson.M{"pending": true}, 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(records)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("completed deliveries are pending %d", n)
+	}
+
+	// pending deliveries are resumed after restart, deliveries to webhooks
+	// which are no longer configured are dropped
+	storeDelivery(Config.Webhooks[0], evt, 1)
+	storeDelivery(Webhook{URL: "http://localhost/unknown"}, evt, 0)
+	if err := resumeDeliveries(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case resumed := <-received:
+		if resumed.ID != evt.ID {
+			t.Errorf("wrong resumed event %+v", resumed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending event is not delivered")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := pending(); n != 0 {
+		t.Errorf("resumed deliveries are pending %d", n)
+	}
+	if records, _ := getDeliveries(ctx, evt.ID); len(records) != 3 || intValue(records[2]["attempt"]) != 2 {
+		t.Errorf("wrong delivery log of resumed delivery %+v", records)
+	}
+}
+
+// TestHTTPEvents
+func TestHTTPEvents(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	for _, dataset := range []string{"/http/events/a", "/http/events/b"} {
+		if err := upsertRecord(ctx, Record{"dataset": dataset, "Schema": "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var resp struct {
+		Events []Event `json:"events"`
+		Next   int64   `json:"next"`
+	}
+	rr, err := respRecorder("GET", "/events?limit=1", nil, EventsHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Events) != 1 || resp.Events[0].Dataset != "/http/events/a" || resp.Next != resp.Events[0].ID {
+		t.Fatalf("wrong events response %s", rr.Body.String())
+	}
+	rr, err = respRecorder("GET", "/events?since="+strconv.FormatInt(resp.Next, 10), nil, EventsHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Events) != 1 || resp.Events[0].Dataset != "/http/events/b" {
+		t.Errorf("wrong events response %s", rr.Body.String())
+	}
+	if _, err := respRecorder("GET", "/events?since=abc", nil, EventsHandler); err == nil {
+		t.Error("no error for invalid since value")
+	}
+}
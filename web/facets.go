@@ -0,0 +1,212 @@
+package main
+
+// facets module provides facets of search results, i.e. counts of distinct
+// values of schema keys among records matching user query
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// FacetLimit defines maximum number of values we report per facet
+var FacetLimit = 20
+
+// FacetValue represents single value of a facet and number of records with it
+type FacetValue struct {
+	Value any    `json:"value"`
+	Count int    `json:"count"`
+	URL   string `json:"-"` // URL which refines search results with this value
+}
+
+// Facet represents counts of distinct values of schema key
+type Facet struct {
+	Key    string       `json:"key"`
+	Values []FacetValue `json:"values"`
+}
+
+// facetKeys returns keys used as facets of search results, they are schema
+// keys with facet attribute and list_str keys with predefined values
+func facetKeys() []string {
+	type facetKey struct {
+		Key      string
+		Position int
+	}
+	var fkeys []facetKey
+	var keys []string
+	for _, fname := range SortedKeys(_smgr.Map) {
+		sobj := _smgr.Map[fname]
+		if sobj.Schema == nil {
+			continue
+		}
+		for _, rec := range sobj.Schema.Map {
+			values, _ := toList(rec.Value)
+			if !rec.Facet && !(rec.Type == "list_str" && len(values) > 0) {
+				continue
+			}
+			if InList(rec.Key, keys) {
+				continue
+			}
+			keys = append(keys, rec.Key)
+			fkeys = append(fkeys, facetKey{rec.Key, rec.Position})
+		}
+	}
+	sort.SliceStable(fkeys, func(i, j int) bool {
+		if fkeys[i].Position == fkeys[j].Position {
+			return fkeys[i].Key < fkeys[j].Key
+		}
+		return fkeys[i].Position < fkeys[j].Position
+	})
+	keys = keys[:0]
+	for _, k := range fkeys {
+		keys = append(keys, k.Key)
+	}
+	return keys
+}
+
+// ParseFilters parses facet filters provided in key:value form and returns
+// spec which selects records with given values, multiple values of the same
+// key should all be present in the record
+func ParseFilters(filters []string) (bson.M, error) {
+	spec := make(bson.M)
+	values := make(map[string][]string)
+	var keys []string
+	for _, filter := range filters {
+		arr := strings.SplitN(filter, separator, 2)
+		if len(arr) != 2 {
+			return nil, fmt.Errorf("invalid filter '%s', should be in key:value form", filter)
+		}
+		key, ok := _schemaKeys[strings.ToLower(strings.TrimSpace(arr[0]))]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter key '%s'", arr[0])
+		}
+		if !InList(key, keys) {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], arr[1])
+	}
+	for _, key := range keys {
+		if len(values[key]) == 1 {
+			spec[key] = filterValue(values[key][0])
+		} else {
+			spec[key] = bson.M{"$all": values[key]}
+		}
+	}
+	return spec, nil
+}
+
+// helper function to convert filter value to numeric type if possible, such
+// values are matched either as numbers or as strings
+func filterValue(val string) any {
+	if v, err := strconv.Atoi(val); err == nil {
+		return bson.M{"$in": []any{val, v}}
+	}
+	if v, err := strconv.ParseFloat(val, 64); err == nil {
+		return bson.M{"$in": []any{val, v}}
+	}
+	return val
+}
+
+// helper function to combine query and filters specs
+func filterSpec(spec, fspec bson.M) bson.M {
+	if len(fspec) == 0 {
+		return spec
+	}
+	if len(spec) == 0 {
+		return fspec
+	}
+	for k := range fspec {
+		if _, ok := spec[k]; ok {
+			return bson.M{"$and": []bson.M{spec, fspec}}
+		}
+	}
+	for k, v := range fspec {
+		spec[k] = v
+	}
+	return spec
+}
+
+// computeFacets returns facets of given keys for records matching given spec,
+// list values are counted individually and facet values are ordered by their
+// counts
+func computeFacets(ctx context.Context, spec bson.M, keys []string) ([]Facet, error) {
+	facets := []Facet{}
+	if len(keys) == 0 {
+		return facets, nil
+	}
+	store, ok := MetaStore.(AggregateStore)
+	if !ok {
+		return facets, errors.New("meta-data store does not support aggregation")
+	}
+	fspec := bson.M{}
+	for _, key := range keys {
+		fspec[key] = []bson.M{
+			{"$unwind": "$" + key},
+			{"$group": bson.M{"_id": "$" + key, "count": bson.M{"$sum": 1}}},
+		}
+	}
+	pipeline := []bson.M{{"$match": spec}, {"$facet": fspec}}
+	records, err := store.Aggregate(ctx, Config.DBName, Config.DBColl, pipeline)
+	if err != nil || len(records) == 0 {
+		return facets, err
+	}
+	for _, key := range keys {
+		facet := Facet{Key: key}
+		groups, _ := toList(records[0][key])
+		for _, g := range groups {
+			group, ok := toMap(g)
+			if !ok || group["_id"] == nil || group["_id"] == "" {
+				continue
+			}
+			facet.Values = append(facet.Values, FacetValue{Value: group["_id"], Count: intValue(group["count"])})
+		}
+		if len(facet.Values) == 0 {
+			continue
+		}
+		sort.SliceStable(facet.Values, func(i, j int) bool {
+			if facet.Values[i].Count == facet.Values[j].Count {
+				return compareValues(facet.Values[i].Value, facet.Values[j].Value) < 0
+			}
+			return facet.Values[i].Count > facet.Values[j].Count
+		})
+		if len(facet.Values) > FacetLimit {
+			facet.Values = facet.Values[:FacetLimit]
+		}
+		facets = append(facets, facet)
+	}
+	return facets, nil
+}
+
+// helper function to render facets sidebar of search results, every facet
+// value links to search results refined by this value and every selected
+// filter links to search results without it
+func facetsSidebar(query, sort string, filters []string, limit int, facets []Facet) string {
+	var selected []map[string]string
+	for i, f := range filters {
+		rest := append(append([]string{}, filters[:i]...), filters[i+1:]...)
+		selected = append(selected, map[string]string{"Filter": f, "URL": searchURL(query, sort, rest, 0, limit)})
+	}
+	for i := range facets {
+		for j := range facets[i].Values {
+			f := fmt.Sprintf("%s%s%v", facets[i].Key, separator, facets[i].Values[j].Value)
+			if InList(f, filters) {
+				continue
+			}
+			refined := append(append([]string{}, filters...), f)
+			facets[i].Values[j].URL = searchURL(query, sort, refined, 0, limit)
+		}
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Selected"] = selected
+	tmplData["Facets"] = facets
+	return templates.Tmpl(Config.Templates, "facets.tmpl", tmplData)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestParseFilters
+func TestParseFilters(t *testing.T) {
+	initMetaDataService()
+	spec, err := ParseFilters([]string{"stringkey:a", "ListKey:3A", "ListKey:3B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec["StringKey"] != "a" {
+		t.Errorf("wrong filter spec %+v", spec)
+	}
+	if all, ok := spec["ListKey"].(bson.M); !ok || len(all["$all"].([]string)) != 2 {
+		t.Errorf("wrong filter spec %+v", spec)
+	}
+	for _, f := range []string{"StringKey", "UnknownKey:a"} {
+		if _, err := ParseFilters([]string{f}); err == nil {
+			t.Errorf("no error for invalid filter %s", f)
+		}
+	}
+
+	// filters of keys used in query are combined with it
+	spec = filterSpec(bson.M{"StringKey": "a"}, bson.M{"StringKey": "b"})
+	if _, ok := spec["$and"]; !ok {
+		t.Errorf("wrong combined spec %+v", spec)
+	}
+}
+
+// helper function to insert records for facets tests
+func insertFacetRecords(t *testing.T) {
+	records := []Record{
+		{"dataset": "/facets/a", "StringKey": "a", "ListKey": []string{"3A", "3B"}, "User": "facets"},
+		{"dataset": "/facets/b", "StringKey": "a", "ListKey": []string{"3A"}, "User": "facets"},
+		{"dataset": "/facets/c", "StringKey": "b", "ListKey": []string{"3B"}, "User": "facets"},
+	}
+	if err := Insert(context.Background(), Config.DBName, Config.DBColl, records); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFacets
+func TestFacets(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	insertFacetRecords(t)
+
+	keys := facetKeys()
+	if !InList("StringKey", keys) || !InList("ListKey", keys) || InList("FloatKey", keys) {
+		t.Fatalf("wrong facet keys %v", keys)
+	}
+	facets, err := computeFacets(ctx, bson.M{"User": "facets"}, []string{"StringKey", "ListKey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(facets) != 2 {
+		t.Fatalf("wrong facets %+v", facets)
+	}
+	if v := facets[0].Values; len(v) != 2 || v[0].Value != "a" || v[0].Count != 2 {
+		t.Errorf("wrong facet values %+v", v)
+	}
+	// list values are counted individually
+	if v := facets[1].Values; len(v) != 2 || v[0].Count != 2 || v[1].Count != 2 {
+		t.Errorf("wrong facet values %+v", v)
+	}
+
+	// refined query narrows facets
+	spec, _ := ParseFilters([]string{"ListKey:3B"})
+	spec = filterSpec(bson.M{"User": "facets"}, spec)
+	facets, err = computeFacets(ctx, spec, []string{"StringKey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := facets[0].Values; len(v) != 2 || v[0].Count != 1 || v[1].Count != 1 {
+		t.Errorf("wrong refined facet values %+v", v)
+	}
+}
+
+// TestHTTPSearchFacets
+func TestHTTPSearchFacets(t *testing.T) {
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	insertFacetRecords(t)
+
+	form := url.Values{}
+	form.Add("query", "User:facets")
+	form.Add("filter", "ListKey:3A")
+	form.Add("client", "cli")
+	form.Add("facets", "true")
+	reader := strings.NewReader(form.Encode())
+	rr, err := respRecorder("POST", "/search", reader, SearchHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Records []Record `json:"records"`
+		Facets  []Facet  `json:"facets"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Records) != 2 || len(resp.Facets) == 0 {
+		t.Fatalf("wrong search response %s", rr.Body.String())
+	}
+	for _, f := range resp.Facets {
+		if f.Key == "ListKey" && (len(f.Values) != 2 || f.Values[0].Value != "3A" || f.Values[0].Count != 2) {
+			t.Errorf("wrong facet %+v", f)
+		}
+	}
+
+	// web clients get sidebar with links which refine the query
+	form.Del("client")
+	reader = strings.NewReader(form.Encode())
+	rr, err = respRecorder("POST", "/search", reader, SearchHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refined := url.Values{"filter": {"ListKey:3A", "ListKey:3B"}}.Encode()
+	if !strings.Contains(rr.Body.String(), strings.ReplaceAll(refined, "&", "&amp;")) {
+		t.Errorf("no refine link in search page")
+	}
+}
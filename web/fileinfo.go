@@ -0,0 +1,153 @@
+package main
+
+// fileinfo module provides attributes of files we register in FilesDB
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// list of entry types of registered files
+const (
+	EntryFile     = "file"
+	EntryDir      = "dir"
+	EntrySymlink  = "symlink"
+	EntryOther    = "other"
+	EntrySequence = "sequence" // summary of sequence of numbered files
+)
+
+// FileInfo represents attributes of a file registered in FilesDB
+type FileInfo struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Mtime    int64  `json:"mtime"`
+	Checksum string `json:"checksum,omitempty"` // checksum in algorithm:value form
+	Type     string `json:"type"`
+	Tier     string `json:"tier,omitempty"`   // data tier, e.g. RAW or REDUCED
+	Frames   string `json:"frames,omitempty"` // frame ranges of sequence, e.g. 1-4,8-10
+}
+
+// MtimeString returns modification time of the file in RFC3339 format
+func (f FileInfo) MtimeString() string {
+	if f.Mtime <= 0 {
+		return "-"
+	}
+	return time.Unix(f.Mtime, 0).UTC().Format(time.RFC3339)
+}
+
+// String returns string representation of the file, the name is placed last
+// such that it can be easily extracted from CLI output
+func (f FileInfo) String() string {
+	checksum := f.Checksum
+	if checksum == "" {
+		checksum = "-"
+	}
+	tier := f.Tier
+	if tier == "" {
+		tier = "-"
+	}
+	name := f.Name
+	if f.Frames != "" {
+		name = fmt.Sprintf("%s [%s]", f.Name, f.Frames)
+	}
+	return fmt.Sprintf("%-7s %-7s %12d %s %s %s", f.Type, tier, f.Size, f.MtimeString(), checksum, name)
+}
+
+// helper function to get entry type of a file
+func entryType(info os.FileInfo) string {
+	mode := info.Mode()
+	switch {
+	case mode.IsRegular():
+		return EntryFile
+	case mode.IsDir():
+		return EntryDir
+	case mode&os.ModeSymlink != 0:
+		return EntrySymlink
+	}
+	return EntryOther
+}
+
+// helper function to get checksum algorithm used for registered files
+func checksumType() string {
+	if Config.Checksum == "" {
+		return "adler32"
+	}
+	return strings.ToLower(Config.Checksum)
+}
+
+// fileChecksum computes checksum of given file using configured algorithm,
+// it returns empty string if checksums are disabled
+func fileChecksum(fname string) (string, error) {
+	var hasher hash.Hash
+	alg := checksumType()
+	switch alg {
+	case "none":
+		return "", nil
+	case "adler32":
+		hasher = adler32.New()
+	case "sha256":
+		hasher = sha256.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum type %s", alg)
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return alg + ":" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// helper function to create file info of given path, checksum of regular
+// files is computed on demand since it requires reading the whole file
+func newFileInfo(path string, info os.FileInfo, checksum bool) FileInfo {
+	finfo := FileInfo{Name: path, Type: EntryOther}
+	if info == nil {
+		return finfo
+	}
+	finfo.Type = entryType(info)
+	finfo.Mtime = info.ModTime().Unix()
+	if finfo.Type == EntryFile {
+		finfo.Size = info.Size()
+	}
+	if checksum {
+		finfo.setChecksum()
+	}
+	return finfo
+}
+
+// helper function to compute checksum of regular file
+func (f *FileInfo) setChecksum() {
+	if f.Type != EntryFile {
+		return
+	}
+	checksum, err := fileChecksum(f.Name)
+	if err != nil {
+		log.Printf("WARNING: unable to compute checksum of %s, error %v", f.Name, err)
+		return
+	}
+	f.Checksum = checksum
+}
+
+// helper function to get total size of given files
+func totalSize(files []FileInfo) int64 {
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return size
+}
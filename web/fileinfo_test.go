@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileInfo
+func TestFileInfo(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(fname, []byte("Wikipedia"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(fname, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Config.Checksum = "" }()
+
+	files, err := DiscoverFiles(dir, DiscoveryRules{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]FileInfo)
+	for _, f := range files {
+		types[f.Type] = f
+	}
+	if len(types) != 3 {
+		t.Fatalf("wrong entry types %+v", types)
+	}
+	// adler32 of Wikipedia is 11e60398
+	if f := types[EntryFile]; f.Size != 9 || f.Checksum != "adler32:11e60398" || f.Mtime == 0 {
+		t.Errorf("wrong file attributes %+v", f)
+	}
+	if f := types[EntryDir]; f.Size != 0 || f.Checksum != "" {
+		t.Errorf("wrong directory attributes %+v", f)
+	}
+
+	Config.Checksum = "sha256"
+	if checksum, err := fileChecksum(fname); err != nil || len(checksum) != len("sha256:")+64 {
+		t.Errorf("wrong sha256 checksum %s, error %v", checksum, err)
+	}
+	Config.Checksum = "none"
+	if checksum, err := fileChecksum(fname); err != nil || checksum != "" {
+		t.Errorf("checksum %s is computed while disabled, error %v", checksum, err)
+	}
+	Config.Checksum = "md4"
+	if _, err := fileChecksum(fname); err == nil {
+		t.Error("no error for unsupported checksum")
+	}
+}
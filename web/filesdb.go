@@ -14,22 +14,34 @@ package main
 //   _ "github.com/go-sql-driver/mysql"
 // SQLite driver:
 //  _ "github.com/mattn/go-sqlite3"
+// PostgreSQL driver:
+//  _ "github.com/lib/pq"
 //
 
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // FilesDB global variable to keep pointer to Files DB
 var FilesDB *sql.DB
 
+// validity states of registered files
+const (
+	FileInvalid = 0 // file is gone from disk
+	FileValid   = 1 // file exists on disk
+	FileDeleted = 2 // file of soft deleted record
+)
+
 // InitFilesDB sets pointer to FilesDB
 func InitFilesDB() (*sql.DB, error) {
 	dbAttrs := strings.Split(Config.FilesDBUri, "://")
@@ -42,7 +54,7 @@ func InitFilesDB() (*sql.DB, error) {
 	} else {
 		log.Printf("FilesDB: %v\n", dbAttrs)
 	}
-	db, err := sql.Open(dbAttrs[0], dbAttrs[1])
+	db, err := sql.Open(dbAttrs[0], dataSourceName(dbAttrs[0], dbAttrs[1]))
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +69,7 @@ func InitFilesDB() (*sql.DB, error) {
 func execute(tx *sql.Tx, stm string, args ...interface{}) ([]Record, error) {
 	var records []Record
 
-	rows, err := tx.Query(stm, args...)
+	rows, err := tx.Query(rebind(stm), args...)
 	if err != nil {
 		log.Printf("query %v arguments %v error %v\n", stm, args, err)
 		return records, err
@@ -131,35 +143,103 @@ func execute(tx *sql.Tx, stm string, args ...interface{}) ([]Record, error) {
 // findDID finds dataset attributes
 func findDID(stmt string, args ...interface{}) (string, error) {
 	var did string
-	err := FilesDB.QueryRow(stmt, args...).Scan(&did)
+	err := FilesDB.QueryRow(rebind(stmt), args...).Scan(&did)
 	if err == nil {
 		return did, nil
 	}
 	return did, errors.New("Unable to find id")
 }
 
-// InsertFiles insert given files into FilesDB
-func InsertFiles(did, dataset, path string) error {
-	// look-up files for given path
-	files := FindFiles(path)
+// InsertFiles insert files of given path which satisfy discovery rules
+// into FilesDB as RAW data tier, the rules are stored with the dataset
+func InsertFiles(did, dataset, path string, rules DiscoveryRules) error {
+	return InsertLocations(did, dataset, []DataLocation{{Tier: TierRaw, Path: path}}, rules)
+}
 
-	log.Printf("InsertFiles: dataset=%s did=%s", dataset, did)
+// InsertLocations insert files of given data locations which satisfy
+// discovery rules into FilesDB, the rules are stored with the dataset
+func InsertLocations(did, dataset string, locations []DataLocation, rules DiscoveryRules) error {
+	_, err := RegisterLocations(did, dataset, locations, rules)
+	return err
+}
 
-	// check if we have already our dataset in DB
-	dstmt := "SELECT did FROM metadata M JOIN datasets D ON M.meta_id=D.meta_id WHERE D.dataset=? AND M.did=?"
-	DID, e := findDID(dstmt, dataset, did)
-	if e == nil && DID == did {
-		return nil
+// RegisterLocations is bulk registration path of files of given data
+// locations: directories are walked and checksums are computed by pool of
+// walk workers and files are inserted via multi-row inserts within single
+// transaction. It returns throughput metrics of the registration.
+func RegisterLocations(did, dataset string, locations []DataLocation, rules DiscoveryRules) (RegistrationStats, error) {
+	var stats RegistrationStats
+	// look-up files for given locations, checksums are computed only for
+	// files which do not belong to sequences
+	start := time.Now()
+	files, _, err := discoverLocations(locations, rules, false)
+	if err != nil {
+		log.Printf("ERROR: unable to discover files of dataset %s, error %v", dataset, err)
+		return stats, err
 	}
-	log.Println("proceed with insert")
+	stats.Files = len(files)
+	stats.measure(&stats.WalkTime, start)
+	seqs, files := splitSequences(files, sequenceMinFiles())
+	start = time.Now()
+	setChecksums(files)
+	stats.measure(&stats.ChecksumTime, start)
 
 	// proceed with transaction operation
+	start = time.Now()
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return stats, err
+	}
+	defer tx.Rollback()
+	registered, err := insertFiles(tx, did, dataset, rules, seqs, files)
+	if err != nil {
+		return stats, err
+	}
+	// commit whole workflow
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return stats, err
+	}
+	stats.Registered = registered
+	stats.Skipped = stats.Files - registered
+	stats.measure(&stats.InsertTime, start)
+	log.Printf("InsertFiles: dataset=%s did=%s %s", dataset, did, stats)
+	return stats, nil
+}
+
+// InsertDataset inserts dataset without files into FilesDB along with its
+// discovery rules, the files are registered later by the job
+func InsertDataset(did, dataset string, rules DiscoveryRules) error {
 	tx, err := FilesDB.Begin()
 	if err != nil {
 		log.Printf("ERROR: DB error %v\n", err)
 		return err
 	}
 	defer tx.Rollback()
+	if _, err := insertFiles(tx, did, dataset, rules, nil, nil); err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return err
+	}
+	return nil
+}
+
+// helper function to insert given sequences and files of a dataset within
+// transaction, it returns number of inserted files including frames of
+// sequences. Files of already registered dataset are not inserted.
+func insertFiles(tx *sql.Tx, did, dataset string, rules DiscoveryRules, seqs []FileSequence, files []FileInfo) (int, error) {
+	// check if we have already our dataset in DB
+	var DID string
+	dstmt := "SELECT did FROM metadata M JOIN datasets D ON M.meta_id=D.meta_id WHERE D.dataset=? AND M.did=?"
+	if err := tx.QueryRow(rebind(dstmt), dataset, did).Scan(&DID); err == nil && DID == did {
+		return 0, nil
+	}
+	log.Println("proceed with insert")
 
 	// main attributes
 	var stmt string
@@ -172,58 +252,164 @@ func InsertFiles(did, dataset, path string) error {
 
 	// insert main attributes
 	stmt = "INSERT INTO metadata (did,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?)"
-	_, err = tx.Exec(stmt, did, create_at, create_by, modify_at, modify_by)
-	if err != nil && !strings.Contains(err.Error(), "UNIQUE") {
+	_, err := insertUnique(tx, stmt, did, create_at, create_by, modify_at, modify_by)
+	if err != nil {
 		log.Printf("ERROR: unable to execute %s with %v, error=%v", stmt, did, err)
-		return tx.Rollback()
+		return 0, err
 	}
 
 	stmt = "SELECT meta_id FROM metadata WHERE did=?"
 	res, err = execute(tx, stmt, did)
-	if err != nil {
+	if err != nil || len(res) == 0 {
 		log.Printf("ERROR: unable to execute %s with %v, error=%v", stmt, did, err)
-		return tx.Rollback()
+		return 0, fmt.Errorf("unable to find meta_id of did=%s, error %v", did, err)
 	}
 	rec = res[0]
 	metaId := rec["meta_id"].(int64)
 
 	// insert main attributes
-	stmt = "INSERT INTO datasets (dataset,meta_id,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?,?)"
-	_, err = tx.Exec(stmt, dataset, metaId, create_at, create_by, modify_at, modify_by)
-	if err != nil && !strings.Contains(err.Error(), "UNIQUE") {
+	cycle, beamline, btr, sample := datasetColumns(dataset)
+	stmt = "INSERT INTO datasets (dataset,cycle,beamline,btr,sample,discovery,meta_id,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?,?,?,?,?,?,?)"
+	_, err = insertUnique(tx, stmt, dataset, cycle, beamline, btr, sample, rules.String(), metaId, create_at, create_by, modify_at, modify_by)
+	if err != nil {
 		log.Printf("ERROR: unable to execute %s with %v, error=%v", stmt, dataset, err)
-		return tx.Rollback()
+		return 0, err
 	}
 
 	// select main attributes ids
 	stmt = "SELECT dataset_id FROM datasets WHERE dataset=?"
 	res, err = execute(tx, stmt, dataset)
-	if err != nil {
+	if err != nil || len(res) == 0 {
 		log.Printf("ERROR: unable to execute %s with %v, error=%v", stmt, dataset, err)
-		return tx.Rollback()
+		return 0, fmt.Errorf("unable to find dataset_id of dataset=%s, error %v", dataset, err)
 	}
 	rec = res[0]
 	datasetId := rec["dataset_id"].(int64)
 
-	// insert files info
-	for _, fname := range files {
-		stmt = "INSERT INTO files (file,meta_id,dataset_id,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?,?,?)"
-		_, err = tx.Exec(stmt, fname, metaId, datasetId, create_at, create_by, modify_at, modify_by)
-		if err != nil && !strings.Contains(err.Error(), "UNIQUE") {
-			log.Printf("ERROR: unable to execute %s with did=%v name=%s error=%v", stmt, did, fname, err)
-			return tx.Rollback()
+	// insert sequences and files info
+	var inserted int
+	for _, s := range seqs {
+		added, err := insertSequence(tx, s, metaId, datasetId)
+		if err != nil {
+			return 0, err
+		}
+		if added {
+			inserted += int(s.Frames)
 		}
 	}
-	// commit whole workflow
-	err = tx.Commit()
+	n, err := insertFilesBulk(tx, nil, files, metaId, datasetId)
 	if err != nil {
+		return 0, err
+	}
+	return inserted + n, nil
+}
+
+// helper function to insert single file within transaction, it returns false
+// if file is already registered
+func insertFile(tx *sql.Tx, f FileInfo, metaId, datasetId int64) (bool, error) {
+	create_at := time.Now().Unix()
+	create_by := "MetaData server"
+	tier := f.Tier
+	if tier == "" {
+		tier = TierRaw
+	}
+	stmt := "INSERT INTO files (file,size,mtime,checksum,entry_type,tier,meta_id,dataset_id,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)"
+	added, err := insertUnique(tx, stmt, f.Name, f.Size, f.Mtime, f.Checksum, f.Type, tier, metaId, datasetId, create_at, create_by, create_at, create_by)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with meta_id=%v name=%s error=%v", stmt, metaId, f.Name, err)
+	}
+	return added, err
+}
+
+// FilesEntry represents dataset files to be registered in FilesDB
+type FilesEntry struct {
+	Did       string
+	Dataset   string
+	Locations []DataLocation
+	Rules     DiscoveryRules
+}
+
+// FilesBatchSize defines number of datasets we insert within single transaction
+var FilesBatchSize = 100
+
+// InsertFilesBatch inserts files of given datasets using batches of
+// transactions, every dataset is inserted within its own savepoint such that
+// failure of one dataset does not affect others, it returns list of errors
+// which corresponds to given entries. Files of the batch are discovered and
+// their checksums are computed before transaction begins to not lock
+// FilesDB for long time.
+func InsertFilesBatch(entries []FilesEntry) []error {
+	errs := make([]error, len(entries))
+	for start := 0; start < len(entries); start += FilesBatchSize {
+		end := start + FilesBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		seqs := make([][]FileSequence, end-start)
+		files := make([][]FileInfo, end-start)
+		for i := start; i < end; i++ {
+			found, _, err := discoverLocations(entries[i].Locations, entries[i].Rules, false)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			seqs[i-start], files[i-start] = splitSequences(found, sequenceMinFiles())
+			setChecksums(files[i-start])
+		}
+		insertEntries(entries[start:end], seqs, files, errs[start:end])
+	}
+	return errs
+}
+
+// helper function to insert files of given entries within single
+// transaction, entries which have already failed are skipped and errors of
+// other entries are stored into given list of errors
+func insertEntries(entries []FilesEntry, seqs [][]FileSequence, files [][]FileInfo, errs []error) {
+	fail := func(err error) {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		fail(err)
+		return
+	}
+	defer tx.Rollback()
+	for i, entry := range entries {
+		if errs[i] != nil {
+			continue
+		}
+		if _, err := tx.Exec("SAVEPOINT dataset"); err != nil {
+			errs[i] = err
+			continue
+		}
+		_, err := insertFiles(tx, entry.Did, entry.Dataset, entry.Rules, seqs[i], files[i])
+		if err == nil {
+			if _, err = tx.Exec("RELEASE SAVEPOINT dataset"); err == nil {
+				continue
+			}
+			log.Printf("ERROR: unable to release savepoint of dataset %s, error=%v", entry.Dataset, err)
+		}
+		errs[i] = err
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT dataset"); err != nil {
+			// state of transaction is unknown, therefore whole batch fails
+			log.Printf("ERROR: unable to rollback savepoint of dataset %s, error=%v", entry.Dataset, err)
+			fail(err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		log.Printf("ERROR: unable to commit, error=%v", err)
-		return tx.Rollback()
+		fail(err)
 	}
-	return nil
 }
 
-// helper function to get list of files
+// helper function to get list of files, sequences are expanded into their
+// frames
 func getFiles(did string) ([]string, error) {
 	var files []string
 	// proceed with transaction operation
@@ -236,7 +422,7 @@ func getFiles(did string) ([]string, error) {
 	// look-up files info
 	//     stmt := "SELECT name FROM files WHERE meta_id=?"
 	stmt := "SELECT F.file FROM files F JOIN metadata M ON M.meta_id=F.meta_id WHERE M.did=?"
-	res, err := tx.Query(stmt, did)
+	res, err := tx.Query(rebind(stmt), did)
 	if err != nil {
 		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
 		return files, tx.Rollback()
@@ -250,6 +436,18 @@ func getFiles(did string) ([]string, error) {
 		}
 		files = append(files, name)
 	}
+	res.Close()
+	tx.Rollback()
+	// frames of sequences
+	seqs, err := getSequences(did, "")
+	if err != nil {
+		return files, err
+	}
+	for _, s := range seqs {
+		for _, f := range s.Expand() {
+			files = append(files, f.Name)
+		}
+	}
 	return files, nil
 }
 
@@ -309,3 +507,471 @@ func getDatasets() ([]string, error) {
 	}
 	return out, nil
 }
+
+// helper function to mark dataset of given did as deleted or restored, the
+// valid files of deleted dataset are marked as deleted and files which
+// were valid before deletion are validated on restore, files in other
+// states are kept intact
+func setDatasetDeleted(did string, deleted bool) error {
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+	modify_at := time.Now().Unix()
+	modify_by := "MetaData server"
+	from, to, flag := FileValid, FileDeleted, 1
+	if !deleted {
+		from, to, flag = FileDeleted, FileValid, 0
+	}
+	stmt := "UPDATE datasets SET is_deleted=? WHERE meta_id IN (SELECT meta_id FROM metadata WHERE did=?)"
+	if _, err := tx.Exec(rebind(stmt), flag, did); err != nil {
+		log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+		return err
+	}
+	for _, table := range []string{"files", "sequences"} {
+		stmt := fmt.Sprintf("UPDATE %s SET is_file_valid=?,modify_at=?,modify_by=? WHERE is_file_valid=? AND meta_id IN (SELECT meta_id FROM metadata WHERE did=?)", table)
+		_, err = tx.Exec(rebind(stmt), to, modify_at, modify_by, from, did)
+		if err != nil {
+			log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return err
+	}
+	return nil
+}
+
+// helper function to delete all FilesDB entries of given did
+func deleteDID(did string) error {
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		"DELETE FROM replicas WHERE file_id IN (SELECT file_id FROM files WHERE meta_id IN (SELECT meta_id FROM metadata WHERE did=?))",
+		"DELETE FROM files WHERE meta_id IN (SELECT meta_id FROM metadata WHERE did=?)",
+		"DELETE FROM sequences WHERE meta_id IN (SELECT meta_id FROM metadata WHERE did=?)",
+		"DELETE FROM datasets WHERE meta_id IN (SELECT meta_id FROM metadata WHERE did=?)",
+		"DELETE FROM metadata WHERE did=?",
+	}
+	for _, stmt := range stmts {
+		_, err = tx.Exec(rebind(stmt), did)
+		if err != nil {
+			log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return err
+	}
+	return nil
+}
+
+// helper function to get number of valid files per dataset
+func datasetFileCounts() (map[string]int, error) {
+	out := make(map[string]int)
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return out, err
+	}
+	defer tx.Rollback()
+	stmt := "SELECT D.dataset, COUNT(F.file_id) FROM datasets D LEFT JOIN files F ON F.dataset_id=D.dataset_id AND F.is_file_valid=1 GROUP BY D.dataset"
+	res, err := tx.Query(stmt)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return out, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var dataset string
+		var nfiles int
+		if err := res.Scan(&dataset, &nfiles); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return out, err
+		}
+		out[dataset] = nfiles
+	}
+	if err := res.Err(); err != nil {
+		return out, err
+	}
+	res.Close()
+	// every frame of a sequence counts as a file
+	stmt = "SELECT D.dataset, SUM(S.frames) FROM datasets D JOIN sequences S ON S.dataset_id=D.dataset_id AND S.is_file_valid=1 GROUP BY D.dataset"
+	res, err = tx.Query(stmt)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return out, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var dataset string
+		var nframes int
+		if err := res.Scan(&dataset, &nframes); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return out, err
+		}
+		out[dataset] += nframes
+	}
+	return out, res.Err()
+}
+
+// helper function to insert name into one of the lookup tables, e.g.
+// parents or processing, and return its id
+func lookupID(tx *sql.Tx, table, column, name string) (int64, error) {
+	create_at := time.Now().Unix()
+	create_by := "MetaData server"
+	stmt := fmt.Sprintf("INSERT INTO %s (%s,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?)", table, column)
+	_, err := insertUnique(tx, stmt, name, create_at, create_by, create_at, create_by)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with %v, error=%v", stmt, name, err)
+		return 0, err
+	}
+	var id int64
+	stmt = fmt.Sprintf("SELECT %s_id FROM %s WHERE %s=?", column, table, column)
+	if err := tx.QueryRow(rebind(stmt), name).Scan(&id); err != nil {
+		log.Printf("ERROR: unable to execute %s with %v, error=%v", stmt, name, err)
+		return 0, err
+	}
+	return id, nil
+}
+
+// setLineage links dataset to its parent dataset and processing which
+// produced it, empty values reset corresponding link
+func setLineage(dataset, parent, processing string) error {
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+	var parentID, processingID sql.NullInt64
+	if parent != "" {
+		id, err := lookupID(tx, "parents", "parent", parent)
+		if err != nil {
+			return err
+		}
+		parentID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	if processing != "" {
+		id, err := lookupID(tx, "processing", "processing", processing)
+		if err != nil {
+			return err
+		}
+		processingID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	modify_at := time.Now().Unix()
+	modify_by := "MetaData server"
+	stmt := "UPDATE datasets SET parent_id=?,processing_id=?,modify_at=?,modify_by=? WHERE dataset=?"
+	res, err := tx.Exec(rebind(stmt), parentID, processingID, modify_at, modify_by, dataset)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with dataset=%v, error=%v", stmt, dataset, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("dataset %s is not found in FilesDB", dataset)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return err
+	}
+	return nil
+}
+
+// helper function to check if dataset is registered in FilesDB
+func datasetExists(dataset string) (bool, error) {
+	var id int64
+	err := FilesDB.QueryRow(rebind("SELECT dataset_id FROM datasets WHERE dataset=?"), dataset).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// helper function to get parent dataset and processing of given dataset
+func datasetParent(dataset string) (string, string, error) {
+	var parent, processing sql.NullString
+	stmt := "SELECT P.parent, R.processing FROM datasets D LEFT JOIN parents P ON P.parent_id=D.parent_id LEFT JOIN processing R ON R.processing_id=D.processing_id WHERE D.dataset=?"
+	err := FilesDB.QueryRow(rebind(stmt), dataset).Scan(&parent, &processing)
+	if err == sql.ErrNoRows {
+		return "", "", fmt.Errorf("dataset %s is not found in FilesDB", dataset)
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+	}
+	return parent.String, processing.String, err
+}
+
+// helper function to get datasets derived from given dataset along with
+// processing which produced them
+func datasetChildren(dataset string) ([]LineageNode, error) {
+	var out []LineageNode
+	stmt := "SELECT D.dataset, R.processing FROM datasets D JOIN parents P ON P.parent_id=D.parent_id LEFT JOIN processing R ON R.processing_id=D.processing_id WHERE P.parent=? ORDER BY D.dataset"
+	res, err := FilesDB.Query(rebind(stmt), dataset)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return out, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var child string
+		var processing sql.NullString
+		if err := res.Scan(&child, &processing); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return out, err
+		}
+		out = append(out, LineageNode{Dataset: child, Processing: processing.String})
+	}
+	return out, res.Err()
+}
+
+// helper function to get list of files of given did along with their
+// attributes, files of all data tiers are returned if tier is empty.
+// Sequences of numbered files are either expanded into their frames or
+// represented by single summary entry.
+func getFileInfos(did, tier string, expand bool) ([]FileInfo, error) {
+	var files []FileInfo
+	stmt := "SELECT F.file, F.size, F.mtime, F.checksum, F.entry_type, F.tier FROM files F JOIN metadata M ON M.meta_id=F.meta_id WHERE M.did=?"
+	args := []any{did}
+	if tier != "" {
+		stmt += " AND F.tier=?"
+		args = append(args, tier)
+	}
+	stmt += " ORDER BY F.file"
+	res, err := FilesDB.Query(rebind(stmt), args...)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return files, err
+	}
+	files, err = scanFileInfos(res)
+	if err != nil {
+		return files, err
+	}
+	seqs, err := getSequences(did, tier)
+	if err != nil || len(seqs) == 0 {
+		return files, err
+	}
+	for _, s := range seqs {
+		if expand {
+			files = append(files, s.Expand()...)
+		} else {
+			files = append(files, s.Info())
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// helper function to scan file, size, mtime, checksum, entry_type and tier rows
+func scanFileInfos(res *sql.Rows) ([]FileInfo, error) {
+	var files []FileInfo
+	defer res.Close()
+	for res.Next() {
+		var name string
+		var size, mtime sql.NullInt64
+		var checksum, etype, tier sql.NullString
+		if err := res.Scan(&name, &size, &mtime, &checksum, &etype, &tier); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return files, err
+		}
+		files = append(files, FileInfo{
+			Name:     name,
+			Size:     size.Int64,
+			Mtime:    mtime.Int64,
+			Checksum: checksum.String,
+			Type:     etype.String,
+			Tier:     tier.String,
+		})
+	}
+	return files, res.Err()
+}
+
+// helper function to get meta_id and dataset_id of given did
+func datasetIDs(tx *sql.Tx, did string) (int64, int64, error) {
+	var metaId, datasetId int64
+	stmt := "SELECT M.meta_id, D.dataset_id FROM metadata M JOIN datasets D ON D.meta_id=M.meta_id WHERE M.did=?"
+	if err := tx.QueryRow(rebind(stmt), did).Scan(&metaId, &datasetId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, 0, fmt.Errorf("did %s is not found in FilesDB", did)
+		}
+		log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+		return 0, 0, err
+	}
+	return metaId, datasetId, nil
+}
+
+// syncFiles synchronizes FilesDB entries of given did with files found on
+// disk: new files are inserted, vanished files are invalidated and files
+// with changed size or modification time are updated. Checksums are
+// computed only for new and changed files. Only files of given data tiers,
+// whose locations were scanned, are invalidated. Sequences of numbered files
+// are synchronized as a whole, see syncSequences.
+func syncFiles(did string, tiers []string, files []FileInfo, report *RescanReport) error {
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	metaId, datasetId, err := datasetIDs(tx, did)
+	if err != nil {
+		return err
+	}
+
+	// existing files of the dataset
+	type fileRow struct {
+		ID    int64
+		Size  int64
+		Mtime int64
+		Valid bool
+		Tier  string
+	}
+	rows := make(map[string]fileRow)
+	stmt := "SELECT file_id, file, size, mtime, is_file_valid, tier FROM files WHERE meta_id=?"
+	res, err := tx.Query(rebind(stmt), metaId)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+		return err
+	}
+	for res.Next() {
+		var id int64
+		var name string
+		var size, mtime, valid sql.NullInt64
+		var tier sql.NullString
+		if err := res.Scan(&id, &name, &size, &mtime, &valid, &tier); err != nil {
+			res.Close()
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return err
+		}
+		rows[name] = fileRow{ID: id, Size: size.Int64, Mtime: mtime.Int64, Valid: valid.Int64 == 1, Tier: tier.String}
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	// files which are not registered individually may belong to sequences
+	var known, unknown []FileInfo
+	for _, f := range files {
+		if _, ok := rows[f.Name]; ok {
+			known = append(known, f)
+		} else {
+			unknown = append(unknown, f)
+		}
+	}
+	rest, err := syncSequences(tx, metaId, datasetId, tiers, unknown, report)
+	if err != nil {
+		return err
+	}
+	files = append(known, rest...)
+
+	modify_at := time.Now().Unix()
+	modify_by := "MetaData server"
+	ustmt := "UPDATE files SET size=?,mtime=?,checksum=?,entry_type=?,tier=?,is_file_valid=1,modify_at=?,modify_by=? WHERE file_id=?"
+	found := make(map[string]bool)
+	for _, f := range files {
+		found[f.Name] = true
+		row, ok := rows[f.Name]
+		if !ok {
+			f.setChecksum()
+			added, err := insertFile(tx, f, metaId, datasetId)
+			if err != nil {
+				return err
+			}
+			if added {
+				report.Added = append(report.Added, f.Name)
+			} else {
+				report.Skipped = append(report.Skipped, f.Name)
+			}
+			continue
+		}
+		if row.Valid && row.Size == f.Size && row.Mtime == f.Mtime && row.Tier == f.Tier {
+			report.Unchanged++
+			continue
+		}
+		f.setChecksum()
+		if _, err := tx.Exec(rebind(ustmt), f.Size, f.Mtime, f.Checksum, f.Type, f.Tier, modify_at, modify_by, row.ID); err != nil {
+			log.Printf("ERROR: unable to execute %s with file=%v, error=%v", ustmt, f.Name, err)
+			return err
+		}
+		if row.Valid {
+			report.Updated = append(report.Updated, f.Name)
+		} else {
+			report.Restored = append(report.Restored, f.Name)
+		}
+	}
+
+	// invalidate files which are gone
+	stmt = "UPDATE files SET is_file_valid=0,modify_at=?,modify_by=? WHERE file_id=?"
+	for _, name := range SortedKeys(rows) {
+		if row := rows[name]; row.Valid && !found[name] && InList(row.Tier, tiers) {
+			if _, err := tx.Exec(rebind(stmt), modify_at, modify_by, row.ID); err != nil {
+				log.Printf("ERROR: unable to execute %s with file=%v, error=%v", stmt, name, err)
+				return err
+			}
+			report.Removed = append(report.Removed, name)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return err
+	}
+	return nil
+}
+
+// helper function to get cycle, beamline, BTR and sample columns of
+// dataset, the columns are NULL for datasets of other forms
+func datasetColumns(dataset string) (any, any, any, any) {
+	parts := datasetParts(dataset)
+	if parts == nil {
+		return nil, nil, nil, nil
+	}
+	return parts[0], parts[1], parts[2], parts[3]
+}
+
+// fillDatasetParts fills cycle, beamline, BTR and sample columns of
+// datasets registered before these columns were introduced
+func fillDatasetParts(tx *sql.Tx) error {
+	datasets := make(map[int64]string)
+	stmt := "SELECT dataset_id, dataset FROM datasets WHERE cycle IS NULL"
+	res, err := tx.Query(stmt)
+	if err != nil {
+		return err
+	}
+	for res.Next() {
+		var id int64
+		var dataset string
+		if err := res.Scan(&id, &dataset); err != nil {
+			res.Close()
+			return err
+		}
+		datasets[id] = dataset
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+	stmt = "UPDATE datasets SET cycle=?,beamline=?,btr=?,sample=? WHERE dataset_id=?"
+	for id, dataset := range datasets {
+		cycle, beamline, btr, sample := datasetColumns(dataset)
+		if cycle == nil {
+			continue
+		}
+		if _, err := tx.Exec(rebind(stmt), cycle, beamline, btr, sample, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
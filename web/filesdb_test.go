@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -30,29 +31,37 @@ func TestFilesDB(t *testing.T) {
 	dataset := fmt.Sprintf("/%s/%s/%s/%s", cycle, beamline, btr, sample)
 	path := "/tmp"
 	path = filepath.Join("/tmp", os.Getenv("USER")) // for testing purposes
-	files := FindFiles(path)
-	if len(files) == 0 {
+	files, err := DiscoverFiles(path, DiscoveryRules{}, true)
+	if err != nil || len(files) == 0 {
 		t.Errorf("Unable to find any files in directory=%s", path)
 	}
 
-	err = InsertFiles(did, dataset, path)
+	err = InsertFiles(did, dataset, path, DiscoveryRules{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	files, err = getFiles(did)
+	files, err = getFileInfos(did, "", false)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if len(files) == 0 {
 		t.Errorf("No files found in database for did=%v", did)
 	}
+	for _, f := range files {
+		if f.Type == "" || f.Mtime == 0 {
+			t.Errorf("No attributes found in database for file %+v", f)
+		}
+		if f.Type == EntryFile && !strings.HasPrefix(f.Checksum, "adler32:") {
+			t.Errorf("No checksum found in database for file %+v", f)
+		}
+	}
 
 	// insert another dataset with different cycle
 	did = "456"
 	cycle = "cycle-2"
 	dataset = fmt.Sprintf("/%s/%s/%s/%s", cycle, beamline, btr, sample)
 	path = filepath.Join("/tmp", os.Getenv("USER")) // for testing purposes
-	err = InsertFiles(did, dataset, path)
+	err = InsertFiles(did, dataset, path, DiscoveryRules{})
 	if err != nil {
 		t.Fatal(err)
 	}
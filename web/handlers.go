@@ -6,6 +6,7 @@ package main
 //
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,10 +22,12 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/process"
+	bson "go.mongodb.org/mongo-driver/bson"
 	primitive "go.mongodb.org/mongo-driver/bson/primitive" // for BSON ObjectID
 
 	"gopkg.in/jcmturner/gokrb5.v7/credentials"
@@ -59,6 +62,10 @@ func jsonResponse(w http.ResponseWriter, err error, status int) {
 	if err != nil {
 		rec["error"] = err.Error()
 	}
+	if cerr, ok := isConflict(err); ok {
+		rec["conflict"] = cerr
+	}
+	status = errorStatus(err, status)
 	rec["status"] = status
 	w.WriteHeader(status)
 	if body, err := json.Marshal(rec); err == nil {
@@ -203,6 +210,9 @@ func SchemasHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// _searchLimits defines number of records per page user can choose in search form
+var _searchLimits = []int{10, 25, 50, 100}
+
 // SearchHandler handlers Search requests
 func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	var user string
@@ -225,9 +235,13 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	var templates Templates
 	tmplData := makeTmplData()
 
-	// if we got GET request it is /search web form
-	if r.Method == "GET" {
+	// if we got GET request without query it is /search web form
+	query := r.FormValue("query")
+	filters := r.Form["filter"]
+	tmplData["Limits"] = _searchLimits
+	if r.Method == "GET" && query == "" && len(filters) == 0 {
 		tmplData["Query"] = ""
+		tmplData["Limit"] = _searchLimits[2]
 		tmplData["User"] = user
 		page := templates.Tmpl(Config.Templates, "searchform.tmpl", tmplData)
 		w.WriteHeader(http.StatusOK)
@@ -235,25 +249,85 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// if we get POST request we'll process user query
-	query := r.FormValue("query")
-	spec, err := ParseQuery(query)
+	// otherwise we'll process user query, the facet filters refine it
+	spec := bson.M{}
+	if query != "" || len(filters) == 0 {
+		spec, err = ParseQuery(query)
+	}
+	if err == nil {
+		var fspec bson.M
+		fspec, err = ParseFilters(filters)
+		spec = filterSpec(spec, fspec)
+	}
 	if Config.Verbose > 0 {
-		log.Printf("search query='%s' spec=%+v user=%v", query, spec, user)
+		log.Printf("search query='%s' filters=%v spec=%+v user=%v", query, filters, spec, user)
 	}
 	if err != nil {
 		msg := "unable to parse user query"
 		handleError(w, r, msg, err)
 		return
 	}
+	client := r.FormValue("client")
+	sortParams := r.Form["sort"]
+	skeys, err := ParseSort(sortParams)
+	if err != nil {
+		if client == "cli" {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		msg := "unable to parse sort parameters"
+		handleError(w, r, msg, err)
+		return
+	}
+	sortValue := strings.Join(sortParams, ",")
+
+	// deleted records are only visible in trash
+	spec = activeSpec(spec)
+
+	// get pagination parameters, cli clients get all records by default
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		limit = _searchLimits[2]
+		if client == "cli" {
+			limit = -1
+		}
+	}
+	idx, err := strconv.Atoi(r.FormValue("idx"))
+	if err != nil || idx < 0 {
+		idx = 0
+	}
 
 	// check if we use web or cli
-	if client := r.FormValue("client"); client == "cli" {
-		var records []Record
+	if client == "cli" {
+		records := []Record{}
 		if spec != nil {
-			records = MongoGet(Config.DBName, Config.DBColl, spec, 0, -1)
+			nrec, err := MongoCount(r.Context(), Config.DBName, Config.DBColl, spec)
+			if err != nil {
+				jsonResponse(w, err, http.StatusInternalServerError)
+				return
+			}
+			records, err = GetSorted(r.Context(), Config.DBName, Config.DBColl, spec, skeys, idx, limit)
+			if err != nil {
+				jsonResponse(w, err, http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("X-Total-Count", fmt.Sprintf("%d", nrec))
+		}
+		var data []byte
+		if r.FormValue("facets") == "true" {
+			// cli clients may ask for facets along with records
+			var facets []Facet
+			if spec != nil {
+				facets, err = computeFacets(r.Context(), spec, facetKeys())
+				if err != nil {
+					jsonResponse(w, err, http.StatusInternalServerError)
+					return
+				}
+			}
+			data, err = json.Marshal(Record{"records": records, "facets": facets})
+		} else {
+			data, err = json.Marshal(records)
 		}
-		data, err := json.Marshal(records)
 		if err != nil {
 			w.Write([]byte(fmt.Sprintf("unable to marshal data, error=%v", err)))
 			return
@@ -262,45 +336,55 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write(data)
 		return
 	}
-	// get form parameters
-	limit, err := strconv.Atoi(r.FormValue("limit"))
-	if err != nil {
-		limit = 50
-	}
-	idx, err := strconv.Atoi(r.FormValue("idx"))
-	if err != nil {
-		idx = 0
-	}
 
 	tmplData["Query"] = query
+	tmplData["Sort"] = sortValue
+	tmplData["Limit"] = limit
+	tmplData["Filters"] = filters
 	tmplData["User"] = user
 	page := templates.Tmpl(Config.Templates, "searchform.tmpl", tmplData)
 
 	// process the query
 	if spec != nil {
-		nrec := MongoCount(Config.DBName, Config.DBColl, spec)
-		records := MongoGet(Config.DBName, Config.DBColl, spec, 0, -1)
-		var pager string
+		nrec, err := MongoCount(r.Context(), Config.DBName, Config.DBColl, spec)
+		if err != nil {
+			handleError(w, r, "unable to count records", err)
+			return
+		}
+		records, err := GetSorted(r.Context(), Config.DBName, Config.DBColl, spec, skeys, idx, limit)
+		if err != nil {
+			handleError(w, r, "unable to get records", err)
+			return
+		}
+		facets, err := computeFacets(r.Context(), spec, facetKeys())
+		if err != nil {
+			log.Printf("ERROR: unable to compute facets, error %v", err)
+		}
+		var results, pager string
 		if nrec > 0 {
-			pager = pagination(query, nrec, idx, limit)
-			page = fmt.Sprintf("%s<br><br>%s", page, pager)
+			pager = pagination(query, sortValue, filters, nrec, idx, limit)
+			results = pager
 		} else {
-			page = fmt.Sprintf("%s<br><br>No results found</br>", page)
+			results = "No results found</br>"
 		}
 		for _, rec := range records {
 			oid := rec["_id"].(primitive.ObjectID)
 			rec["_id"] = oid
 			tmplData["Id"] = oid.Hex()
 			tmplData["Did"] = rec["did"]
+			tmplData["Dataset"] = rec["dataset"]
+			tmplData["Revision"] = recordRevision(rec)
 			tmplData["RecordString"] = rec.ToString()
 			tmplData["Record"] = rec.ToJSON()
 			tmplData["Description"] = fmt.Sprintf("update on %s", time.Now().String())
 			prec := templates.Tmpl(Config.Templates, "record.tmpl", tmplData)
-			page = fmt.Sprintf("%s<br>%s", page, prec)
+			results = fmt.Sprintf("%s<br>%s", results, prec)
 		}
-		if nrec > 5 {
-			page = fmt.Sprintf("%s<br><br>%s", page, pager)
+		if len(records) > 5 {
+			results = fmt.Sprintf("%s<br><br>%s", results, pager)
 		}
+		sidebar := facetsSidebar(query, sortValue, filters, limit, facets)
+		page = fmt.Sprintf("%s<br><br><div class=\"is-row\"><div class=\"is-col is-20\">%s</div><div class=\"is-col is-80\">%s</div></div>", page, sidebar, results)
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(_top + page + _bottom))
@@ -669,6 +753,68 @@ type Mem struct {
 	Swap    Memory
 }
 
+// StatsHandler provides aggregation statistics of records and files grouped
+// by schema keys, the keys can be provided via keys parameter
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	keys, err := parseStatsKeys(r.URL.Query()["keys"])
+	if err != nil {
+		if jsonRequest(r) {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		handleError(w, r, "invalid stats keys", err)
+		return
+	}
+	report, err := _statsCache.Get(r.Context(), keys)
+	if jsonRequest(r) {
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		data, err := json.Marshal(report)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	if err != nil {
+		handleError(w, r, "unable to get statistics", err)
+		return
+	}
+	// prepare groups for the template
+	var groups [][]any
+	for _, g := range report.Groups {
+		var row []any
+		for _, k := range keys {
+			if v := g.Keys[k]; v != nil {
+				row = append(row, v)
+			} else {
+				row = append(row, "")
+			}
+		}
+		row = append(row, g.Records, g.Files)
+		groups = append(groups, row)
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Keys"] = keys
+	tmplData["KeysValue"] = strings.Join(keys, ",")
+	tmplData["Groups"] = groups
+	tmplData["Records"] = report.Records
+	tmplData["Files"] = report.Files
+	tmplData["Time"] = TimeFormat(report.Timestamp)
+	page := templates.Tmpl(Config.Templates, "stats.tmpl", tmplData)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(_top + page + _bottom))
+}
+
 // StatusHandler handlers Status requests
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
@@ -880,13 +1026,21 @@ func processForm(r *http.Request) (string, Record, error) {
 		if Config.Verbose > 0 {
 			log.Println("### PostForm", k, items)
 		}
-		if k == "SchemaName" {
+		// record id and revision are handled by the update handler
+		if k == "SchemaName" || k == "_id" || k == "revision" {
 			continue
 		}
 		if k == "Description" {
 			desc = strings.Join(items, " ")
 			continue
 		}
+		// lineage attributes are optional and not part of the schema
+		if k == "Parent" || k == "Processing" {
+			if v := strings.TrimSpace(strings.Join(items, "")); v != "" {
+				rec[k] = v
+			}
+			continue
+		}
 		val, err := parseValue(schema, k, items)
 		if err != nil {
 			// check if given key is mandatory or optional
@@ -943,11 +1097,13 @@ func ProcessHandler(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(_top + page + _bottom))
 			return
 		}
-		err = insertData(schema, rec)
+		var jid string
+		jid, err = insertData(r.Context(), schema, rec)
 		if err == nil {
 			msg = fmt.Sprintf("Your meta-data is inserted successfully")
 			log.Println("INFO", msg)
 			class = "alert is-success"
+			tmplData["Job"] = jid
 		} else {
 			//             msg = fmt.Sprintf("Web processing error: %v", err)
 			msg = fmt.Sprintf("ERROR: %v", err)
@@ -963,7 +1119,7 @@ func ProcessHandler(w http.ResponseWriter, r *http.Request) {
 			tmplData["Id"] = ""
 			tmplData["Description"] = fmt.Sprintf("update on %s", time.Now().String())
 			page += templates.Tmpl(Config.Templates, "update.tmpl", tmplData)
-			w.WriteHeader(http.StatusOK)
+			w.WriteHeader(errorStatus(err, http.StatusOK))
 			w.Write([]byte(_top + page + _bottom))
 			return
 		}
@@ -1028,13 +1184,13 @@ func APIHandler(w http.ResponseWriter, r *http.Request) {
 					handleError(w, r, msg, err)
 					return
 				}
-				err = insertData(schema, data)
+				jid, err := insertData(r.Context(), schema, data)
 				if err != nil {
 					msg := "unable to insert data"
 					handleError(w, r, msg, err)
 					return
 				}
-				msg := fmt.Sprintf("Successfully inserted:\n%v", data.ToString())
+				msg := fmt.Sprintf("Successfully inserted:\n%v\nfiles are registered by job %s", data.ToString(), jid)
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte(msg))
 				return
@@ -1054,12 +1210,19 @@ func APIHandler(w http.ResponseWriter, r *http.Request) {
 			jsonResponse(w, err, http.StatusBadRequest)
 			return
 		}
-		err = insertData(schema, data)
+		// lineage attributes can be provided along with the record
+		if v := r.FormValue("parent"); v != "" {
+			data["Parent"] = v
+		}
+		if v := r.FormValue("processing"); v != "" {
+			data["Processing"] = v
+		}
+		jid, err := insertData(r.Context(), schema, data)
 		if err != nil {
 			jsonResponse(w, err, http.StatusBadRequest)
 			return
 		}
-		jsonResponse(w, nil, http.StatusOK)
+		jobResponse(w, jid)
 		return
 	}
 
@@ -1072,7 +1235,8 @@ func APIHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	var msg, class string
+	var msg, class, job string
+	status := http.StatusOK
 	defer r.Body.Close()
 	body, err := io.ReadAll(file)
 	if err != nil {
@@ -1085,13 +1249,15 @@ func APIHandler(w http.ResponseWriter, r *http.Request) {
 			msg = fmt.Sprintf("error: %v, unable to parse request data", err)
 			class = "alert is-error"
 		} else {
-			err := insertData(schema, data)
+			jid, err := insertData(r.Context(), schema, data)
 			if err == nil {
 				msg = fmt.Sprintf("meta-data is inserted successfully")
 				class = "alert is-success"
+				job = jid
 			} else {
 				msg = fmt.Sprintf("ERROR: %v", err)
 				class = "alert is-error"
+				status = errorStatus(err, status)
 			}
 		}
 	}
@@ -1100,8 +1266,9 @@ func APIHandler(w http.ResponseWriter, r *http.Request) {
 	tmplData["Schema"] = schemaName(schema)
 	tmplData["Message"] = msg
 	tmplData["Class"] = class
+	tmplData["Job"] = job
 	page := templates.Tmpl(Config.Templates, "confirm.tmpl", tmplData)
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	w.Write([]byte(_top + page + _bottom))
 }
 
@@ -1172,9 +1339,21 @@ func UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	// we will prepare input entries for the template
 	// where each entry represented in form of template.HTML
 	// to avoid escaping of HTML characters
-	inputs := htmlInputs(rec)
+	// server assigned keys are not editable and preserved by the update
+	data := make(Record)
+	for k, v := range rec {
+		if !InList(k, _serverKeys) && !InList(k, _skipKeys) {
+			data[k] = v
+		}
+	}
+	inputs := htmlInputs(data)
 	tmplData["Inputs"] = inputs
 	tmplData["Id"] = r.FormValue("_id")
+	tmplData["SchemaName"] = rec["Schema"]
+	tmplData["Revision"] = recordRevision(rec)
+	if val := r.FormValue("revision"); val != "" {
+		tmplData["Revision"] = val
+	}
 	tmplData["Description"] = fmt.Sprintf("update on %s", time.Now().String())
 	page := templates.Tmpl(Config.Templates, "update.tmpl", tmplData)
 	w.WriteHeader(http.StatusOK)
@@ -1193,6 +1372,7 @@ func UpdateRecordHandler(w http.ResponseWriter, r *http.Request) {
 	tmplData["User"] = user
 	var msg, cls, schema string
 	var rec Record
+	status := http.StatusOK
 	if err := r.ParseForm(); err == nil {
 		schema, rec, err = processForm(r)
 		if err != nil {
@@ -1209,23 +1389,34 @@ func UpdateRecordHandler(w http.ResponseWriter, r *http.Request) {
 		// delete record id before the update
 		delete(rec, "_id")
 		if rid == "" {
-			err := insertData(schema, rec)
+			jid, err := insertData(r.Context(), schema, rec)
 			if err == nil {
 				msg = fmt.Sprintf("Your meta-data is inserted successfully")
 				cls = "alert is-success"
+				tmplData["Job"] = jid
 			} else {
 				//                 msg = fmt.Sprintf("update web processing error: %v", err)
 				msg = fmt.Sprintf("ERROR: %v", err)
 				cls = "alert is-error"
+				status = errorStatus(err, status)
 			}
 		} else {
 			msg = fmt.Sprintf("record %v is successfully updated", rid)
-			log.Println("MongoUpsert", rec)
-			records := []Record{rec}
-			err = MongoUpsert(Config.DBName, Config.DBColl, "dataset", records)
+			revision, ok, err := requestRevision(r)
+			if err == nil && !ok {
+				err = errors.New("record revision is not provided")
+			}
+			if err == nil {
+				_, err = updateRecord(r.Context(), rid, rec, revision)
+			}
+			if cerr, ok := isConflict(err); ok {
+				conflictResponse(w, r, cerr)
+				return
+			}
 			if err != nil {
 				msg = fmt.Sprintf("record %v update is failed, reason: %v", rid, err)
 				cls = "is-error"
+				status = errorStatus(err, status)
 			} else {
 				cls = "is-success"
 			}
@@ -1238,11 +1429,108 @@ func UpdateRecordHandler(w http.ResponseWriter, r *http.Request) {
 	tmplData["Message"] = strings.ToTitle(msg)
 	tmplData["Class"] = fmt.Sprintf("alert %s is-large is-text-center", cls)
 	page := templates.Tmpl(Config.Templates, "confirm.tmpl", tmplData)
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
+	w.Write([]byte(_top + page + _bottom))
+}
+
+// helper function to write response for update of a record based on stale
+// revision, the page shows changes made since that revision
+func conflictResponse(w http.ResponseWriter, r *http.Request, cerr *ConflictError) {
+	if jsonRequest(r) {
+		jsonResponse(w, cerr, http.StatusConflict)
+		return
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Id"] = cerr.RecordID
+	tmplData["Dataset"] = cerr.Dataset
+	tmplData["Revision"] = cerr.Revision
+	tmplData["Current"] = cerr.Current
+	tmplData["Diff"] = tmplDiffs(cerr.Changes)
+	if rec, err := findRecord(r.Context(), cerr.RecordID); err == nil {
+		tmplData["Record"] = rec.ToJSON()
+	}
+	page := templates.Tmpl(Config.Templates, "conflict.tmpl", tmplData)
+	w.WriteHeader(http.StatusConflict)
 	w.Write([]byte(_top + page + _bottom))
 }
 
-// FilesHandler handlers Files requests
+// RecordHandler provides meta-data record in JSON data-format, the ETag
+// header holds record revision which clients should pass back via If-Match
+// header (or revision key of the record) when they update the record with
+// PUT request
+func RecordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		putRecord(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rid := mux.Vars(r)["id"]
+	rec, err := findRecord(r.Context(), rid)
+	if err != nil {
+		jsonResponse(w, err, http.StatusNotFound)
+		return
+	}
+	if val := r.Header.Get("If-None-Match"); val != "" && val == recordETag(rec) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rec["_id"] = recordID(rec)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", recordETag(rec))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// helper function to update meta-data record provided in JSON data-format
+func putRecord(w http.ResponseWriter, r *http.Request) {
+	rid := mux.Vars(r)["id"]
+	defer r.Body.Close()
+	var rec Record
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	revision, ok, err := requestRevision(r)
+	if err != nil {
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if val, found := rec["revision"]; found && !ok {
+		revision, ok = intValue(val), true
+	}
+	if !ok {
+		err := errors.New("record revision is not provided, use If-Match header or revision key")
+		jsonResponse(w, err, http.StatusPreconditionRequired)
+		return
+	}
+	delete(rec, "_id")
+	user, _ := username(r)
+	rec["User"] = user
+	rec["Date"] = time.Now().Unix()
+	if _, found := rec["Description"]; !found {
+		rec["Description"] = fmt.Sprintf("update on %s", time.Now().String())
+	}
+	rec, err = updateRecord(r.Context(), rid, rec, revision)
+	if err != nil {
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("ETag", recordETag(rec))
+	jsonResponse(w, nil, http.StatusOK)
+}
+
+// FilesHandler handlers Files requests, files can be filtered by data tier
+// via tier parameter, e.g. /files?did=123&tier=REDUCED, sequences of
+// numbered files are summarized unless expand=true parameter is given
 func FilesHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := username(r)
 	if !Config.TestMode && err != nil {
@@ -1259,15 +1547,36 @@ func FilesHandler(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(msg))
 			return
 		}
-		files, err := getFiles(did)
+		tier, err := parseTier(r.FormValue("tier"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		expand := r.FormValue("expand") == "true"
+		files, err := getFileInfos(did, tier, expand)
 		if err != nil {
 			msg := fmt.Sprintf("Unable to get files\nError: %v", err)
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte(msg))
 			return
 		}
+		if jsonRequest(r) {
+			data, err := json.Marshal(files)
+			if err != nil {
+				jsonResponse(w, err, http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+			return
+		}
+		var lines []string
+		for _, f := range files {
+			lines = append(lines, f.String())
+		}
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(strings.Join(files, "\n")))
+		w.Write([]byte(strings.Join(lines, "\n")))
 		return
 	}
 	var templates Templates
@@ -1281,7 +1590,17 @@ func FilesHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(_top + page + _bottom))
 		return
 	}
-	files, err := getFiles(did)
+	tier, err := parseTier(r.FormValue("tier"))
+	if err != nil {
+		tmplData["Message"] = err.Error()
+		tmplData["Class"] = "alert is-error is-large is-text-center"
+		page := templates.Tmpl(Config.Templates, "confirm.tmpl", tmplData)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(_top + page + _bottom))
+		return
+	}
+	expand := r.FormValue("expand") == "true"
+	files, err := getFileInfos(did, tier, expand)
 	if err != nil {
 		tmplData["Message"] = fmt.Sprintf("Unable to query FilesDB\nError: %v", err)
 		tmplData["Class"] = "alert is-error is-large is-text-center"
@@ -1292,8 +1611,592 @@ func FilesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	tmplData["Id"] = r.FormValue("_id")
 	tmplData["Did"] = did
-	tmplData["Files"] = strings.Join(files, "\n")
+	tmplData["Tier"] = tier
+	tmplData["Expand"] = expand
+	tmplData["Files"] = files
+	tmplData["NumberOfFiles"] = numberOfFiles(files)
+	tmplData["TotalSize"] = SizeFormat(totalSize(files))
 	page := templates.Tmpl(Config.Templates, "files.tmpl", tmplData)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(_top + page + _bottom))
 }
+
+// RecordHistoryHandler handles record history requests
+func RecordHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rid := mux.Vars(r)["id"]
+	versions, err := recordHistory(r.Context(), rid)
+	if jsonRequest(r) {
+		if err != nil {
+			jsonResponse(w, err, http.StatusNotFound)
+			return
+		}
+		data, err := json.Marshal(versions)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	if err != nil {
+		msg := fmt.Sprintf("unable to get history of record %s", rid)
+		handleError(w, r, msg, err)
+		return
+	}
+	// prepare versions for the template, we show latest version first
+	var vers []map[string]any
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		ver := map[string]any{
+			"Version":     v.Version,
+			"User":        v.User,
+			"Time":        "",
+			"Description": v.Description,
+			"Current":     v.Current,
+			"Diff":        tmplDiffs(v.Diff),
+		}
+		if v.Timestamp > 0 {
+			ver["Time"] = TimeFormat(v.Timestamp)
+		}
+		vers = append(vers, ver)
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Id"] = rid
+	tmplData["Dataset"] = versions[len(versions)-1].Record["dataset"]
+	tmplData["Revision"] = recordRevision(versions[len(versions)-1].Record)
+	tmplData["Versions"] = vers
+	page := templates.Tmpl(Config.Templates, "history.tmpl", tmplData)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(_top + page + _bottom))
+}
+
+// helper function to prepare record diff for templates
+func tmplDiffs(fdiffs []FieldDiff) []map[string]any {
+	var diffs []map[string]any
+	for _, d := range fdiffs {
+		diff := map[string]any{"Key": d.Key, "Action": d.Action, "Old": "", "New": ""}
+		if d.Old != nil {
+			diff["Old"] = fmt.Sprintf("%v", d.Old)
+		}
+		if d.New != nil {
+			diff["New"] = fmt.Sprintf("%v", d.New)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// RecordRevertHandler handles revert of a record to its previous version
+func RecordRevertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rid := mux.Vars(r)["id"]
+	user, _ := username(r)
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err == nil {
+		// the revert is applied only to revision the client has seen
+		revision, ok, rerr := requestRevision(r)
+		if rerr == nil && !ok {
+			err := errors.New("record revision is not provided, use If-Match header or revision key")
+			if jsonRequest(r) {
+				jsonResponse(w, err, http.StatusPreconditionRequired)
+				return
+			}
+			handleError(w, r, fmt.Sprintf("unable to revert record %s", rid), err)
+			return
+		}
+		err = rerr
+		if err == nil {
+			_, err = managedRecord(r.Context(), rid, user)
+		}
+		if err == nil {
+			_, err = revertRecord(r.Context(), rid, version, revision, user)
+		}
+	}
+	if cerr, ok := isConflict(err); ok {
+		conflictResponse(w, r, cerr)
+		return
+	}
+	if jsonRequest(r) {
+		if err != nil {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		jsonResponse(w, nil, http.StatusOK)
+		return
+	}
+	if err != nil {
+		msg := fmt.Sprintf("unable to revert record %s", rid)
+		handleError(w, r, msg, err)
+		return
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Message"] = strings.ToTitle(fmt.Sprintf("record %s is reverted to version %d", rid, version))
+	tmplData["Class"] = "alert is-success is-large is-text-center"
+	page := templates.Tmpl(Config.Templates, "confirm.tmpl", tmplData)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(_top + page + _bottom))
+}
+
+// helper function to perform given action on a record and write its response
+func recordAction(w http.ResponseWriter, r *http.Request, action string, f func(ctx context.Context, rid, user string) error) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rid := mux.Vars(r)["id"]
+	user, _ := username(r)
+	err := f(r.Context(), rid, user)
+	if jsonRequest(r) {
+		if err != nil {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		jsonResponse(w, nil, http.StatusOK)
+		return
+	}
+	if err != nil {
+		msg := fmt.Sprintf("unable to %s record %s", action, rid)
+		handleError(w, r, msg, err)
+		return
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Message"] = strings.ToTitle(fmt.Sprintf("record %s, %s operation is successful", rid, action))
+	tmplData["Class"] = "alert is-success is-large is-text-center"
+	page := templates.Tmpl(Config.Templates, "confirm.tmpl", tmplData)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(_top + page + _bottom))
+}
+
+// RecordDeleteHandler handles deletion of a record
+func RecordDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	recordAction(w, r, "delete", deleteRecord)
+}
+
+// RecordRestoreHandler handles restore of deleted record
+func RecordRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	recordAction(w, r, "restore", restoreRecord)
+}
+
+// RecordPurgeHandler handles permanent removal of deleted record
+func RecordPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	recordAction(w, r, "purge", purgeRecord)
+}
+
+// TrashHandler handles trash requests
+func TrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user, _ := username(r)
+	records, err := trashRecords(r.Context(), user)
+	if err != nil {
+		if jsonRequest(r) {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		handleError(w, r, "unable to get deleted records", err)
+		return
+	}
+	if jsonRequest(r) {
+		data, err := json.Marshal(records)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	var entries []map[string]any
+	for _, rec := range records {
+		entries = append(entries, map[string]any{
+			"Id":        recordID(rec),
+			"Dataset":   rec["dataset"],
+			"Did":       rec["did"],
+			"User":      rec["User"],
+			"DeletedBy": rec["deleted_by"],
+			"DeletedAt": TimeFormat(rec["deleted_at"]),
+		})
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["User"] = user
+	tmplData["Records"] = entries
+	page := templates.Tmpl(Config.Templates, "trash.tmpl", tmplData)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(_top + page + _bottom))
+}
+
+// IndexesHandler reports status of meta-data store indexes and reconciles
+// them on POST requests, it is only available to server admins
+func IndexesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := username(r)
+	if !isAdmin(user) {
+		err := fmt.Errorf("user %s is not allowed to manage indexes", user)
+		if jsonRequest(r) {
+			jsonResponse(w, err, http.StatusForbidden)
+			return
+		}
+		handleError(w, r, "access denied", err)
+		return
+	}
+	var report []IndexStatus
+	var err error
+	if r.Method == "POST" {
+		report, err = EnsureIndexes(r.Context())
+	} else {
+		report, err = IndexReport(r.Context())
+	}
+	if jsonRequest(r) {
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		data, err := json.Marshal(report)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	var indexes []map[string]any
+	for _, s := range report {
+		idx := map[string]any{
+			"Collection": s.Index.Collection,
+			"Name":       s.Index.Name,
+			"Keys":       strings.Join(s.Index.Keys, ", "),
+			"Unique":     s.Index.Unique,
+			"Existing":   "",
+			"Status":     s.Status,
+			"Error":      s.Error,
+		}
+		if s.Index.Text {
+			idx["Keys"] = fmt.Sprintf("text %v", s.Index.Weights)
+		}
+		if s.Existing != nil {
+			idx["Existing"] = s.Existing.String()
+		}
+		indexes = append(indexes, idx)
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Indexes"] = indexes
+	if err != nil {
+		tmplData["Error"] = err.Error()
+	}
+	page := templates.Tmpl(Config.Templates, "indexes.tmpl", tmplData)
+	w.WriteHeader(errorStatus(err, http.StatusOK))
+	w.Write([]byte(_top + page + _bottom))
+}
+
+// BulkHandler handles bulk insert of meta-data records, it accepts either
+// JSON array or NDJSON of records for given schema and returns status of
+// every record
+func BulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var user string
+	var err error
+	if Config.TestMode {
+		user = "test"
+	} else {
+		user, err = username(r)
+		if err != nil {
+			creds, err := getUserCredentials(r)
+			if err != nil {
+				jsonResponse(w, err, http.StatusUnauthorized)
+				return
+			}
+			user = creds.UserName()
+		}
+	}
+	sname := r.FormValue("SchemaName")
+	if sname == "" {
+		sname = r.FormValue("schema")
+	}
+	if sname == "" {
+		jsonResponse(w, errors.New("client does not provide schema name"), http.StatusBadRequest)
+		return
+	}
+	schema := schemaFileName(sname)
+
+	// records are either provided via form or as request body
+	var data []byte
+	if records := r.FormValue("records"); records != "" {
+		data = []byte(records)
+	} else {
+		defer r.Body.Close()
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	records, perrs, err := parseBulkRecords(data)
+	if err != nil {
+		jsonResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	for idx, rec := range records {
+		if perrs[idx] == nil {
+			if _, ok := rec["User"]; !ok {
+				rec["User"] = user
+			}
+		}
+	}
+	if Config.Verbose > 0 {
+		log.Printf("BulkHandler schema=%s, file=%s, records=%d", sname, schema, len(records))
+	}
+	statuses, err := insertRecords(r.Context(), schema, records, perrs)
+	nfailed := 0
+	for _, s := range statuses {
+		if s.Status != "ok" {
+			nfailed++
+		}
+	}
+	status := errorStatus(err, http.StatusOK)
+	rec := Record{
+		"status":   status,
+		"inserted": len(statuses) - nfailed,
+		"failed":   nfailed,
+		"records":  statuses,
+	}
+	if err != nil {
+		rec["error"] = err.Error()
+	}
+	w.WriteHeader(status)
+	if body, err := json.Marshal(rec); err == nil {
+		w.Write(body)
+	}
+}
+
+// EventsHandler provides events which happened after given sequence number
+// for consumers which can't receive webhooks, e.g. /events?since=123&type=record.created
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if val := r.FormValue("since"); val != "" {
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			jsonResponse(w, fmt.Errorf("invalid since value '%s'", val), http.StatusBadRequest)
+			return
+		}
+		since = v
+	}
+	limit := EventLimit
+	if val := r.FormValue("limit"); val != "" {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			jsonResponse(w, fmt.Errorf("invalid limit value '%s'", val), http.StatusBadRequest)
+			return
+		}
+		limit = v
+	}
+	events, err := getEvents(r.Context(), since, r.Form["type"], limit)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	next := since
+	if len(events) > 0 {
+		next = events[len(events)-1].ID
+	}
+	data, err := json.Marshal(map[string]any{"events": events, "next": next})
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// DeliveriesHandler provides webhook delivery log of given event
+func DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := username(r)
+	if !isAdmin(user) {
+		jsonResponse(w, fmt.Errorf("user %s is not allowed to inspect deliveries", user), http.StatusForbidden)
+		return
+	}
+	eid, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		jsonResponse(w, fmt.Errorf("invalid event id '%s'", mux.Vars(r)["id"]), http.StatusBadRequest)
+		return
+	}
+	records, err := getDeliveries(r.Context(), eid)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// LineageHandler provides ancestry and descendants of a dataset,
+// e.g. /lineage?dataset=/2022-3/3A/123/sample
+func LineageHandler(w http.ResponseWriter, r *http.Request) {
+	dataset := r.FormValue("dataset")
+	if dataset == "" {
+		err := errors.New("no dataset found in HTTP request")
+		if jsonRequest(r) {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		handleError(w, r, "invalid lineage request", err)
+		return
+	}
+	lineage, err := getLineage(dataset)
+	if jsonRequest(r) {
+		if err != nil {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(lineage)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	if err != nil {
+		handleError(w, r, "unable to get dataset lineage", err)
+		return
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Dataset"] = lineage.Dataset
+	tmplData["Processing"] = lineage.Processing
+	tmplData["Ancestors"] = lineage.Ancestors
+	tmplData["Descendants"] = lineage.Descendants
+	page := templates.Tmpl(Config.Templates, "lineage.tmpl", tmplData)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(_top + page + _bottom))
+}
+
+// RescanHandler rescans directory of dataset with given did and provides
+// report of changed files, admins may rescan all datasets by omitting did
+func RescanHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := username(r)
+	if Config.TestMode && user == "" {
+		user = "test"
+	}
+	did := r.FormValue("did")
+	var result any
+	if did == "" {
+		if !isAdmin(user) {
+			jsonResponse(w, fmt.Errorf("user %s is not allowed to rescan all datasets", user), http.StatusForbidden)
+			return
+		}
+		reports, err := rescanRecords(r.Context())
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		result = reports
+	} else {
+		report, err := rescanDID(r.Context(), did, user)
+		if err != nil {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		result = report
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// ConsistencyHandler provides report of consistency check of MongoDB and
+// FilesDB to admins, POST request repairs found problems but only reports
+// repair actions unless dryrun=false parameter is provided
+func ConsistencyHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := username(r)
+	if !isAdmin(user) {
+		jsonResponse(w, fmt.Errorf("user %s is not allowed to check consistency", user), http.StatusForbidden)
+		return
+	}
+	var report ConsistencyReport
+	var err error
+	if r.Method == "POST" {
+		report, err = repairConsistency(r.Context(), r.FormValue("dryrun") != "false")
+	} else {
+		report, err = checkConsistency(r.Context())
+	}
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		jsonResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// helper function to write response of accepted record along with id of
+// the job which registers its files
+func jobResponse(w http.ResponseWriter, jid string) {
+	rec := Record{"status": http.StatusOK, "job": jid}
+	w.WriteHeader(http.StatusOK)
+	if body, err := json.Marshal(rec); err == nil {
+		w.Write(body)
+	}
+}
+
+// JobHandler provides status and progress of the job which registers files
+// of a dataset, e.g. /jobs/0b1c2d3e4f
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := getJob(r.Context(), mux.Vars(r)["id"])
+	if jsonRequest(r) {
+		if err != nil {
+			jsonResponse(w, err, http.StatusNotFound)
+			return
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	if err != nil {
+		handleError(w, r, "unable to get job", err)
+		return
+	}
+	var templates Templates
+	tmplData := makeTmplData()
+	tmplData["Job"] = job
+	tmplData["Completed"] = job.Completed()
+	page := templates.Tmpl(Config.Templates, "job.tmpl", tmplData)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(_top + page + _bottom))
+}
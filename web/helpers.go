@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -92,10 +93,33 @@ func handleError(w http.ResponseWriter, r *http.Request, msg string, err error)
 	tmplData["Message"] = strings.ToTitle(msg)
 	tmplData["Class"] = "alert is-error is-large is-text-center"
 	page := templates.Tmpl(Config.Templates, "confirm.tmpl", tmplData)
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(errorStatus(err, http.StatusOK))
 	w.Write([]byte(_top + page + _bottom))
 }
 
+// helper function to get HTTP status code for given error, the errors of
+// unavailable meta-data store are reported as 503 Service Unavailable and
+// updates based on stale revision as 409 Conflict
+func errorStatus(err error, status int) int {
+	if errors.Is(err, ErrStoreUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if _, ok := isConflict(err); ok {
+		return http.StatusConflict
+	}
+	return status
+}
+
+// helper function to check if client asks for JSON response
+func jsonRequest(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	content := r.Header.Get("Content-Type")
+	if strings.Contains(accept, "json") || strings.Contains(content, "json") {
+		return true
+	}
+	return r.URL.Query().Get("format") == "json"
+}
+
 // helper function to check user credentials for POST requests
 func getUserCredentials(r *http.Request) (*credentials.Credentials, error) {
 	var msg string
@@ -175,18 +199,20 @@ func preprocess(rec Record) Record {
 }
 */
 
-// helper function to insert data into backend DB
-func insertData(sname string, rec Record) error {
+// helper function to prepare record for insertion into backend DB, it
+// validates the record against its schema, assigns dataset name and did,
+// and returns path of data files associated with the record
+func prepareRecord(sname string, rec Record) (string, error) {
 	// load our schema
 	if _, err := _smgr.Load(sname); err != nil {
 		msg := fmt.Sprintf("unable to load %s error %v", sname, err)
 		log.Println("ERROR: ", msg)
-		return errors.New(msg)
+		return "", errors.New(msg)
 	}
 
 	// check if data satisfies to one of the schema
 	if err := validateData(sname, rec); err != nil {
-		return err
+		return "", err
 	}
 	if _, ok := rec["Date"]; !ok {
 		rec["Date"] = time.Now().Unix()
@@ -242,35 +268,63 @@ func insertData(sname string, rec Record) error {
 	rec["dataset"] = dataset
 	//     rec = preprocess(rec)
 	// check if given path exist on file system
-	_, err := os.Stat(path)
-	if err == nil {
-		log.Printf("input data, record %v, path %v\n", rec, path)
-		rec["path"] = path
-		// generate unique id
-		var did string
-		if v, ok := rec["did"]; !ok {
-			if uuid, err := uuid.NewRandom(); err == nil {
-				did = hex.EncodeToString(uuid[:])
-			} else {
-				did = fmt.Sprintf("%v", time.Now().UnixMilli())
-			}
-		} else {
-			did = v.(string)
-		}
-		err = InsertFiles(did, dataset, path)
-		if err != nil {
-			log.Printf("ERROR: unable to InsertFiles for did=%v dataset=%s path=%s, error=%v", did, dataset, path, err)
-			return err
+	if _, err := os.Stat(path); err != nil {
+		msg := fmt.Sprintf("No files found associated with DataLocationRaw=%s", path)
+		log.Printf("ERROR: %s", msg)
+		return "", errors.New(msg)
+	}
+	log.Printf("input data, record %v, path %v\n", rec, path)
+	rec["path"] = path
+	// generate unique id
+	if v, ok := rec["did"]; ok {
+		rec["did"] = fmt.Sprintf("%v", v)
+	} else if uuid, err := uuid.NewRandom(); err == nil {
+		rec["did"] = hex.EncodeToString(uuid[:])
+	} else {
+		rec["did"] = fmt.Sprintf("%v", time.Now().UnixMilli())
+	}
+	return path, nil
+}
+
+// helper function to insert data into backend DB, the meta-data record and
+// its dataset are stored immediately while dataset files are registered by
+// background job, it returns id of the job
+func insertData(ctx context.Context, sname string, rec Record) (string, error) {
+	path, err := prepareRecord(sname, rec)
+	if err != nil {
+		return "", err
+	}
+	did := rec["did"].(string)
+	dataset := rec["dataset"].(string)
+	parent, processing, err := lineageKeys(rec)
+	if err != nil {
+		return "", err
+	}
+	if parent != "" {
+		if err := checkParent(dataset, parent); err != nil {
+			return "", err
 		}
-		rec["did"] = did
-		records := []Record{rec}
-		err = MongoUpsert(Config.DBName, Config.DBColl, "dataset", records)
-		if err != nil {
-			log.Printf("ERROR: unable to MongoUpsert for did=%v dataset=%s path=%s, error=%v", did, dataset, path, err)
+	}
+	err = InsertDataset(did, dataset, discoveryRules(rec))
+	if err != nil {
+		log.Printf("ERROR: unable to InsertDataset for did=%v dataset=%s, error=%v", did, dataset, err)
+		return "", err
+	}
+	if parent != "" || processing != "" {
+		if err := setLineage(dataset, parent, processing); err != nil {
+			log.Printf("ERROR: unable to set lineage of dataset=%s parent=%s processing=%s, error=%v", dataset, parent, processing, err)
+			return "", err
 		}
-		return err
 	}
-	msg := fmt.Sprintf("No files found associated with DataLocationRaw=%s", path)
-	log.Printf("ERROR: %s", msg)
-	return errors.New(msg)
+	err = upsertRecord(ctx, rec)
+	if err != nil {
+		log.Printf("ERROR: unable to MongoUpsert for did=%v dataset=%s path=%s, error=%v", did, dataset, path, err)
+		return "", err
+	}
+	job, err := submitJob(rec)
+	if err != nil {
+		log.Printf("ERROR: unable to submit job for did=%v dataset=%s path=%s, error=%v", did, dataset, path, err)
+		return job.ID, err
+	}
+	return job.ID, nil
 }
@@ -0,0 +1,358 @@
+package main
+
+// record history module
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive" // for BSON ObjectID
+)
+
+// _serverKeys represents record keys which are assigned by the server
+// and therefore do not belong to any schema
+var _serverKeys = []string{"_id", "did", "dataset", "path", "revision", "owner"}
+
+// FieldDiff represents change of a single record field between two versions
+type FieldDiff struct {
+	Key    string `json:"key"`
+	Action string `json:"action"` // added, removed or changed
+	Old    any    `json:"old,omitempty"`
+	New    any    `json:"new,omitempty"`
+}
+
+// RecordVersion represents single version of meta-data record, the version
+// is the revision the record had at that time
+type RecordVersion struct {
+	Version     int         `json:"version"`
+	User        string      `json:"user"`
+	Timestamp   int64       `json:"timestamp"`
+	Description string      `json:"description"`
+	Current     bool        `json:"current"`
+	Record      Record      `json:"record"`
+	Diff        []FieldDiff `json:"diff"`
+}
+
+// helper function to compare two records and return field-level diff
+func recordDiff(oldRec, newRec Record) []FieldDiff {
+	var out []FieldDiff
+	for _, k := range MapKeys(oldRec) {
+		if k == "_id" {
+			continue
+		}
+		nv, ok := newRec[k]
+		if !ok {
+			out = append(out, FieldDiff{Key: k, Action: "removed", Old: oldRec[k]})
+		} else if !equalValues(oldRec[k], nv) && !reflect.DeepEqual(oldRec[k], nv) {
+			out = append(out, FieldDiff{Key: k, Action: "changed", Old: oldRec[k], New: nv})
+		}
+	}
+	for _, k := range MapKeys(newRec) {
+		if k == "_id" {
+			continue
+		}
+		if _, ok := oldRec[k]; !ok {
+			out = append(out, FieldDiff{Key: k, Action: "added", New: newRec[k]})
+		}
+	}
+	return out
+}
+
+// helper function to convert numeric value to int, MongoDB may return
+// integers as int32 or int64 depending on their size
+func intValue(val any) int {
+	switch v := val.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// helper function to get string representation of record id
+func recordID(rec Record) string {
+	switch v := rec["_id"].(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// helper function to create spec for given record id
+func recordSpec(rid string) bson.M {
+	if oid, err := primitive.ObjectIDFromHex(rid); err == nil {
+		return bson.M{"_id": oid}
+	}
+	return bson.M{"_id": rid}
+}
+
+// helper function to find meta-data record for given record id
+func findRecord(ctx context.Context, rid string) (Record, error) {
+	records, err := MongoGet(ctx, Config.DBName, Config.DBColl, recordSpec(rid), 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != 1 {
+		msg := fmt.Sprintf("unable to find record %s", rid)
+		return nil, errors.New(msg)
+	}
+	return records[0], nil
+}
+
+// upsertRecord upserts given record into meta-data store and keeps previous
+// version of the record in history collection
+func upsertRecord(ctx context.Context, rec Record) error {
+	return recordError(upsertRecords(ctx, []Record{rec}), 0)
+}
+
+// upsertRecords upserts given records into meta-data store and keeps previous
+// versions of the records in history collection, the records should have
+// distinct datasets and failures of individual records are reported via BulkError.
+// Every write increments revision of the record, existing records are updated
+// only if their revision is not changed concurrently and if record carries
+// revision key it should match revision of existing record. Successful writes
+// emit record.created or record.updated events
+func upsertRecords(ctx context.Context, records []Record) error {
+	return writeRecords(ctx, records, false)
+}
+
+// replaceRecord replaces existing record with given one, keys of existing
+// record which are missing in given record are removed by the same update
+func replaceRecord(ctx context.Context, rec Record) error {
+	return recordError(writeRecords(ctx, []Record{rec}, true), 0)
+}
+
+// helper function to write records into meta-data store, see upsertRecords,
+// existing records are either merged with or replaced by given records
+func writeRecords(ctx context.Context, records []Record, replace bool) error {
+	var datasets []string
+	for _, rec := range records {
+		datasets = append(datasets, fmt.Sprintf("%v", rec["dataset"]))
+	}
+	spec := bson.M{"dataset": bson.M{"$in": datasets}}
+	existing, err := MongoGet(ctx, Config.DBName, Config.DBColl, spec, 0, -1)
+	if err != nil {
+		return err
+	}
+	prevs := make(map[string]Record)
+	for _, prev := range existing {
+		prevs[fmt.Sprintf("%v", prev["dataset"])] = prev
+	}
+	berr := &BulkError{Errors: make(map[int]error)}
+	var newRecords []Record
+	var newIndexes []int
+	var events []Event
+	defer func() { emitEvents(ctx, events...) }()
+	for idx, rec := range records {
+		prev, ok := prevs[datasets[idx]]
+		if !ok {
+			rec["revision"] = 1
+			// user who creates the record owns it
+			if user, ok := rec["User"]; ok && user != nil {
+				rec["owner"] = user
+			}
+			newRecords = append(newRecords, rec)
+			newIndexes = append(newIndexes, idx)
+			continue
+		}
+		revision := recordRevision(prev)
+		if val, ok := rec["revision"]; ok && intValue(val) != revision {
+			berr.Errors[idx] = conflictError(ctx, prev, intValue(val))
+			continue
+		}
+		rec["revision"] = revision + 1
+		// owner of the record is never changed by updates
+		if owner := recordOwner(prev); owner != "" {
+			rec["owner"] = owner
+		} else {
+			delete(rec, "owner")
+		}
+		if err := updateRevision(ctx, prev, rec, revision, replace); err != nil {
+			if _, ok := isConflict(err); !ok {
+				return err
+			}
+			berr.Errors[idx] = err
+			continue
+		}
+		// previous record is stored in history under its revision, the
+		// revision is unique per record since the update above succeeded
+		// only for this revision
+		rid := recordID(prev)
+		version := revision
+		hrec := Record{
+			"record_id":   rid,
+			"dataset":     datasets[idx],
+			"version":     version,
+			"user":        rec["User"],
+			"timestamp":   time.Now().Unix(),
+			"description": rec["Description"],
+			"record":      prev,
+		}
+		if err := Insert(ctx, Config.DBName, Config.HistoryColl, []Record{hrec}); err != nil {
+			return err
+		}
+		if Config.Verbose > 0 {
+			log.Printf("record %s, dataset %s, stored version %d in history", rid, datasets[idx], version)
+		}
+		evt := recordEvent(EventRecordUpdated, prev)
+		if user, ok := rec["User"]; ok && user != nil {
+			evt.User = fmt.Sprintf("%v", user)
+		}
+		events = append(events, evt)
+	}
+	if len(newRecords) > 0 {
+		err := MongoUpsert(ctx, Config.DBName, Config.DBColl, "dataset", newRecords)
+		var uerr *BulkError
+		if err != nil && !errors.As(err, &uerr) {
+			return err
+		}
+		if uerr != nil {
+			for i, e := range uerr.Errors {
+				berr.Errors[newIndexes[i]] = e
+			}
+		}
+		for i, rec := range newRecords {
+			if uerr == nil || uerr.Errors[i] == nil {
+				events = append(events, recordEvent(EventRecordCreated, rec))
+			}
+		}
+	}
+	if len(berr.Errors) > 0 {
+		return berr
+	}
+	return nil
+}
+
+// helper function to convert history snapshot into a record
+func historySnapshot(hrec Record) Record {
+	rec := make(Record)
+	if r, ok := toMap(hrec["record"]); ok {
+		for k, v := range r {
+			rec[k] = v
+		}
+	}
+	return rec
+}
+
+// recordHistory returns all versions of given record, the last version
+// represents current record
+func recordHistory(ctx context.Context, rid string) ([]RecordVersion, error) {
+	var versions []RecordVersion
+	current, err := findRecord(ctx, rid)
+	if err != nil {
+		return versions, err
+	}
+	spec := bson.M{"record_id": rid}
+	hrecords, err := MongoGet(ctx, Config.DBName, Config.HistoryColl, spec, 0, -1)
+	if err != nil {
+		return versions, err
+	}
+	sort.SliceStable(hrecords, func(i, j int) bool {
+		return intValue(hrecords[i]["version"]) < intValue(hrecords[j]["version"])
+	})
+	for idx, hrec := range hrecords {
+		var next Record
+		if idx+1 < len(hrecords) {
+			next = historySnapshot(hrecords[idx+1])
+		} else {
+			next = current
+		}
+		snapshot := historySnapshot(hrec)
+		v := RecordVersion{
+			Version:     intValue(hrec["version"]),
+			User:        fmt.Sprintf("%v", hrec["user"]),
+			Timestamp:   int64(intValue(hrec["timestamp"])),
+			Description: fmt.Sprintf("%v", hrec["description"]),
+			Record:      snapshot,
+			Diff:        recordDiff(snapshot, next),
+		}
+		if _, ok := hrec["version"]; !ok {
+			v.Version = idx + 1
+		}
+		versions = append(versions, v)
+	}
+	v := RecordVersion{
+		Version:     recordRevision(current),
+		User:        fmt.Sprintf("%v", current["User"]),
+		Description: fmt.Sprintf("%v", current["Description"]),
+		Current:     true,
+		Record:      current,
+	}
+	v.Timestamp = int64(intValue(current["Date"]))
+	versions = append(versions, v)
+	return versions, nil
+}
+
+// helper function to validate record which is stored in meta-data store
+// against its schema, server assigned keys are not part of the schema
+func validateRecord(rec Record) error {
+	sname, ok := rec["SchemaFile"]
+	if !ok {
+		return errors.New("record does not contain schema file")
+	}
+	r := make(Record)
+	for k, v := range rec {
+		if InList(k, _serverKeys) {
+			continue
+		}
+		r[k] = v
+	}
+	if _, err := _smgr.Load(fmt.Sprintf("%v", sname)); err != nil {
+		return err
+	}
+	return validateData(fullPath(fmt.Sprintf("%v", sname)), r)
+}
+
+// revertRecord restores given version of the record, the current record is
+// kept in history as any other update
+func revertRecord(ctx context.Context, rid string, version, revision int, user string) (Record, error) {
+	versions, err := recordHistory(ctx, rid)
+	if err != nil {
+		return nil, err
+	}
+	var rec Record
+	for _, v := range versions {
+		if v.Version == version && !v.Current {
+			rec = v.Record
+		}
+	}
+	if rec == nil {
+		msg := fmt.Sprintf("unable to find version %d of record %s", version, rid)
+		return nil, errors.New(msg)
+	}
+	delete(rec, "_id")
+	if err := validateRecord(rec); err != nil {
+		return nil, err
+	}
+	// the record should keep its current dataset to be upserted in place
+	current := versions[len(versions)-1].Record
+	rec["dataset"] = current["dataset"]
+	// the revert is guarded by revision the client based its decision on,
+	// therefore stale revert is rejected with conflict
+	rec["revision"] = revision
+	rec["User"] = user
+	rec["Description"] = fmt.Sprintf("revert to version %d on %s", version, time.Now().String())
+	// keys which were added after reverted version are removed by the same
+	// update, therefore the record is never left half-reverted
+	if err := replaceRecord(ctx, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestRecordDiff
+func TestRecordDiff(t *testing.T) {
+	oldRec := Record{"a": 1, "b": "x", "c": []string{"1", "2"}}
+	newRec := Record{"a": 1.0, "b": "y", "d": true}
+	diffs := recordDiff(oldRec, newRec)
+	actions := make(map[string]string)
+	for _, d := range diffs {
+		actions[d.Key] = d.Action
+	}
+	if len(diffs) != 3 || actions["b"] != "changed" || actions["c"] != "removed" || actions["d"] != "added" {
+		t.Errorf("wrong record diff %+v", diffs)
+	}
+}
+
+// TestRecordHistory
+func TestRecordHistory(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+
+	schema := fullPath("schemas/test.json")
+	rec := Record{
+		"StringKey":            "test",
+		"StrKeyMultipleValues": "bla",
+		"ListKey":              []string{"3A"},
+		"FloatKey":             1.1,
+		"BoolKey":              true,
+		"SchemaFile":           schema,
+		"dataset":              "/a/b/c/d",
+		"User":                 "test",
+		"Description":          "first version",
+	}
+	if err := upsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	records, err := MongoGet(ctx, Config.DBName, Config.DBColl, bson.M{"dataset": "/a/b/c/d"}, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("unable to find inserted record")
+	}
+	rid := recordID(records[0])
+
+	// update the record and check its history
+	rec = Record{"dataset": "/a/b/c/d", "StringKey": "new", "Extra": "added", "User": "test", "Description": "second version"}
+	if err := upsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := recordHistory(ctx, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || !versions[1].Current {
+		t.Fatalf("wrong record versions %+v", versions)
+	}
+	// versions follow revisions of the record
+	if versions[0].Version != 1 || versions[1].Version != 2 {
+		t.Errorf("wrong version numbers %d, %d", versions[0].Version, versions[1].Version)
+	}
+	if len(versions[0].Diff) == 0 || versions[0].Diff[0].Key != "Description" {
+		t.Errorf("wrong record diff %+v", versions[0].Diff)
+	}
+
+	// revert record to its first version
+	revision := recordRevision(versions[1].Record)
+	if _, err := revertRecord(ctx, rid, 1, revision, "test"); err != nil {
+		t.Fatal(err)
+	}
+	current, err := findRecord(ctx, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current["Extra"]; current["StringKey"] != "test" || ok {
+		t.Errorf("record was not reverted %+v", current)
+	}
+	versions, _ = recordHistory(ctx, rid)
+	if len(versions) != 3 {
+		t.Errorf("wrong number of record versions %d", len(versions))
+	}
+
+	// revert based on stale revision is rejected with conflict
+	if _, err := revertRecord(ctx, rid, 1, revision, "test"); err == nil {
+		t.Error("stale revert is applied")
+	} else if _, ok := isConflict(err); !ok {
+		t.Errorf("wrong error of stale revert %v", err)
+	}
+	revert := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/record/"+rid+"/revert?"+form.Encode(), nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		Handlers().ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := revert(url.Values{"version": {"1"}}); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("wrong status %d of revert without revision", rr.Code)
+	}
+	stale := url.Values{"version": {"1"}, "revision": {strconv.Itoa(revision)}}
+	if rr := revert(stale); rr.Code != http.StatusConflict {
+		t.Errorf("wrong status %d of stale revert", rr.Code)
+	}
+	if current, _ := findRecord(ctx, rid); current["StringKey"] != "test" {
+		t.Errorf("record is changed by stale revert %+v", current)
+	}
+
+	// record edited by other user is still owned and reverted by its creator
+	rec = Record{"dataset": "/a/b/c/d", "StringKey": "other", "User": "other", "owner": "other", "Description": "fourth version"}
+	if err := upsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	current, _ = findRecord(ctx, rid)
+	if recordOwner(current) != "test" || canManage("other", current) {
+		t.Errorf("owner of the record is changed %+v", current)
+	}
+	form := url.Values{"version": {"1"}, "revision": {strconv.Itoa(recordRevision(current))}}
+	if rr := revert(form); rr.Code != http.StatusOK {
+		t.Errorf("wrong status %d of revert by owner", rr.Code)
+	}
+	current, _ = findRecord(ctx, rid)
+	if current["StringKey"] != "test" || recordOwner(current) != "test" {
+		t.Errorf("record is not reverted by owner %+v", current)
+	}
+
+	// only owner of the record can revert it
+	if _, err := Update(ctx, Config.DBName, Config.DBColl, bson.M{"dataset": "/a/b/c/d"}, bson.M{"$set": bson.M{"owner": "other"}}); err != nil {
+		t.Fatal(err)
+	}
+	form = url.Values{"version": {"1"}, "revision": {strconv.Itoa(recordRevision(current))}}
+	if rr := revert(form); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong status %d of revert by other user", rr.Code)
+	}
+	if after, _ := findRecord(ctx, rid); recordRevision(after) != recordRevision(current) {
+		t.Errorf("record of other user is reverted %+v", after)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -87,6 +88,8 @@ func TestHTTPPostGet(t *testing.T) {
 		fmt.Println("fail to process record", inputRecord, "for schema", schema)
 		t.Error(err)
 	}
+	// files of the record are registered by background job
+	waitJobs(t)
 
 	data := rr.Body.Bytes()
 	// unmarshal received records
@@ -103,6 +106,9 @@ func TestHTTPPostGet(t *testing.T) {
 	} else {
 		t.Error("no status code in record")
 	}
+	if record["job"] == nil {
+		t.Errorf("no job id in record %+v", record)
+	}
 
 	// HTTP GET request
 	query := "user:test"
@@ -178,3 +184,45 @@ func TestHTTPPostMissingKeys(t *testing.T) {
 		fmt.Printf("INFO: expected error %v", err)
 	}
 }
+
+// TestHTTPSearchPagination provides test of paginated and sorted search
+func TestHTTPSearchPagination(t *testing.T) {
+	initMetaDataService()
+	var records []Record
+	for _, key := range []string{"b", "c", "a"} {
+		rec := Record{"dataset": "/pagination/" + key, "StringKey": key, "User": "pager"}
+		records = append(records, rec)
+	}
+	if err := Insert(context.Background(), Config.DBName, Config.DBColl, records); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	form.Add("query", "User:pager")
+	form.Add("client", "cli")
+	form.Add("sort", "StringKey:desc")
+	form.Add("idx", "1")
+	form.Add("limit", "1")
+	reader := strings.NewReader(form.Encode())
+	rr, err := respRecorder("POST", "/search", reader, SearchHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := rr.Header().Get("X-Total-Count"); total != "3" {
+		t.Errorf("wrong total count %s", total)
+	}
+	var recs []Record
+	if err := json.Unmarshal(rr.Body.Bytes(), &recs); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0]["StringKey"] != "b" {
+		t.Errorf("wrong page of records %+v", recs)
+	}
+
+	// invalid sort key should be rejected
+	form.Set("sort", "UnknownKey:desc")
+	reader = strings.NewReader(form.Encode())
+	if _, err := respRecorder("POST", "/search", reader, SearchHandler); err == nil {
+		t.Error("no error for invalid sort key")
+	}
+}
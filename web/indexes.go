@@ -0,0 +1,234 @@
+package main
+
+// index manager module creates and reconciles meta-data store indexes
+// based on index attributes of our schemas
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+)
+
+// TextIndexName defines name of text index of meta-data collection,
+// MongoDB allows only one text index per collection
+const TextIndexName = "text_index"
+
+// IndexSpec represents index of meta-data store collection
+type IndexSpec struct {
+	Name       string         `json:"name"`
+	Collection string         `json:"collection"`
+	Keys       []string       `json:"keys"`
+	Unique     bool           `json:"unique"`
+	Text       bool           `json:"text"`
+	Weights    map[string]int `json:"weights,omitempty"`
+}
+
+// String provides string representation of index spec
+func (i IndexSpec) String() string {
+	if i.Text {
+		return fmt.Sprintf("<index %s.%s text %v>", i.Collection, i.Name, i.Weights)
+	}
+	return fmt.Sprintf("<index %s.%s keys %v unique %v>", i.Collection, i.Name, i.Keys, i.Unique)
+}
+
+// equal checks if two index specs define the same index
+func (i IndexSpec) equal(o IndexSpec) bool {
+	if i.Text || o.Text {
+		return i.Text == o.Text && reflect.DeepEqual(i.Weights, o.Weights)
+	}
+	return i.Unique == o.Unique && reflect.DeepEqual(i.Keys, o.Keys)
+}
+
+// IndexStore defines index operations of meta-data store
+type IndexStore interface {
+	Indexes(ctx context.Context, dbname, collname string) ([]IndexSpec, error)
+	CreateIndex(ctx context.Context, dbname string, spec IndexSpec) error
+	DropIndex(ctx context.Context, dbname, collname, name string) error
+}
+
+// IndexStatus represents status of single index
+type IndexStatus struct {
+	Index    IndexSpec  `json:"index"`
+	Existing *IndexSpec `json:"existing,omitempty"`
+	Status   string     `json:"status"` // ok, missing, drift, extra, created, updated or failed
+	Error    string     `json:"error,omitempty"`
+}
+
+// helper function to return list of indexes server relies on
+func serverIndexes() []IndexSpec {
+	return []IndexSpec{
+		{Name: "dataset", Collection: Config.DBColl, Keys: []string{"dataset"}, Unique: true},
+		{Name: "did", Collection: Config.DBColl, Keys: []string{"did"}},
+		{Name: "record_version", Collection: Config.HistoryColl, Keys: []string{"record_id", "version"}, Unique: true},
+		{Name: "event_id", Collection: Config.EventsColl, Keys: []string{"id"}, Unique: true},
+		{Name: "event_type", Collection: Config.EventsColl, Keys: []string{"type", "id"}},
+		{Name: "delivery_event", Collection: Config.DeliveriesColl, Keys: []string{"event_id"}},
+		{Name: "delivery_pending", Collection: Config.DeliveriesColl, Keys: []string{"delivery"}},
+		{Name: "job_id", Collection: Config.JobsColl, Keys: []string{"id"}, Unique: true},
+		{Name: "job_status", Collection: Config.JobsColl, Keys: []string{"status"}},
+	}
+}
+
+// schemaIndexes returns list of indexes defined by loaded schemas, keys
+// with the same index name form compound index ordered by their position
+// in schema file, and all keys with text index weight form single text index
+func schemaIndexes() []IndexSpec {
+	type indexKey struct {
+		Key      string
+		Position int
+	}
+	indexKeys := make(map[string][]indexKey)
+	unique := make(map[string]bool)
+	weights := make(map[string]int)
+	for _, fname := range SortedKeys(_smgr.Map) {
+		sobj := _smgr.Map[fname]
+		if sobj.Schema == nil {
+			continue
+		}
+		for _, rec := range sobj.Schema.Map {
+			if rec.Index != "" {
+				found := false
+				for _, k := range indexKeys[rec.Index] {
+					if k.Key == rec.Key {
+						found = true
+						break
+					}
+				}
+				if !found {
+					indexKeys[rec.Index] = append(indexKeys[rec.Index], indexKey{rec.Key, rec.Position})
+				}
+				if rec.Unique {
+					unique[rec.Index] = true
+				}
+			}
+			if rec.TextIndex > weights[rec.Key] {
+				weights[rec.Key] = rec.TextIndex
+			}
+		}
+	}
+	var out []IndexSpec
+	for _, name := range SortedKeys(indexKeys) {
+		keys := indexKeys[name]
+		sort.SliceStable(keys, func(i, j int) bool {
+			return keys[i].Position < keys[j].Position
+		})
+		spec := IndexSpec{Name: name, Collection: Config.DBColl, Unique: unique[name]}
+		for _, k := range keys {
+			spec.Keys = append(spec.Keys, k.Key)
+		}
+		out = append(out, spec)
+	}
+	if len(weights) > 0 {
+		spec := IndexSpec{Name: TextIndexName, Collection: Config.DBColl, Text: true, Weights: weights}
+		for _, k := range SortedKeys(weights) {
+			spec.Keys = append(spec.Keys, k)
+		}
+		out = append(out, spec)
+	}
+	return out
+}
+
+// helper function to return index store of our meta-data store
+func indexStore() (IndexStore, error) {
+	store, ok := MetaStore.(IndexStore)
+	if !ok {
+		return nil, errors.New("meta-data store does not support indexes")
+	}
+	return store, nil
+}
+
+// IndexReport compares indexes required by the server and schemas with
+// existing ones and returns their status
+func IndexReport(ctx context.Context) ([]IndexStatus, error) {
+	var out []IndexStatus
+	store, err := indexStore()
+	if err != nil {
+		return out, err
+	}
+	required := append(serverIndexes(), schemaIndexes()...)
+	var colls []string
+	for _, spec := range required {
+		if !InList(spec.Collection, colls) {
+			colls = append(colls, spec.Collection)
+		}
+	}
+	for _, coll := range colls {
+		existing, err := store.Indexes(ctx, Config.DBName, coll)
+		if err != nil {
+			return out, err
+		}
+		var names []string
+		for _, spec := range required {
+			if spec.Collection != coll {
+				continue
+			}
+			names = append(names, spec.Name)
+			status := IndexStatus{Index: spec, Status: "missing"}
+			for _, e := range existing {
+				e := e
+				if e.Name == spec.Name || (spec.Text && e.Text) {
+					status.Existing = &e
+					status.Status = "drift"
+					if spec.equal(e) {
+						status.Status = "ok"
+					}
+					break
+				}
+			}
+			out = append(out, status)
+		}
+		// report indexes which are not managed by the server
+		for _, e := range existing {
+			if e.Name == "_id_" || InList(e.Name, names) || e.Text {
+				continue
+			}
+			out = append(out, IndexStatus{Index: e, Status: "extra"})
+		}
+	}
+	return out, nil
+}
+
+// EnsureIndexes creates missing indexes and re-creates indexes which drift
+// from their definition, the indexes not managed by the server are left intact
+func EnsureIndexes(ctx context.Context) ([]IndexStatus, error) {
+	report, err := IndexReport(ctx)
+	if err != nil {
+		return report, err
+	}
+	store, err := indexStore()
+	if err != nil {
+		return report, err
+	}
+	for idx, status := range report {
+		if status.Status != "missing" && status.Status != "drift" {
+			continue
+		}
+		spec := status.Index
+		if status.Existing != nil {
+			err = store.DropIndex(ctx, Config.DBName, spec.Collection, status.Existing.Name)
+		}
+		if err == nil {
+			err = store.CreateIndex(ctx, Config.DBName, spec)
+		}
+		if err != nil {
+			log.Printf("ERROR: unable to create %s, error %v", spec, err)
+			report[idx].Status = "failed"
+			report[idx].Error = err.Error()
+			err = nil
+			continue
+		}
+		if status.Existing != nil {
+			report[idx].Status = "updated"
+		} else {
+			report[idx].Status = "created"
+		}
+		log.Printf("%s is %s", spec, report[idx].Status)
+	}
+	return report, nil
+}
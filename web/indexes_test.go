@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// helper function to get index status by its name
+func indexStatus(report []IndexStatus, name string) IndexStatus {
+	for _, s := range report {
+		if s.Index.Name == name {
+			return s
+		}
+	}
+	return IndexStatus{}
+}
+
+// TestIndexes
+func TestIndexes(t *testing.T) {
+	initMetaDataService()
+	ctx := context.Background()
+
+	// schema keys define compound and text indexes
+	var text IndexSpec
+	for _, spec := range schemaIndexes() {
+		if spec.Name == "cycle_beamline" && (len(spec.Keys) != 2 || spec.Keys[0] != "Cycle") {
+			t.Errorf("wrong compound index %s", spec)
+		}
+		if spec.Text {
+			text = spec
+		}
+	}
+	if text.Weights["SampleName"] != 10 || text.Weights["StringKey"] != 1 {
+		t.Errorf("wrong text index %s", text)
+	}
+
+	// fresh store has no indexes and they are created by EnsureIndexes
+	report, err := IndexReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := indexStatus(report, "dataset"); s.Status != "missing" {
+		t.Errorf("wrong status of dataset index %+v", s)
+	}
+	report, err = EnsureIndexes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range report {
+		if s.Status != "created" {
+			t.Errorf("index is not created %+v", s)
+		}
+	}
+
+	// change index definition and check that drift is detected and fixed
+	store, _ := indexStore()
+	if err := store.DropIndex(ctx, Config.DBName, Config.DBColl, "btr"); err != nil {
+		t.Fatal(err)
+	}
+	spec := IndexSpec{Name: "btr", Collection: Config.DBColl, Keys: []string{"BTR"}, Unique: true}
+	if err := store.CreateIndex(ctx, Config.DBName, spec); err != nil {
+		t.Fatal(err)
+	}
+	extra := IndexSpec{Name: "extra", Collection: Config.DBColl, Keys: []string{"Facility"}}
+	if err := store.CreateIndex(ctx, Config.DBName, extra); err != nil {
+		t.Fatal(err)
+	}
+	report, _ = IndexReport(ctx)
+	if s := indexStatus(report, "btr"); s.Status != "drift" {
+		t.Errorf("index drift is not detected %+v", s)
+	}
+	if s := indexStatus(report, "extra"); s.Status != "extra" {
+		t.Errorf("extra index is not reported %+v", s)
+	}
+	report, _ = EnsureIndexes(ctx)
+	if s := indexStatus(report, "btr"); s.Status != "updated" {
+		t.Errorf("index drift is not fixed %+v", s)
+	}
+	report, _ = IndexReport(ctx)
+	for _, s := range report {
+		if s.Status != "ok" && s.Index.Name != "extra" {
+			t.Errorf("wrong index status %+v", s)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+// jobs module provides asynchronous registration of dataset files, the
+// meta-data record is stored immediately while files are registered in
+// FilesDB by pool of background workers
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// job states
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// JobBatchSize defines number of files registered within single transaction
+var JobBatchSize = 1000
+
+// JobQueueSize defines number of jobs which can wait for the workers
+var JobQueueSize = 1000
+
+// JobMaxErrors defines maximum number of errors kept in the job
+var JobMaxErrors = 100
+
+// Job represents registration of files of a dataset
+type Job struct {
+	ID                string         `json:"id"`
+	Did               string         `json:"did"`
+	Dataset           string         `json:"dataset"`
+	Path              string         `json:"path"`
+	Locations         []DataLocation `json:"locations,omitempty"` // data locations of all tiers
+	Schema            string         `json:"schema"`
+	User              string         `json:"user"`
+	Status            string         `json:"status"`
+	RegistrationStats                // number of found, registered and skipped files along with throughput metrics
+	Errors            []string       `json:"errors,omitempty"`
+	Created           int64          `json:"created"`
+	Started           int64          `json:"started,omitempty"`
+	Finished          int64          `json:"finished,omitempty"`
+}
+
+// Completed checks if job is completed
+func (j Job) Completed() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
+
+// String returns summary of the job
+func (j Job) String() string {
+	return fmt.Sprintf("job=%s status=%s did=%s dataset=%s %s errors=%d",
+		j.ID, j.Status, j.Did, j.Dataset, j.RegistrationStats, len(j.Errors))
+}
+
+// _jobs keeps jobs which are processed by this server
+var _jobs = struct {
+	sync.RWMutex
+	Map   map[string]*Job
+	Queue chan *Job
+	once  sync.Once
+}{Map: make(map[string]*Job)}
+
+// startJobWorkers starts pool of job workers, it can be called many times
+// but workers are started only once
+func startJobWorkers() {
+	_jobs.once.Do(func() {
+		_jobs.Queue = make(chan *Job, JobQueueSize)
+		workers := Config.JobWorkers
+		if workers <= 0 {
+			workers = 4
+		}
+		for i := 0; i < workers; i++ {
+			go jobWorker()
+		}
+	})
+}
+
+// helper function to process jobs from the queue
+func jobWorker() {
+	for job := range _jobs.Queue {
+		runJob(job)
+	}
+}
+
+// helper function to update job under lock
+func updateJob(job *Job, update func(j *Job)) {
+	_jobs.Lock()
+	update(job)
+	_jobs.Unlock()
+}
+
+// helper function to add error to the job
+func (j *Job) addError(err error) {
+	if len(j.Errors) < JobMaxErrors {
+		j.Errors = append(j.Errors, err.Error())
+	}
+}
+
+// helper function to store job in meta-data store
+func storeJob(job *Job) {
+	_jobs.RLock()
+	data, err := json.Marshal(job)
+	_jobs.RUnlock()
+	if err != nil {
+		log.Printf("ERROR: unable to marshal job %s, error %v", job.ID, err)
+		return
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		log.Printf("ERROR: unable to unmarshal job %s, error %v", job.ID, err)
+		return
+	}
+	err = MongoUpsert(context.Background(), Config.DBName, Config.JobsColl, "id", []Record{rec})
+	if err != nil {
+		log.Printf("ERROR: unable to store job %s, error %v", job.ID, err)
+	}
+}
+
+// submitJob creates job which registers files of the record and puts it
+// into the queue of job workers
+func submitJob(rec Record) (*Job, error) {
+	startJobWorkers()
+	job := &Job{
+		Did:     fmt.Sprintf("%v", rec["did"]),
+		Dataset: fmt.Sprintf("%v", rec["dataset"]),
+		Status:  JobPending,
+		Created: time.Now().Unix(),
+	}
+	job.Path, _ = rec["path"].(string)
+	job.Locations = recordLocations(rec)
+	job.Schema, _ = rec["Schema"].(string)
+	job.User, _ = rec["User"].(string)
+	if uid, err := uuid.NewRandom(); err == nil {
+		job.ID = hex.EncodeToString(uid[:])
+	} else {
+		job.ID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	_jobs.Lock()
+	_jobs.Map[job.ID] = job
+	_jobs.Unlock()
+	storeJob(job)
+	return job, queueJob(job)
+}
+
+// helper function to put job into the queue
+func queueJob(job *Job) error {
+	select {
+	case _jobs.Queue <- job:
+		return nil
+	default:
+		err := errors.New("job queue is full, please rescan the dataset later")
+		updateJob(job, func(j *Job) {
+			j.Status = JobFailed
+			j.Finished = time.Now().Unix()
+			j.addError(err)
+		})
+		storeJob(job)
+		// rejected jobs are provided by meta-data store
+		_jobs.Lock()
+		delete(_jobs.Map, job.ID)
+		_jobs.Unlock()
+		return err
+	}
+}
+
+// getJob returns job with given id, jobs of this server are reported with
+// their current progress, other jobs are read from meta-data store
+func getJob(ctx context.Context, id string) (Job, error) {
+	_jobs.RLock()
+	job, ok := _jobs.Map[id]
+	if ok {
+		out := *job
+		out.Errors = append([]string{}, job.Errors...)
+		_jobs.RUnlock()
+		return out, nil
+	}
+	_jobs.RUnlock()
+	records, err := MongoGet(ctx, Config.DBName, Config.JobsColl, bson.M{"id": id}, 0, 1)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(records) == 0 {
+		return Job{}, fmt.Errorf("job %s is not found", id)
+	}
+	var out Job
+	data, err := json.Marshal(records[0])
+	if err == nil {
+		err = json.Unmarshal(data, &out)
+	}
+	return out, err
+}
+
+// runJob registers files of the job dataset in batches of transactions,
+// the files which are already registered are skipped and therefore
+// interrupted jobs can be run again
+func runJob(job *Job) {
+	updateJob(job, func(j *Job) {
+		j.Status = JobRunning
+		j.Started = time.Now().Unix()
+	})
+	storeJob(job)
+	err := registerJobFiles(job)
+	var registered int
+	var summary string
+	updateJob(job, func(j *Job) {
+		j.Status = JobDone
+		if err != nil {
+			j.Status = JobFailed
+			j.addError(err)
+		}
+		j.Finished = time.Now().Unix()
+		registered = j.Registered
+		summary = j.String()
+	})
+	storeJob(job)
+	// completed jobs are provided by meta-data store
+	_jobs.Lock()
+	delete(_jobs.Map, job.ID)
+	_jobs.Unlock()
+	log.Println(summary)
+	if registered > 0 {
+		evt := Event{
+			Type:    EventFilesRegistered,
+			Did:     job.Did,
+			Dataset: job.Dataset,
+			Schema:  job.Schema,
+			User:    job.User,
+			Path:    job.Path,
+		}
+		emitEvents(context.Background(), evt)
+	}
+}
+
+// helper function to register files of all data locations of the job
+// using discovery rules stored with its dataset
+func registerJobFiles(job *Job) error {
+	rules, _, err := didDiscoveryRules(job.Did)
+	if err != nil {
+		return err
+	}
+	locations := job.Locations
+	if len(locations) == 0 {
+		locations = []DataLocation{{Tier: TierRaw, Path: job.Path}}
+	}
+	start := time.Now()
+	files, _, err := discoverLocations(locations, rules, false)
+	if err != nil {
+		return err
+	}
+	updateJob(job, func(j *Job) {
+		j.Files = len(files)
+		j.measure(&j.WalkTime, start)
+	})
+	seqs, files := splitSequences(files, sequenceMinFiles())
+	if len(seqs) > 0 {
+		start := time.Now()
+		registered, skipped, err := registerSequences(job.Did, seqs)
+		if err != nil {
+			return err
+		}
+		updateJob(job, func(j *Job) {
+			j.Registered += registered
+			j.Skipped += skipped
+			j.measure(&j.InsertTime, start)
+		})
+	}
+	// multi-row insert statement is prepared once and reused by all batches
+	full, err := prepareFilesBulk()
+	if err != nil {
+		return err
+	}
+	defer full.Close()
+	for idx := 0; idx < len(files); idx += JobBatchSize {
+		end := idx + JobBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		stats, err := registerBatch(job.Did, full, files[idx:end])
+		if err != nil {
+			return err
+		}
+		updateJob(job, func(j *Job) { j.add(stats) })
+	}
+	return nil
+}
+
+// helper function to register batch of files within single transaction
+// using multi-row insert statement prepared by prepareFilesBulk, it returns
+// metrics of the batch
+func registerBatch(did string, full *sql.Stmt, files []FileInfo) (RegistrationStats, error) {
+	var stats RegistrationStats
+	// compute checksums before transaction to not lock FilesDB for long time
+	start := time.Now()
+	setChecksums(files)
+	stats.measure(&stats.ChecksumTime, start)
+	start = time.Now()
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return stats, err
+	}
+	defer tx.Rollback()
+	metaId, datasetId, err := datasetIDs(tx, did)
+	if err != nil {
+		return stats, err
+	}
+	registered, err := insertFilesBulk(tx, full, files, metaId, datasetId)
+	if err != nil {
+		return stats, err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return stats, err
+	}
+	stats.Registered = registered
+	stats.Skipped = len(files) - registered
+	stats.measure(&stats.InsertTime, start)
+	return stats, nil
+}
+
+// helper function to register sequences within single transaction, the
+// frames of sequences are counted as registered or skipped files
+func registerSequences(did string, seqs []FileSequence) (int, int, error) {
+	var registered, skipped int
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	metaId, datasetId, err := datasetIDs(tx, did)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, s := range seqs {
+		added, err := insertSequence(tx, s, metaId, datasetId)
+		if err != nil {
+			return 0, 0, err
+		}
+		if added {
+			registered += int(s.Frames)
+		} else {
+			skipped += int(s.Frames)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return 0, 0, err
+	}
+	return registered, skipped, nil
+}
+
+// resumeJobs puts jobs which were not completed before server restart back
+// into the queue
+func resumeJobs(ctx context.Context) error {
+	spec := bson.M{"status": bson.M{"$in": []string{JobPending, JobRunning}}}
+	records, err := MongoGet(ctx, Config.DBName, Config.JobsColl, spec, 0, -1)
+	if err != nil {
+		return err
+	}
+	startJobWorkers()
+	for _, rec := range records {
+		var job Job
+		data, err := json.Marshal(rec)
+		if err == nil {
+			err = json.Unmarshal(data, &job)
+		}
+		if err != nil {
+			log.Printf("ERROR: unable to read job %v, error %v", rec["id"], err)
+			continue
+		}
+		// the job is run from scratch, therefore metrics and errors of
+		// interrupted run are reset to not be counted twice
+		job.Status = JobPending
+		job.RegistrationStats = RegistrationStats{}
+		job.Errors = nil
+		job.Started = 0
+		job.Finished = 0
+		// stored job is upserted without empty fields, therefore we remove
+		// them explicitly
+		reset := bson.M{"$unset": bson.M{"errors": "", "started": "", "finished": ""}}
+		if _, err := Update(ctx, Config.DBName, Config.JobsColl, bson.M{"id": job.ID}, reset); err != nil {
+			log.Printf("ERROR: unable to reset job %s, error %v", job.ID, err)
+		}
+		_jobs.Lock()
+		_jobs.Map[job.ID] = &job
+		_jobs.Unlock()
+		log.Printf("resume %s", job.String())
+		if err := queueJob(&job); err != nil {
+			log.Printf("ERROR: unable to resume job %s, error %v", job.ID, err)
+		}
+	}
+	return nil
+}
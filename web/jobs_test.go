@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// helper function to wait for job completion
+func waitJob(t *testing.T, id string) Job {
+	for i := 0; i < 100; i++ {
+		job, err := getJob(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Completed() {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("job %s is not completed", id)
+	return Job{}
+}
+
+// helper function to wait for completion of all jobs of the server
+func waitJobs(t *testing.T) {
+	for i := 0; i < 600; i++ {
+		_jobs.RLock()
+		njobs := len(_jobs.Map)
+		_jobs.RUnlock()
+		if njobs == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("jobs are not completed")
+}
+
+// TestJobs
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"f1", "f2", "f3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	did := "job-did"
+	dataset := "/job/3A/btr/sample"
+	if err := InsertDataset(did, dataset, DiscoveryRules{}); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+
+	// files are registered in batches by background job
+	batchSize := JobBatchSize
+	JobBatchSize = 2
+	defer func() { JobBatchSize = batchSize }()
+	rec := Record{"did": did, "dataset": dataset, "path": dir, "User": "test"}
+	job, err := submitJob(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitJob(t, job.ID)
+	if done.Status != JobDone || done.Files != 4 || done.Registered != 4 || done.Skipped != 0 {
+		t.Errorf("wrong job %+v, errors %v", done, done.Errors)
+	}
+	files, err := getFileInfos(did, "", false)
+	if err != nil || len(files) != 4 {
+		t.Errorf("wrong registered files %+v, error %v", files, err)
+	}
+	for _, f := range files {
+		if f.Type == EntryFile && f.Checksum == "" {
+			t.Errorf("no checksum of registered file %+v", f)
+		}
+	}
+
+	// job status via HTTP
+	req := httptest.NewRequest("GET", "/jobs/"+job.ID, nil)
+	req.Header.Add("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	Handlers().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong response %d", rr.Code)
+	}
+	var status Job
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil || status.ID != job.ID || status.Registered != 4 {
+		t.Errorf("wrong job status %+v, error %v", status, err)
+	}
+
+	// interrupted jobs are resumed and skip already registered files
+	stored := Job{ID: "interrupted-job", Did: did, Dataset: dataset, Path: dir, Status: JobRunning, Errors: []string{"interrupted"}, Started: 1}
+	stored.Registered = 2
+	stored.Skipped = 2
+	storeJob(&stored)
+	if err := resumeJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	done = waitJob(t, stored.ID)
+	if done.Status != JobDone || done.Registered != 0 || done.Skipped != 4 || len(done.Errors) != 0 || done.Started <= 1 {
+		t.Errorf("wrong resumed job %+v", done)
+	}
+
+	// job of unknown dataset fails
+	job, err = submitJob(Record{"did": "unknown-did", "dataset": "/job/3A/btr/unknown", "path": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done = waitJob(t, job.ID); done.Status != JobFailed || len(done.Errors) == 0 {
+		t.Errorf("wrong job of unknown dataset %+v", done)
+	}
+	if _, err := getJob(ctx, "none"); err == nil {
+		t.Error("no error for unknown job")
+	}
+
+	// job rejected by full queue fails and is not kept by the server
+	queue := _jobs.Queue
+	_jobs.Queue = make(chan *Job)
+	job, err = submitJob(Record{"did": did, "dataset": dataset, "path": dir})
+	_jobs.Queue = queue
+	if err == nil {
+		t.Error("no error for full job queue")
+	}
+	_jobs.RLock()
+	_, ok := _jobs.Map[job.ID]
+	_jobs.RUnlock()
+	if ok {
+		t.Errorf("rejected job %s is kept by the server", job.ID)
+	}
+	if done, err = getJob(ctx, job.ID); err != nil || done.Status != JobFailed {
+		t.Errorf("wrong rejected job %+v, error %v", done, err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+// lineage module provides relationships between datasets, e.g. reduced
+// dataset is linked to raw dataset it is derived from along with name of
+// the processing which produced it. The lineage is kept in FilesDB parents
+// and processing tables.
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+)
+
+// LineageDepth defines maximum depth of lineage we walk through
+var LineageDepth = 100
+
+// LineageNode represents dataset within lineage of another dataset
+type LineageNode struct {
+	Dataset    string `json:"dataset"`
+	Parent     string `json:"parent,omitempty"`
+	Processing string `json:"processing,omitempty"`
+	Depth      int    `json:"depth"`
+}
+
+// Lineage represents ancestry and descendants of a dataset, ancestors are
+// ordered from the closest one and descendants are ordered by their depth
+type Lineage struct {
+	Dataset     string        `json:"dataset"`
+	Parent      string        `json:"parent,omitempty"`
+	Processing  string        `json:"processing,omitempty"`
+	Ancestors   []LineageNode `json:"ancestors"`
+	Descendants []LineageNode `json:"descendants"`
+}
+
+// helper function to extract lineage attributes from the record
+func lineageKeys(rec Record) (string, string, error) {
+	var out []string
+	for _, key := range []string{"Parent", "Processing"} {
+		val, ok := rec[key]
+		if !ok || val == nil {
+			out = append(out, "")
+			continue
+		}
+		v, ok := val.(string)
+		if !ok {
+			return "", "", fmt.Errorf("invalid data type for key=%s, value=%v, type=%T, expect=string", key, val, val)
+		}
+		out = append(out, v)
+	}
+	return out[0], out[1], nil
+}
+
+// helper function to check that given dataset can be derived from parent
+// dataset, i.e. parent dataset exists and it is not derived from dataset
+func checkParent(dataset, parent string) error {
+	if parent == dataset {
+		return fmt.Errorf("dataset %s can't be parent of itself", dataset)
+	}
+	exists, err := datasetExists(parent)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("parent dataset %s is not found", parent)
+	}
+	ancestors, err := datasetAncestors(parent)
+	if err != nil {
+		return err
+	}
+	for _, a := range ancestors {
+		if a.Dataset == dataset {
+			return fmt.Errorf("dataset %s is an ancestor of %s", dataset, parent)
+		}
+	}
+	return nil
+}
+
+// datasetAncestors returns chain of datasets given dataset is derived from
+func datasetAncestors(dataset string) ([]LineageNode, error) {
+	var out []LineageNode
+	visited := map[string]bool{dataset: true}
+	for depth := 1; depth <= LineageDepth; depth++ {
+		parent, _, err := datasetParent(dataset)
+		if err != nil {
+			return out, err
+		}
+		if parent == "" || visited[parent] {
+			break
+		}
+		// processing of ancestor is the one which produced it
+		grandParent, processing, err := datasetParent(parent)
+		if err != nil {
+			// parent dataset may be removed from FilesDB
+			out = append(out, LineageNode{Dataset: parent, Depth: depth})
+			break
+		}
+		out = append(out, LineageNode{Dataset: parent, Parent: grandParent, Processing: processing, Depth: depth})
+		visited[parent] = true
+		dataset = parent
+	}
+	return out, nil
+}
+
+// datasetDescendants returns all datasets derived from given dataset
+func datasetDescendants(dataset string) ([]LineageNode, error) {
+	var out []LineageNode
+	visited := map[string]bool{dataset: true}
+	level := []string{dataset}
+	for depth := 1; depth <= LineageDepth && len(level) > 0; depth++ {
+		var next []string
+		for _, parent := range level {
+			children, err := datasetChildren(parent)
+			if err != nil {
+				return out, err
+			}
+			for _, child := range children {
+				if visited[child.Dataset] {
+					continue
+				}
+				visited[child.Dataset] = true
+				child.Parent = parent
+				child.Depth = depth
+				out = append(out, child)
+				next = append(next, child.Dataset)
+			}
+		}
+		level = next
+	}
+	return out, nil
+}
+
+// getLineage returns lineage of given dataset
+func getLineage(dataset string) (Lineage, error) {
+	lineage := Lineage{Dataset: dataset, Ancestors: []LineageNode{}, Descendants: []LineageNode{}}
+	parent, processing, err := datasetParent(dataset)
+	if err != nil {
+		return lineage, err
+	}
+	lineage.Parent = parent
+	lineage.Processing = processing
+	if ancestors, err := datasetAncestors(dataset); err != nil {
+		return lineage, err
+	} else if len(ancestors) > 0 {
+		lineage.Ancestors = ancestors
+	}
+	if descendants, err := datasetDescendants(dataset); err != nil {
+		return lineage, err
+	} else if len(descendants) > 0 {
+		lineage.Descendants = descendants
+	}
+	return lineage, nil
+}
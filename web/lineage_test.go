@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"testing"
+)
+
+// TestLineage
+func TestLineage(t *testing.T) {
+	initMetaDataService()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+
+	// raw dataset with reduced dataset and its further analysis
+	datasets := []string{"/lineage/raw", "/lineage/reduced", "/lineage/analysis", "/lineage/other"}
+	for _, dataset := range datasets {
+		did := "/beamline=lineage/dataset=" + dataset
+		if err := insertStatsFiles(did, dataset, []string{dataset + "/f1"}); err != nil {
+			t.Fatal(err)
+		}
+		defer deleteDID(did)
+	}
+	if err := setLineage("/lineage/reduced", "/lineage/raw", "reduction"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLineage("/lineage/analysis", "/lineage/reduced", "analysis"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLineage("/lineage/other", "/lineage/raw", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	lineage, err := getLineage("/lineage/analysis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lineage.Parent != "/lineage/reduced" || lineage.Processing != "analysis" || len(lineage.Descendants) != 0 {
+		t.Errorf("wrong lineage %+v", lineage)
+	}
+	if a := lineage.Ancestors; len(a) != 2 || a[0].Dataset != "/lineage/reduced" || a[0].Processing != "reduction" || a[1].Dataset != "/lineage/raw" || a[1].Depth != 2 {
+		t.Errorf("wrong ancestors %+v", a)
+	}
+
+	lineage, err = getLineage("/lineage/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := lineage.Descendants; len(d) != 3 || d[2].Dataset != "/lineage/analysis" || d[2].Parent != "/lineage/reduced" || d[2].Depth != 2 {
+		t.Errorf("wrong descendants %+v", d)
+	}
+
+	// dataset can't be derived from its descendant or unknown dataset
+	for _, parent := range []string{"/lineage/raw", "/lineage/analysis", "/lineage/unknown"} {
+		if err := checkParent("/lineage/raw", parent); err == nil {
+			t.Errorf("no error for parent %s", parent)
+		}
+	}
+	if err := checkParent("/lineage/other", "/lineage/reduced"); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := lineageKeys(Record{"Parent": 1}); err == nil {
+		t.Error("no error for invalid parent type")
+	}
+
+	// lineage via HTTP
+	rr, err := respRecorder("GET", "/lineage?"+url.Values{"dataset": {"/lineage/reduced"}}.Encode(), nil, LineageHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp Lineage
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Ancestors) != 1 || len(resp.Descendants) != 1 || resp.Descendants[0].Processing != "analysis" {
+		t.Errorf("wrong lineage response %s", rr.Body.String())
+	}
+}
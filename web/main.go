@@ -26,10 +26,26 @@ func main() {
 	flag.BoolVar(&version, "version", false, "Show version")
 	var config string
 	flag.StringVar(&config, "config", "server.json", "server config JSON file")
+	var migrate bool
+	flag.BoolVar(&migrate, "migrate", false, "apply pending FilesDB migrations and exit")
+	var consistency bool
+	flag.BoolVar(&consistency, "consistency", false, "check consistency of MongoDB and FilesDB and exit")
+	var repair bool
+	flag.BoolVar(&repair, "repair", false, "repair problems found by consistency check")
+	var dryRun bool
+	flag.BoolVar(&dryRun, "dryrun", true, "only report repair actions, use -dryrun=false to apply them")
 	flag.Parse()
 	if version {
 		fmt.Println("server version:", info())
 		return
 	}
+	if migrate {
+		Migrate(config)
+		return
+	}
+	if consistency || repair {
+		Consistency(config, repair, dryRun)
+		return
+	}
 	Server(config)
 }
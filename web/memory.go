@@ -0,0 +1,676 @@
+package main
+
+// in-memory metadata store module
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive" // for BSON ObjectID
+)
+
+// MemoryStore provides in-memory implementation of MetadataStore, it is
+// used for local development and tests which should not depend on MongoDB
+type MemoryStore struct {
+	mutex       sync.RWMutex
+	Collections map[string][]Record
+	Indices     map[string][]IndexSpec
+}
+
+// NewMemoryStore creates new instance of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		Collections: make(map[string][]Record),
+		Indices:     make(map[string][]IndexSpec),
+	}
+}
+
+// helper function to build collection name
+func collectionName(dbname, collname string) string {
+	return fmt.Sprintf("%s.%s", dbname, collname)
+}
+
+// helper function to make a shallow copy of given record
+func copyRecord(rec Record) Record {
+	out := make(Record, len(rec))
+	for k, v := range rec {
+		out[k] = v
+	}
+	return out
+}
+
+// Insert records into memory store
+func (m *MemoryStore) Insert(ctx context.Context, dbname, collname string, records []Record) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cname := collectionName(dbname, collname)
+	for _, rec := range records {
+		r := copyRecord(rec)
+		if _, ok := r["_id"]; !ok {
+			r["_id"] = primitive.NewObjectID()
+		}
+		m.Collections[cname] = append(m.Collections[cname], r)
+	}
+	return nil
+}
+
+// Upsert records into memory store
+func (m *MemoryStore) Upsert(ctx context.Context, dbname, collname, attr string, records []Record) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cname := collectionName(dbname, collname)
+	for _, rec := range records {
+		value, _ := rec[attr].(string)
+		if value == "" {
+			continue
+		}
+		found := false
+		for _, r := range m.Collections[cname] {
+			if r[attr] == value {
+				for k, v := range rec {
+					r[k] = v
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			r := copyRecord(rec)
+			if _, ok := r["_id"]; !ok {
+				r["_id"] = primitive.NewObjectID()
+			}
+			m.Collections[cname] = append(m.Collections[cname], r)
+		}
+	}
+	return nil
+}
+
+// helper function to find records matching given spec
+func (m *MemoryStore) find(dbname, collname string, spec bson.M) ([]Record, error) {
+	out := []Record{}
+	for _, rec := range m.Collections[collectionName(dbname, collname)] {
+		matched, err := matchRecord(rec, spec)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			out = append(out, copyRecord(rec))
+		}
+	}
+	return out, nil
+}
+
+// Get records from memory store
+func (m *MemoryStore) Get(ctx context.Context, dbname, collname string, spec bson.M, idx, limit int) ([]Record, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	records, err := m.find(dbname, collname, spec)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(records, idx, limit), nil
+}
+
+// GetSorted records from memory store sorted by given keys
+func (m *MemoryStore) GetSorted(ctx context.Context, dbname, collname string, spec bson.M, skeys []string, idx, limit int) ([]Record, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	records, err := m.find(dbname, collname, spec)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, k := range skeys {
+			order := 1
+			if strings.HasPrefix(k, "-") {
+				k = k[1:]
+				order = -1
+			}
+			if c := compareValues(records[i][k], records[j][k]); c != 0 {
+				return c*order < 0
+			}
+		}
+		return false
+	})
+	return paginate(records, idx, limit), nil
+}
+
+// helper function to return slice of records for given index and limit
+func paginate(records []Record, idx, limit int) []Record {
+	if idx >= len(records) {
+		return []Record{}
+	}
+	records = records[idx:]
+	if limit > 0 && limit < len(records) {
+		records = records[:limit]
+	}
+	return records
+}
+
+// helper function to compare two values, numbers are compared by their
+// values and everything else by string representation
+func compareValues(a, b any) int {
+	sa := fmt.Sprintf("%v", a)
+	sb := fmt.Sprintf("%v", b)
+	fa, ea := strconv.ParseFloat(sa, 64)
+	fb, eb := strconv.ParseFloat(sb, 64)
+	if ea == nil && eb == nil {
+		if fa < fb {
+			return -1
+		} else if fa > fb {
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(sa, sb)
+}
+
+// Update inplace for given spec, we support $set and $unset operators
+func (m *MemoryStore) Update(ctx context.Context, dbname, collname string, spec, newdata bson.M) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, rec := range m.Collections[collectionName(dbname, collname)] {
+		matched, err := matchRecord(rec, spec)
+		if err != nil {
+			return 0, err
+		}
+		if !matched {
+			continue
+		}
+		for op, val := range newdata {
+			fields, ok := toMap(val)
+			if !ok {
+				log.Printf("Unable to update record, spec %v, data %v, unsupported value for %s", spec, newdata, op)
+				continue
+			}
+			for k, v := range fields {
+				if op == "$set" {
+					rec[k] = v
+				} else if op == "$unset" {
+					delete(rec, k)
+				} else {
+					log.Printf("Unable to update record, spec %v, unsupported operator %s", spec, op)
+				}
+			}
+		}
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Count gets number records from memory store
+func (m *MemoryStore) Count(ctx context.Context, dbname, collname string, spec bson.M) (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	records, err := m.find(dbname, collname, spec)
+	return len(records), err
+}
+
+// Remove records from memory store
+func (m *MemoryStore) Remove(ctx context.Context, dbname, collname string, spec bson.M) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cname := collectionName(dbname, collname)
+	var records []Record
+	for _, rec := range m.Collections[cname] {
+		matched, err := matchRecord(rec, spec)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			records = append(records, rec)
+		}
+	}
+	m.Collections[cname] = records
+	return nil
+}
+
+// Aggregate runs aggregation pipeline on memory store collection, we support
+// $match, $unwind, $group and $facet stages, the $group stage supports $sum,
+// $push and $addToSet accumulators
+func (m *MemoryStore) Aggregate(ctx context.Context, dbname, collname string, pipeline []bson.M) ([]Record, error) {
+	m.mutex.RLock()
+	records, err := m.find(dbname, collname, bson.M{})
+	m.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return aggregateRecords(records, pipeline)
+}
+
+// helper function to run aggregation pipeline over given records
+func aggregateRecords(records []Record, pipeline []bson.M) ([]Record, error) {
+	for _, stage := range pipeline {
+		for op, val := range stage {
+			switch op {
+			case "$match":
+				spec, _ := toMap(val)
+				var out []Record
+				for _, rec := range records {
+					matched, err := matchRecord(rec, spec)
+					if err != nil {
+						return nil, err
+					}
+					if matched {
+						out = append(out, rec)
+					}
+				}
+				records = out
+			case "$unwind":
+				records = unwindRecords(records, val)
+			case "$group":
+				group, _ := toMap(val)
+				records = groupRecords(records, group)
+			case "$facet":
+				facets, _ := toMap(val)
+				out := make(Record)
+				for name, sub := range facets {
+					subPipeline, ok := sub.([]bson.M)
+					if !ok {
+						return nil, fmt.Errorf("unsupported pipeline of facet %s", name)
+					}
+					recs, err := aggregateRecords(records, subPipeline)
+					if err != nil {
+						return nil, err
+					}
+					var values []any
+					for _, rec := range recs {
+						values = append(values, rec)
+					}
+					out[name] = values
+				}
+				records = []Record{out}
+			default:
+				return nil, fmt.Errorf("unsupported aggregation stage %s", op)
+			}
+		}
+	}
+	return records, nil
+}
+
+// helper function to resolve field reference, e.g. $Cycle, of given record
+func fieldValue(rec Record, ref any) any {
+	if name, ok := ref.(string); ok && strings.HasPrefix(name, "$") {
+		return rec[name[1:]]
+	}
+	return ref
+}
+
+// helper function to unwind list values of records for given field path
+func unwindRecords(records []Record, val any) []Record {
+	path := val
+	if opts, ok := toMap(val); ok {
+		path = opts["path"]
+	}
+	key := strings.TrimPrefix(fmt.Sprintf("%v", path), "$")
+	var out []Record
+	for _, rec := range records {
+		vals, ok := toList(rec[key])
+		if !ok {
+			out = append(out, rec)
+			continue
+		}
+		if len(vals) == 0 {
+			r := copyRecord(rec)
+			delete(r, key)
+			out = append(out, r)
+		}
+		for _, v := range vals {
+			r := copyRecord(rec)
+			r[key] = v
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// helper function to group records according to $group stage
+func groupRecords(records []Record, group bson.M) []Record {
+	groups := make(map[string]Record)
+	for _, rec := range records {
+		var gid any
+		if ids, ok := toMap(group["_id"]); ok {
+			id := make(Record)
+			for k, ref := range ids {
+				id[k] = fieldValue(rec, ref)
+			}
+			gid = id
+		} else {
+			gid = fieldValue(rec, group["_id"])
+		}
+		gkey := fmt.Sprintf("%v", gid)
+		out, ok := groups[gkey]
+		if !ok {
+			out = Record{"_id": gid}
+			groups[gkey] = out
+		}
+		for field, acc := range group {
+			if field == "_id" {
+				continue
+			}
+			ops, _ := toMap(acc)
+			for op, ref := range ops {
+				value := fieldValue(rec, ref)
+				switch op {
+				case "$sum":
+					out[field] = intValue(out[field]) + intValue(value)
+				case "$push":
+					vals, _ := out[field].([]any)
+					out[field] = append(vals, value)
+				case "$addToSet":
+					vals, _ := out[field].([]any)
+					found := false
+					for _, v := range vals {
+						if equalValues(v, value) {
+							found = true
+							break
+						}
+					}
+					if !found {
+						vals = append(vals, value)
+					}
+					out[field] = vals
+				}
+			}
+		}
+	}
+	var out []Record
+	for _, k := range SortedKeys(groups) {
+		out = append(out, groups[k])
+	}
+	return out
+}
+
+// Indexes returns indexes of memory store collection, the memory store does
+// not use indexes for look-ups and only keeps their definitions
+func (m *MemoryStore) Indexes(ctx context.Context, dbname, collname string) ([]IndexSpec, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]IndexSpec{}, m.Indices[collectionName(dbname, collname)]...), nil
+}
+
+// CreateIndex creates index definition in memory store
+func (m *MemoryStore) CreateIndex(ctx context.Context, dbname string, spec IndexSpec) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cname := collectionName(dbname, spec.Collection)
+	for _, idx := range m.Indices[cname] {
+		if idx.Name == spec.Name {
+			return fmt.Errorf("index %s already exists", spec.Name)
+		}
+	}
+	m.Indices[cname] = append(m.Indices[cname], spec)
+	return nil
+}
+
+// DropIndex drops index definition from memory store
+func (m *MemoryStore) DropIndex(ctx context.Context, dbname, collname, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cname := collectionName(dbname, collname)
+	var indices []IndexSpec
+	for _, idx := range m.Indices[cname] {
+		if idx.Name != name {
+			indices = append(indices, idx)
+		}
+	}
+	m.Indices[cname] = indices
+	return nil
+}
+
+// helper function to convert given value to a map
+func toMap(val any) (map[string]any, bool) {
+	switch v := val.(type) {
+	case bson.M:
+		return v, true
+	case Record:
+		return v, true
+	case map[string]any:
+		return v, true
+	case primitive.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+// helper function to convert given value to a list
+func toList(val any) ([]any, bool) {
+	switch v := val.(type) {
+	case []any:
+		return v, true
+	case bson.A:
+		return v, true
+	case []bson.M:
+		var out []any
+		for _, e := range v {
+			out = append(out, e)
+		}
+		return out, true
+	case []Record:
+		var out []any
+		for _, e := range v {
+			out = append(out, e)
+		}
+		return out, true
+	case []string:
+		var out []any
+		for _, e := range v {
+			out = append(out, e)
+		}
+		return out, true
+	case []int:
+		var out []any
+		for _, e := range v {
+			out = append(out, e)
+		}
+		return out, true
+	case []float64:
+		var out []any
+		for _, e := range v {
+			out = append(out, e)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// helper function to compare two values using their string representation
+// which allows us to match numbers of different types, e.g. 1 and 1.0
+func equalValues(a, b any) bool {
+	if oid, ok := a.(primitive.ObjectID); ok {
+		a = oid.Hex()
+	}
+	if oid, ok := b.(primitive.ObjectID); ok {
+		b = oid.Hex()
+	}
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
+
+// helper function to check if record value matches given condition
+// record values which are lists match if any of their elements match
+func matchValue(value, cond any) bool {
+	if values, ok := toList(value); ok {
+		if clist, ok := toList(cond); ok {
+			return equalValues(values, clist)
+		}
+		for _, v := range values {
+			if matchValue(v, cond) {
+				return true
+			}
+		}
+		return false
+	}
+	return equalValues(value, cond)
+}
+
+// helper function to match record value against spec operators, unsupported
+// operators are reported as errors
+func matchOperators(rec Record, key string, ops map[string]any) (bool, error) {
+	value, exists := rec[key]
+	for op, cond := range ops {
+		switch op {
+		case "$regex":
+			pat := fmt.Sprintf("%v", cond)
+			if opts, ok := ops["$options"]; ok && strings.Contains(fmt.Sprintf("%v", opts), "i") {
+				pat = "(?i)" + pat
+			}
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				return false, fmt.Errorf("unable to compile regex %s, error %v", pat, err)
+			}
+			if !exists {
+				return false, nil
+			}
+			matched := false
+			if values, ok := toList(value); ok {
+				for _, v := range values {
+					if re.MatchString(fmt.Sprintf("%v", v)) {
+						matched = true
+						break
+					}
+				}
+			} else {
+				matched = re.MatchString(fmt.Sprintf("%v", value))
+			}
+			if !matched {
+				return false, nil
+			}
+		case "$options":
+			continue
+		case "$ne":
+			if exists && matchValue(value, cond) {
+				return false, nil
+			}
+		case "$exists":
+			if exists != (cond == true) {
+				return false, nil
+			}
+		case "$all":
+			values, ok := toList(cond)
+			if !ok || !exists {
+				return false, nil
+			}
+			for _, v := range values {
+				if !matchValue(value, v) {
+					return false, nil
+				}
+			}
+		case "$in":
+			values, ok := toList(cond)
+			if !ok || !exists {
+				return false, nil
+			}
+			matched := false
+			for _, v := range values {
+				if matchValue(value, v) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false, nil
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			if !exists {
+				return false, nil
+			}
+			c := compareValues(value, cond)
+			if (op == "$gt" && c <= 0) || (op == "$gte" && c < 0) ||
+				(op == "$lt" && c >= 0) || (op == "$lte" && c > 0) {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported operator %s in memory store", op)
+		}
+	}
+	return true, nil
+}
+
+// helper function to perform free text search over record string values
+func matchText(rec Record, cond any) bool {
+	ops, ok := toMap(cond)
+	if !ok {
+		return false
+	}
+	words := strings.Fields(strings.ToLower(fmt.Sprintf("%v", ops["$search"])))
+	if len(words) == 0 {
+		return false
+	}
+	var values []string
+	for k, v := range rec {
+		if k == "_id" {
+			continue
+		}
+		if vals, ok := toList(v); ok {
+			for _, vvv := range vals {
+				values = append(values, strings.ToLower(fmt.Sprintf("%v", vvv)))
+			}
+		} else {
+			values = append(values, strings.ToLower(fmt.Sprintf("%v", v)))
+		}
+	}
+	// MongoDB text search matches records which contain any of given words
+	for _, w := range words {
+		for _, v := range values {
+			if strings.Contains(v, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helper function to check if given record matches given spec, the error is
+// returned for operators which are not supported by memory store
+func matchRecord(rec Record, spec bson.M) (bool, error) {
+	for key, cond := range spec {
+		if key == "$text" {
+			if !matchText(rec, cond) {
+				return false, nil
+			}
+			continue
+		}
+		if key == "$and" {
+			conds, ok := toList(cond)
+			if !ok {
+				return false, fmt.Errorf("unsupported value of $and operator %v", cond)
+			}
+			for _, c := range conds {
+				cspec, ok := toMap(c)
+				if !ok {
+					return false, fmt.Errorf("unsupported condition of $and operator %v", c)
+				}
+				matched, err := matchRecord(rec, cspec)
+				if err != nil || !matched {
+					return false, err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			return false, fmt.Errorf("unsupported operator %s in memory store", key)
+		}
+		if ops, ok := toMap(cond); ok {
+			matched, err := matchOperators(rec, key, ops)
+			if err != nil || !matched {
+				return false, err
+			}
+			continue
+		}
+		value, ok := rec[key]
+		if !ok || !matchValue(value, cond) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
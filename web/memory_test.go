@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestMemoryStore
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	dbname := "chess"
+	collname := "test"
+	records := []Record{
+		Record{"dataset": "/a/b/c", "PI": "Alice", "Beamline": []string{"3A", "3B"}, "BTR": 1},
+		Record{"dataset": "/a/b/d", "PI": "Bob", "Beamline": []string{"1A3"}, "BTR": 2},
+	}
+	store.Insert(ctx, dbname, collname, records)
+	if n, _ := store.Count(ctx, dbname, collname, bson.M{}); n != 2 {
+		t.Errorf("wrong number of records %d", n)
+	}
+
+	// equality, list values and numbers
+	specs := []bson.M{
+		bson.M{"PI": "Alice"},
+		bson.M{"Beamline": "3B"},
+		bson.M{"BTR": 1.0},
+		bson.M{"PI": bson.M{"$regex": "^alice$", "$options": "i"}},
+		bson.M{"$text": bson.M{"$search": "alice carol"}},
+		bson.M{"PI": bson.M{"$in": []any{"Alice", "Carol"}}},
+		bson.M{"$and": []bson.M{bson.M{"PI": "Alice"}, bson.M{"BTR": 1}}},
+		bson.M{"$and": bson.A{bson.M{"PI": "Alice"}, bson.D{{Key: "BTR", Value: 1}}}},
+		bson.M{"$and": []any{map[string]any{"Beamline": "3A"}}},
+	}
+	for _, spec := range specs {
+		records, _ = store.Get(ctx, dbname, collname, spec, 0, -1)
+		if len(records) != 1 || records[0]["dataset"] != "/a/b/c" {
+			t.Errorf("spec %+v, wrong records %+v", spec, records)
+		}
+	}
+
+	// unsupported operators are reported as errors
+	specs = []bson.M{
+		bson.M{"$or": []bson.M{bson.M{"PI": "Alice"}}},
+		bson.M{"PI": bson.M{"$nin": []any{"Alice"}}},
+		bson.M{"$and": "PI"},
+		bson.M{"$and": []bson.M{bson.M{"PI": bson.M{"$size": 1}}}},
+	}
+	for _, spec := range specs {
+		if _, err := store.Get(ctx, dbname, collname, spec, 0, -1); err == nil {
+			t.Errorf("spec %+v, no error for unsupported operator", spec)
+		}
+		if _, err := store.Count(ctx, dbname, collname, spec); err == nil {
+			t.Errorf("spec %+v, no error for unsupported operator in count", spec)
+		}
+	}
+
+	// upsert existing record
+	rec := Record{"dataset": "/a/b/d", "PI": "Carol"}
+	if err := store.Upsert(ctx, dbname, collname, "dataset", []Record{rec}); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = store.Get(ctx, dbname, collname, bson.M{"PI": "Carol"}, 0, -1)
+	if len(records) != 1 || records[0]["BTR"] != 2 {
+		t.Errorf("wrong upserted records %+v", records)
+	}
+
+	// sorted look-up with pagination
+	records, _ = store.GetSorted(ctx, dbname, collname, bson.M{}, []string{"PI"}, 0, -1)
+	if len(records) != 2 || records[0]["PI"] != "Alice" {
+		t.Errorf("wrong sorted records %+v", records)
+	}
+	records, _ = store.GetSorted(ctx, dbname, collname, bson.M{}, []string{"-PI"}, 0, 1)
+	if len(records) != 1 || records[0]["PI"] != "Carol" {
+		t.Errorf("wrong sorted records in descending order %+v", records)
+	}
+	records, _ = store.Get(ctx, dbname, collname, bson.M{}, 1, 1)
+	if len(records) != 1 {
+		t.Errorf("wrong number of paginated records %+v", records)
+	}
+
+	// update and remove
+	store.Update(ctx, dbname, collname, bson.M{"dataset": "/a/b/c"}, bson.M{"$set": bson.M{"PI": "Dave"}})
+	if n, _ := store.Count(ctx, dbname, collname, bson.M{"PI": "Dave"}); n != 1 {
+		t.Errorf("record was not updated")
+	}
+	store.Remove(ctx, dbname, collname, bson.M{"PI": "Dave"})
+	if n, _ := store.Count(ctx, dbname, collname, bson.M{}); n != 1 {
+		t.Errorf("record was not removed")
+	}
+}
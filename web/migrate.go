@@ -0,0 +1,212 @@
+package main
+
+// migrate module provides versioned migrations of FilesDB schema. The
+// migrations are embedded into server binary and kept in
+// migrations/<driver>/<version>_<name>.sql files, the applied migrations are
+// recorded in schema_version table.
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var _migrations embed.FS
+
+// ErrSchemaAhead is returned when FilesDB schema is newer than server code
+var ErrSchemaAhead = errors.New("FilesDB schema is ahead of the server code")
+
+// Migration represents single FilesDB schema migration
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// _migrationHooks defines functions which complete migrations with given
+// names within migration transaction, e.g. to fill new columns of existing rows
+var _migrationHooks = map[string]func(tx *sql.Tx) error{
+	"dataset_parts": fillDatasetParts,
+}
+
+// helper function to get FilesDB driver name
+func filesDBDriver() string {
+	return strings.Split(Config.FilesDBUri, "://")[0]
+}
+
+// loadMigrations loads ordered migrations of given driver
+func loadMigrations(driver string) ([]Migration, error) {
+	var out []Migration
+	dir := path.Join("migrations", driver)
+	entries, err := _migrations.ReadDir(dir)
+	if err != nil {
+		return out, fmt.Errorf("no migrations found for FilesDB driver %s", driver)
+	}
+	for _, e := range entries {
+		fname := e.Name()
+		if e.IsDir() || !strings.HasSuffix(fname, ".sql") {
+			continue
+		}
+		arr := strings.SplitN(strings.TrimSuffix(fname, ".sql"), "_", 2)
+		version, err := strconv.Atoi(arr[0])
+		if err != nil || len(arr) != 2 {
+			return out, fmt.Errorf("invalid migration file name %s, should be <version>_<name>.sql", fname)
+		}
+		data, err := _migrations.ReadFile(path.Join(dir, fname))
+		if err != nil {
+			return out, err
+		}
+		out = append(out, Migration{Version: version, Name: arr[1], SQL: string(data)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i, m := range out {
+		if m.Version != i+1 {
+			return out, fmt.Errorf("migrations of %s driver are not sequential, expect version %d, got %d", driver, i+1, m.Version)
+		}
+	}
+	return out, nil
+}
+
+// helper function to split migration into individual statements
+func statements(data string) []string {
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	var out []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+// helper function to check if given table exists in FilesDB
+func tableExists(db *sql.DB, table string) bool {
+	rows, err := db.Query(fmt.Sprintf("SELECT 1 FROM %s WHERE 1=0", table))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// schemaVersion returns current version of FilesDB schema. Databases
+// created before schema_version table was introduced are recognized by
+// their tables and columns.
+func schemaVersion(db *sql.DB) (int, error) {
+	if !tableExists(db, "schema_version") {
+		if !tableExists(db, "files") {
+			return 0, nil
+		}
+		if rows, err := db.Query("SELECT size FROM files WHERE 1=0"); err == nil {
+			rows.Close()
+			return 2, nil
+		}
+		return 1, nil
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// helper function to create schema_version table and record versions of
+// existing database
+func initSchemaVersion(db *sql.DB, version int, migrations []Migration) error {
+	if tableExists(db, "schema_version") {
+		return nil
+	}
+	stmt := "CREATE TABLE schema_version (version INTEGER NOT NULL PRIMARY KEY, name VARCHAR(255), applied_at BIGINT)"
+	if _, err := db.Exec(stmt); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		stmt = "INSERT INTO schema_version (version,name,applied_at) VALUES (?,?,?)"
+		if _, err := db.Exec(rebind(stmt), m.Version, m.Name, time.Now().Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to apply single migration within transaction, please note
+// that MySQL commits schema changes implicitly
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range statements(m.SQL) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d_%s fails to execute %s, error %v", m.Version, m.Name, stmt, err)
+		}
+	}
+	if hook, ok := _migrationHooks[m.Name]; ok {
+		if err := hook(tx); err != nil {
+			return fmt.Errorf("migration %d_%s fails, error %v", m.Version, m.Name, err)
+		}
+	}
+	stmt := "INSERT INTO schema_version (version,name,applied_at) VALUES (?,?,?)"
+	if _, err := tx.Exec(rebind(stmt), m.Version, m.Name, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateFilesDB checks version of FilesDB schema and applies pending
+// migrations if requested, it returns list of applied migrations. It fails
+// if FilesDB schema is ahead of the code or if migrations are pending but
+// not requested.
+func MigrateFilesDB(db *sql.DB, apply bool) ([]Migration, error) {
+	var applied []Migration
+	migrations, err := loadMigrations(filesDBDriver())
+	if err != nil {
+		return applied, err
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		return applied, err
+	}
+	latest := len(migrations)
+	if version > latest {
+		return applied, fmt.Errorf("%w, FilesDB version %d, latest known version %d", ErrSchemaAhead, version, latest)
+	}
+	if version == latest {
+		return applied, initSchemaVersion(db, version, migrations)
+	}
+	if !apply {
+		return applied, fmt.Errorf("FilesDB version %d is behind latest version %d, please run migrations", version, latest)
+	}
+	if err := initSchemaVersion(db, version, migrations); err != nil {
+		return applied, err
+	}
+	for _, m := range migrations[version:] {
+		if err := applyMigration(db, m); err != nil {
+			return applied, err
+		}
+		log.Printf("FilesDB migration %d_%s is applied", m.Version, m.Name)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrations
+func TestMigrations(t *testing.T) {
+	initMetaDataService()
+	for _, driver := range []string{"sqlite3", "mysql", "postgres"} {
+		migrations, err := loadMigrations(driver)
+		if err != nil || len(migrations) < 2 {
+			t.Errorf("wrong migrations of %s driver %+v, error %v", driver, migrations, err)
+		}
+	}
+	if _, err := loadMigrations("oracle"); err == nil {
+		t.Error("no error for unknown driver")
+	}
+	if stmts := statements("-- comment\nCREATE TABLE a (id INTEGER);\n\nCREATE TABLE b (id INTEGER);\n"); len(stmts) != 2 {
+		t.Errorf("wrong statements %v", stmts)
+	}
+}
+
+// TestMigrateFilesDB
+func TestMigrateFilesDB(t *testing.T) {
+	initMetaDataService()
+	migrations, err := loadMigrations("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := len(migrations)
+
+	// new database gets all migrations
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "files.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := MigrateFilesDB(db, false); err == nil {
+		t.Error("no error for database which is behind the code")
+	}
+	applied, err := MigrateFilesDB(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := schemaVersion(db); len(applied) != latest || version != latest {
+		t.Errorf("wrong migration of new database, applied %d, version %d", len(applied), version)
+	}
+	if applied, err := MigrateFilesDB(db, true); err != nil || len(applied) != 0 {
+		t.Errorf("migrations are applied again %+v, error %v", applied, err)
+	}
+
+	// database ahead of the code is refused
+	if _, err := db.Exec("INSERT INTO schema_version (version,name) VALUES (?,?)", latest+1, "future"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateFilesDB(db, true); !errors.Is(err, ErrSchemaAhead) {
+		t.Errorf("wrong error for database ahead of the code %v", err)
+	}
+
+	// database created without schema_version table is recognized
+	legacy, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+	for _, stmt := range statements(migrations[0].SQL) {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := legacy.Exec("INSERT INTO datasets (dataset) VALUES (?)", "/2022-3/3A/123/Ti-1"); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := schemaVersion(legacy); version != 1 {
+		t.Errorf("wrong version of legacy database %d", version)
+	}
+	applied, err = MigrateFilesDB(legacy, true)
+	if err != nil || len(applied) != latest-1 {
+		t.Errorf("wrong migration of legacy database %+v, error %v", applied, err)
+	}
+	var n int
+	if err := legacy.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&n); err != nil || n != latest {
+		t.Errorf("wrong schema_version records %d, error %v", n, err)
+	}
+
+	// parts of existing datasets are filled by migration
+	var cycle, sample string
+	if err := legacy.QueryRow("SELECT cycle, sample FROM datasets").Scan(&cycle, &sample); err != nil || cycle != "2022-3" || sample != "Ti-1" {
+		t.Errorf("wrong dataset parts %s %s, error %v", cycle, sample, err)
+	}
+}
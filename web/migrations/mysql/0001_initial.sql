@@ -1,3 +1,4 @@
+-- initial FilesDB schema
 CREATE TABLE processing (
     processing_id INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
     processing VARCHAR(255) NOT NULL UNIQUE,
@@ -0,0 +1,2 @@
+-- JSON encoded discovery rules used to find files of the dataset
+ALTER TABLE datasets ADD COLUMN discovery TEXT;
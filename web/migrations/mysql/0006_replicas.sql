@@ -0,0 +1,16 @@
+-- sites table of other drivers is called sites
+RENAME TABLE site TO sites;
+-- replicas of registered files at sites, e.g. beamline buffer or archive
+CREATE TABLE replicas (
+    replica_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    file_id INTEGER NOT NULL REFERENCES files(file_id) ON UPDATE CASCADE,
+    site_id INTEGER NOT NULL REFERENCES sites(site_id) ON UPDATE CASCADE,
+    path VARCHAR(255) NOT NULL,
+    status VARCHAR(16),
+    create_at INTEGER,
+    create_by VARCHAR(255),
+    modify_at INTEGER,
+    modify_by VARCHAR(255),
+    UNIQUE(file_id, site_id)
+);
+CREATE INDEX replicas_site_idx ON replicas (site_id);
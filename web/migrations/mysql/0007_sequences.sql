@@ -0,0 +1,22 @@
+-- sequences of numbered files, e.g. detector frames, stored as printf
+-- pattern of file names along with range of frame numbers and its gaps
+CREATE TABLE sequences (
+    sequence_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    pattern VARCHAR(255) NOT NULL UNIQUE,
+    first_frame BIGINT NOT NULL,
+    last_frame BIGINT NOT NULL,
+    gaps TEXT,
+    frames BIGINT,
+    size BIGINT,
+    frame_size BIGINT,
+    mtime INTEGER,
+    tier VARCHAR(16),
+    is_file_valid INTEGER DEFAULT 1,
+    meta_id BIGINT REFERENCES metadata(meta_id) ON UPDATE CASCADE,
+    dataset_id BIGINT REFERENCES datasets(dataset_id) ON UPDATE CASCADE,
+    create_at INTEGER,
+    create_by VARCHAR(255),
+    modify_at INTEGER,
+    modify_by VARCHAR(255)
+);
+CREATE INDEX sequences_meta_idx ON sequences (meta_id);
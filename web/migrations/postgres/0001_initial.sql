@@ -0,0 +1,66 @@
+-- initial FilesDB schema
+create TABLE processing (
+    processing_id SERIAL PRIMARY KEY,
+    processing VARCHAR(255) NOT NULL UNIQUE,
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255)
+);
+create TABLE parents (
+    parent_id SERIAL PRIMARY KEY,
+    parent VARCHAR(255) NOT NULL UNIQUE,
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255)
+);
+create TABLE sites (
+    site_id SERIAL PRIMARY KEY,
+    site VARCHAR(255) NOT NULL UNIQUE,
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255)
+);
+create TABLE metadata (
+    meta_id BIGSERIAL PRIMARY KEY,
+    did VARCHAR(255) NOT NULL UNIQUE,
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255)
+);
+create TABLE datasets (
+    dataset_id SERIAL PRIMARY KEY,
+    dataset VARCHAR(255) NOT NULL UNIQUE,
+    meta_id BIGINT REFERENCES metadata(meta_id) ON UPDATE CASCADE,
+    site_id INTEGER REFERENCES sites(site_id) ON UPDATE CASCADE,
+    processing_id INTEGER REFERENCES processing(processing_id) ON UPDATE CASCADE,
+    parent_id INTEGER REFERENCES parents(parent_id) ON UPDATE CASCADE,
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255)
+);
+create TABLE buckets (
+    bucket_id SERIAL PRIMARY KEY,
+    bucket VARCHAR(255) NOT NULL UNIQUE,
+    meta_id BIGINT REFERENCES metadata(meta_id) ON UPDATE CASCADE,
+    dataset_id INTEGER REFERENCES datasets(dataset_id) ON UPDATE CASCADE,
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255)
+);
+create TABLE files (
+    file_id BIGSERIAL PRIMARY KEY,
+    file VARCHAR(4096) NOT NULL UNIQUE,
+    is_file_valid INTEGER DEFAULT 1,
+    meta_id BIGINT REFERENCES metadata(meta_id) ON UPDATE CASCADE,
+    dataset_id INTEGER REFERENCES datasets(dataset_id) ON UPDATE CASCADE,
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255)
+);
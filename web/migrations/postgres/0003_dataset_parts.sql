@@ -0,0 +1,9 @@
+-- cycle, beamline, BTR and sample parts of /cycle/beamline/BTR/sample datasets
+ALTER TABLE datasets ADD COLUMN cycle VARCHAR(255);
+ALTER TABLE datasets ADD COLUMN beamline VARCHAR(255);
+ALTER TABLE datasets ADD COLUMN btr VARCHAR(255);
+ALTER TABLE datasets ADD COLUMN sample VARCHAR(255);
+CREATE INDEX datasets_cycle_idx ON datasets (cycle);
+CREATE INDEX datasets_beamline_idx ON datasets (beamline);
+CREATE INDEX datasets_btr_idx ON datasets (btr);
+CREATE INDEX datasets_sample_idx ON datasets (sample);
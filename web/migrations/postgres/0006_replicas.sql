@@ -0,0 +1,14 @@
+-- replicas of registered files at sites, e.g. beamline buffer or archive
+create TABLE replicas (
+    replica_id BIGSERIAL PRIMARY KEY,
+    file_id BIGINT NOT NULL REFERENCES files(file_id) ON UPDATE CASCADE,
+    site_id INTEGER NOT NULL REFERENCES sites(site_id) ON UPDATE CASCADE,
+    path VARCHAR(4096) NOT NULL,
+    status VARCHAR(16),
+    create_at BIGINT,
+    create_by VARCHAR(255),
+    modify_at BIGINT,
+    modify_by VARCHAR(255),
+    UNIQUE(file_id, site_id)
+);
+CREATE INDEX replicas_site_idx ON replicas (site_id);
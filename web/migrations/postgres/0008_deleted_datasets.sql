@@ -0,0 +1,4 @@
+-- soft deletion flag of datasets whose meta-data records are deleted, files
+-- of deleted records are already marked as deleted
+ALTER TABLE datasets ADD COLUMN is_deleted INTEGER DEFAULT 0;
+UPDATE datasets SET is_deleted=1 WHERE dataset_id IN (SELECT dataset_id FROM files WHERE is_file_valid=2);
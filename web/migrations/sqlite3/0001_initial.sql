@@ -1,5 +1,4 @@
---https://stackoverflow.com/questions/18387209/sqlite-syntax-for-creating-table-with-foreign-key
-
+-- initial FilesDB schema
 create TABLE processing (
     processing_id INTEGER PRIMARY KEY AUTOINCREMENT,
     processing VARCHAR(255) NOT NULL UNIQUE,
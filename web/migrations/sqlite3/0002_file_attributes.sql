@@ -0,0 +1,5 @@
+-- size, modification time, checksum and entry type of registered files
+ALTER TABLE files ADD COLUMN size BIGINT;
+ALTER TABLE files ADD COLUMN mtime INTEGER;
+ALTER TABLE files ADD COLUMN checksum VARCHAR(255);
+ALTER TABLE files ADD COLUMN entry_type VARCHAR(16);
@@ -0,0 +1,4 @@
+-- data tier of registered files, e.g. RAW or REDUCED
+ALTER TABLE files ADD COLUMN tier VARCHAR(16);
+UPDATE files SET tier='RAW' WHERE tier IS NULL;
+CREATE INDEX files_tier_idx ON files (tier);
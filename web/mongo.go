@@ -10,15 +10,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	bson "go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
 // Record define Mongo record
@@ -159,123 +162,240 @@ func GetInt64Value(rec Record, key string) (int64, error) {
 
 // Connection defines connection to MongoDB
 type Connection struct {
-	Client *mongo.Client
-	URI    string
+	Client  *mongo.Client
+	URI     string
+	Timeout time.Duration
+	mutex   sync.Mutex
 }
 
 // InitMongoDB initializes MongoDB connection object
 func InitMongoDB(uri string) {
-	Mongo = Connection{URI: uri}
+	Mongo = Connection{URI: uri, Timeout: time.Duration(Config.MongoTimeout) * time.Second}
+}
+
+// helper function to return timeout of MongoDB operations
+func (m *Connection) timeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return 10 * time.Second
 }
 
 // Connect provides connection to MongoDB
-func (m *Connection) Connect() *mongo.Client {
-	var err error
+func (m *Connection) Connect(ctx context.Context) (*mongo.Client, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	if m.Client != nil {
-		return m.Client
+		return m.Client, nil
 	}
-	client, err := mongo.NewClient(options.Client().ApplyURI(m.URI))
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(m.URI))
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("ERROR: unable to connect to MongoDB, error %v", err)
+		return nil, storeError(err)
 	}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	err = client.Connect(ctx)
-	if err != nil {
-		log.Fatal(err)
+	// make sure that MongoDB is reachable
+	if err := client.Ping(ctx, nil); err != nil {
+		log.Printf("ERROR: unable to ping MongoDB, error %v", err)
+		client.Disconnect(context.Background())
+		return nil, storeError(err)
 	}
 	m.Client = client
-	return client
+	return client, nil
+}
+
+// helper function to drop given client, next Connect call will reconnect
+func (m *Connection) reset(client *mongo.Client) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.Client == client {
+		m.Client = nil
+		go client.Disconnect(context.Background())
+	}
+}
+
+// helper function to check if given error is caused by lost connection
+func isConnectionError(err error) bool {
+	var serr topology.ServerSelectionError
+	if errors.As(err, &serr) {
+		return true
+	}
+	if errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+}
+
+// helper function to wrap connection errors into ErrStoreUnavailable
+func storeError(err error) error {
+	if err == nil || errors.Is(err, ErrStoreUnavailable) {
+		return err
+	}
+	if isConnectionError(err) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrStoreUnavailable, err)
+	}
+	return err
+}
+
+// helper function to run given idempotent operation, e.g. read or upsert,
+// with per-request timeout, the operation is retried once with new client if
+// connection was dropped
+func (m *Connection) run(ctx context.Context, op func(ctx context.Context, client *mongo.Client) error) error {
+	return m.exec(ctx, 2, op)
+}
+
+// helper function to run given operation which is not idempotent, e.g.
+// insert, with per-request timeout. The operation is not retried since it
+// may have reached the server before connection was dropped, but the client
+// is dropped such that next operation reconnects.
+func (m *Connection) write(ctx context.Context, op func(ctx context.Context, client *mongo.Client) error) error {
+	return m.exec(ctx, 1, op)
+}
+
+// helper function to run given operation up to given number of attempts
+func (m *Connection) exec(ctx context.Context, attempts int, op func(ctx context.Context, client *mongo.Client) error) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		var client *mongo.Client
+		client, err = m.Connect(ctx)
+		if err != nil {
+			return err
+		}
+		octx, cancel := context.WithTimeout(ctx, m.timeout())
+		err = op(octx, client)
+		cancel()
+		if err == nil || !isConnectionError(err) || ctx.Err() != nil {
+			return storeError(err)
+		}
+		log.Printf("WARNING: MongoDB connection is lost, error %v, reconnecting", err)
+		m.reset(client)
+	}
+	return storeError(err)
 }
 
 // Mongo holds MongoDB connection
 var Mongo Connection
 
 // Insert records into MongoDB
-func Insert(dbname, collname string, records []Record) {
-	client := Mongo.Connect()
-	ctx := context.TODO()
-	c := client.Database(dbname).Collection(collname)
-	for _, rec := range records {
-		if _, err := c.InsertOne(ctx, &rec); err != nil {
-			log.Printf("Fail to insert record %v, error %v\n", rec, err)
-		}
+func (m *Connection) Insert(ctx context.Context, dbname, collname string, records []Record) error {
+	if len(records) == 0 {
+		return nil
 	}
+	return m.write(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
+		var docs []any
+		for _, rec := range records {
+			docs = append(docs, rec)
+		}
+		if _, err := c.InsertMany(ctx, docs); err != nil {
+			log.Printf("Fail to insert %d records, error %v\n", len(records), err)
+			return bulkError(err)
+		}
+		return nil
+	})
 }
 
-// MongoUpsert records into MongoDB
-func MongoUpsert(dbname, collname, attr string, records []Record) error {
-	client := Mongo.Connect()
-	ctx := context.TODO()
-	c := client.Database(dbname).Collection(collname)
-	for _, rec := range records {
-		value := rec[attr].(string)
+// Upsert records into MongoDB using bulk write, the failures of individual
+// records are reported via BulkError
+func (m *Connection) Upsert(ctx context.Context, dbname, collname, attr string, records []Record) error {
+	var models []mongo.WriteModel
+	var indexes []int
+	for idx, rec := range records {
+		value, _ := rec[attr].(string)
 		if value == "" {
 			continue
 		}
 		spec := bson.M{attr: value}
-		update := bson.D{{"$set", rec}}
-		opts := options.Update().SetUpsert(true)
-		if _, err := c.UpdateOne(ctx, spec, update, opts); err != nil {
-			log.Printf("Fail to insert record %v, error %v\n", rec, err)
-			return err
+		update := bson.D{{Key: "$set", Value: rec}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(spec).SetUpdate(update).SetUpsert(true))
+		indexes = append(indexes, idx)
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	return m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
+		opts := options.BulkWrite().SetOrdered(false)
+		if _, err := c.BulkWrite(ctx, models, opts); err != nil {
+			log.Printf("Fail to upsert %d records, error %v\n", len(models), err)
+			berr := bulkError(err)
+			// map indexes of write models to indexes of records
+			if e, ok := berr.(*BulkError); ok {
+				rerr := &BulkError{Errors: make(map[int]error)}
+				for idx, err := range e.Errors {
+					rerr.Errors[indexes[idx]] = err
+				}
+				return rerr
+			}
+			return berr
+		}
+		return nil
+	})
+}
+
+// helper function to convert MongoDB bulk write exception into BulkError
+func bulkError(err error) error {
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && len(bwe.WriteErrors) > 0 && bwe.WriteConcernError == nil {
+		berr := &BulkError{Errors: make(map[int]error)}
+		for _, e := range bwe.WriteErrors {
+			berr.Errors[e.Index] = errors.New(e.Message)
 		}
+		return berr
 	}
-	return nil
+	return err
 }
 
-// MongoGet records from MongoDB
-func MongoGet(dbname, collname string, spec bson.M, idx, limit int) []Record {
+// Get records from MongoDB
+func (m *Connection) Get(ctx context.Context, dbname, collname string, spec bson.M, idx, limit int) ([]Record, error) {
 	out := []Record{}
-	client := Mongo.Connect()
-	ctx := context.TODO()
-	c := client.Database(dbname).Collection(collname)
-	var err error
-	if limit > 0 {
-		opts := options.Find().SetSkip(int64(idx)).SetLimit(int64(limit))
-		cur, err := c.Find(ctx, spec, opts)
-		if err != nil {
-			log.Printf("ERROR: spec=%+v, error=%v", spec, err)
-		}
-		cur.All(ctx, &out)
-	} else {
+	err := m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
 		opts := options.Find().SetSkip(int64(idx))
+		if limit > 0 {
+			opts.SetLimit(int64(limit))
+		}
 		cur, err := c.Find(ctx, spec, opts)
 		if err != nil {
 			log.Printf("ERROR: spec=%+v, error=%v", spec, err)
+			return err
 		}
-		cur.All(ctx, &out)
-	}
+		return cur.All(ctx, &out)
+	})
 	if err != nil {
 		log.Printf("Unable to get records, error %v\n", err)
 	}
-	return out
+	return out, err
 }
 
-// GetSorted records from MongoDB sorted by given key
-func GetSorted(dbname, collname string, spec bson.M, skeys []string) []Record {
+// GetSorted records from MongoDB sorted by given keys
+func (m *Connection) GetSorted(ctx context.Context, dbname, collname string, spec bson.M, skeys []string, idx, limit int) ([]Record, error) {
 	out := []Record{}
-	client := Mongo.Connect()
-	ctx := context.TODO()
-	c := client.Database(dbname).Collection(collname)
-	var sortSpec bson.M
-	for _, s := range skeys {
-		sortSpec[s] = 1
-	}
-	opts := options.Find().SetSort(sortSpec)
-	cur, err := c.Find(ctx, spec, opts)
-	cur.All(ctx, &out)
-	if err != nil {
-		log.Printf("Unable to sort records, error %v\n", err)
-		// try to fetch all unsorted data
-		cur, err := c.Find(ctx, spec)
+	err := m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
+		sortSpec := bson.D{}
+		for _, s := range skeys {
+			if strings.HasPrefix(s, "-") {
+				sortSpec = append(sortSpec, bson.E{Key: s[1:], Value: -1})
+			} else {
+				sortSpec = append(sortSpec, bson.E{Key: s, Value: 1})
+			}
+		}
+		opts := options.Find().SetSort(sortSpec).SetSkip(int64(idx))
+		if limit > 0 {
+			opts.SetLimit(int64(limit))
+		}
+		cur, err := c.Find(ctx, spec, opts)
 		if err != nil {
-			log.Printf("Unable to find records, error %v\n", err)
-			out = append(out, ErrorRecord(fmt.Sprintf("%v", err), MongoDBErrorName, MongoDBError))
-			return out
+			return err
 		}
-		cur.All(ctx, &out)
+		return cur.All(ctx, &out)
+	})
+	if err != nil {
+		log.Printf("Unable to sort records, error %v\n", err)
 	}
-	return out
+	return out, err
 }
 
 // helper function to present in bson selected fields
@@ -288,35 +408,143 @@ func sel(q ...string) (r bson.M) {
 }
 
 // Update inplace for given spec
-func Update(dbname, collname string, spec, newdata bson.M) {
-	client := Mongo.Connect()
-	ctx := context.TODO()
-	c := client.Database(dbname).Collection(collname)
-	_, err := c.UpdateOne(ctx, spec, newdata)
+func (m *Connection) Update(ctx context.Context, dbname, collname string, spec, newdata bson.M) (int, error) {
+	var matched int64
+	err := m.write(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
+		res, err := c.UpdateOne(ctx, spec, newdata)
+		if err == nil {
+			matched = res.MatchedCount
+		}
+		return err
+	})
 	if err != nil {
 		log.Printf("Unable to update record, spec %v, data %v, error %v\n", spec, newdata, err)
 	}
+	return int(matched), err
 }
 
-// MongoCount gets number records from MongoDB
-func MongoCount(dbname, collname string, spec bson.M) int {
-	client := Mongo.Connect()
-	ctx := context.TODO()
-	c := client.Database(dbname).Collection(collname)
-	nrec, err := c.CountDocuments(ctx, spec)
+// Count gets number records from MongoDB
+func (m *Connection) Count(ctx context.Context, dbname, collname string, spec bson.M) (int, error) {
+	var nrec int64
+	err := m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		var err error
+		c := client.Database(dbname).Collection(collname)
+		nrec, err = c.CountDocuments(ctx, spec)
+		return err
+	})
 	if err != nil {
 		log.Printf("Unable to count records, spec %v, error %v\n", spec, err)
 	}
-	return int(nrec)
+	return int(nrec), err
 }
 
 // Remove records from MongoDB
-func Remove(dbname, collname string, spec bson.M) {
-	client := Mongo.Connect()
-	ctx := context.TODO()
-	c := client.Database(dbname).Collection(collname)
-	_, err := c.DeleteMany(ctx, spec)
+func (m *Connection) Remove(ctx context.Context, dbname, collname string, spec bson.M) error {
+	err := m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
+		_, err := c.DeleteMany(ctx, spec)
+		return err
+	})
 	if err != nil {
 		log.Printf("Unable to remove records, spec %v, error %v\n", spec, err)
 	}
+	return err
+}
+
+// Indexes returns indexes of given MongoDB collection
+func (m *Connection) Indexes(ctx context.Context, dbname, collname string) ([]IndexSpec, error) {
+	var out []IndexSpec
+	err := m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		out = nil
+		c := client.Database(dbname).Collection(collname)
+		cur, err := c.Indexes().List(ctx)
+		if err != nil {
+			return err
+		}
+		defer cur.Close(ctx)
+		for cur.Next(ctx) {
+			var idx struct {
+				Name    string `bson:"name"`
+				Key     bson.D `bson:"key"`
+				Unique  bool   `bson:"unique"`
+				Weights bson.M `bson:"weights"`
+			}
+			if err := cur.Decode(&idx); err != nil {
+				return err
+			}
+			spec := IndexSpec{Name: idx.Name, Collection: collname, Unique: idx.Unique}
+			if len(idx.Weights) > 0 {
+				spec.Text = true
+				spec.Weights = make(map[string]int)
+				for k, v := range idx.Weights {
+					spec.Weights[k] = intValue(v)
+				}
+				spec.Keys = SortedKeys(spec.Weights)
+			} else {
+				for _, e := range idx.Key {
+					spec.Keys = append(spec.Keys, e.Key)
+				}
+			}
+			out = append(out, spec)
+		}
+		return cur.Err()
+	})
+	if err != nil {
+		log.Printf("Unable to list indexes of %s.%s, error %v\n", dbname, collname, err)
+	}
+	return out, err
+}
+
+// CreateIndex creates index in MongoDB collection
+func (m *Connection) CreateIndex(ctx context.Context, dbname string, spec IndexSpec) error {
+	return m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(spec.Collection)
+		opts := options.Index().SetName(spec.Name)
+		keys := bson.D{}
+		if spec.Text {
+			weights := bson.M{}
+			for _, k := range SortedKeys(spec.Weights) {
+				keys = append(keys, bson.E{Key: k, Value: "text"})
+				weights[k] = spec.Weights[k]
+			}
+			opts.SetWeights(weights)
+		} else {
+			for _, k := range spec.Keys {
+				keys = append(keys, bson.E{Key: k, Value: 1})
+			}
+			if spec.Unique {
+				opts.SetUnique(true)
+			}
+		}
+		model := mongo.IndexModel{Keys: keys, Options: opts}
+		_, err := c.Indexes().CreateOne(ctx, model)
+		return err
+	})
+}
+
+// DropIndex drops index of MongoDB collection
+func (m *Connection) DropIndex(ctx context.Context, dbname, collname, name string) error {
+	return m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
+		_, err := c.Indexes().DropOne(ctx, name)
+		return err
+	})
+}
+
+// Aggregate runs aggregation pipeline on MongoDB collection
+func (m *Connection) Aggregate(ctx context.Context, dbname, collname string, pipeline []bson.M) ([]Record, error) {
+	out := []Record{}
+	err := m.run(ctx, func(ctx context.Context, client *mongo.Client) error {
+		c := client.Database(dbname).Collection(collname)
+		cur, err := c.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		return cur.All(ctx, &out)
+	})
+	if err != nil {
+		log.Printf("Unable to aggregate records, pipeline %v, error %v\n", pipeline, err)
+	}
+	return out, err
 }
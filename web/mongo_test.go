@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 
 	bson "go.mongodb.org/mongo-driver/bson"
@@ -11,23 +12,31 @@ func TestMongoInsert(t *testing.T) {
 	// our db attributes
 	dbname := "chess"
 	collname := "test"
-	InitMongoDB(Config.URI)
+	InitMetadataStore(Config.URI)
+	ctx := context.Background()
 
 	// remove all records in test collection
-	Remove(dbname, collname, bson.M{})
+	if err := Remove(ctx, dbname, collname, bson.M{}); err != nil {
+		t.Fatal(err)
+	}
 
 	// insert one record
 	var records []Record
 	dataset := "/a/b/c"
 	rec := Record{"dataset": dataset}
 	records = append(records, rec)
-	Insert(dbname, collname, records)
+	if err := Insert(ctx, dbname, collname, records); err != nil {
+		t.Fatal(err)
+	}
 
 	// look-up one record
 	spec := bson.M{"dataset": dataset}
 	idx := 0
 	limit := 1
-	records = MongoGet(dbname, collname, spec, idx, limit)
+	records, err := MongoGet(ctx, dbname, collname, spec, idx, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(records) != 1 {
 		t.Errorf("unable to find records using spec '%s', records %+v", spec, records)
 	}
@@ -36,12 +45,15 @@ func TestMongoInsert(t *testing.T) {
 	rec = Record{"dataset": dataset, "test": 1}
 	records = []Record{}
 	records = append(records, rec)
-	err := MongoUpsert(dbname, collname, "dataset", records)
+	err = MongoUpsert(ctx, dbname, collname, "dataset", records)
 	if err != nil {
 		t.Error(err)
 	}
 	spec = bson.M{"test": 1}
-	records = MongoGet(dbname, collname, spec, idx, limit)
+	records, err = MongoGet(ctx, dbname, collname, spec, idx, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(records) != 1 {
 		t.Errorf("unable to find records using spec '%s', records %+v", spec, records)
 	}
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -117,6 +118,11 @@ func adjustQuery(spec bson.M) bson.M {
 	// TODO: take input query and change its keys to match schema
 	nspec := make(bson.M)
 	for kkk, val := range spec {
+		// keep free text search as is, it relies on text index
+		if kkk == "$text" {
+			nspec[kkk] = val
+			continue
+		}
 		if strings.HasPrefix(kkk, "$") {
 			continue
 		}
@@ -127,6 +133,11 @@ func adjustQuery(spec bson.M) bson.M {
 			}
 			continue
 		}
+		// dataset patterns are matched segment by segment
+		if kkk == "dataset" {
+			nspec[kkk] = datasetSpec(fmt.Sprintf("%v", val))
+			continue
+		}
 		// look-up appropriate schema key
 		if key, ok := _schemaKeys[strings.ToLower(kkk)]; ok {
 			// create regex for value if it is the string
@@ -148,3 +159,63 @@ func adjustQuery(spec bson.M) bson.M {
 	}
 	return nspec
 }
+
+// datasetSpec translates dataset pattern with wildcards in its segments,
+// e.g. /2022-3/3A/*/Ti*, into MongoDB regex anchored at the beginning of
+// dataset name, therefore the literal prefix of the pattern can use dataset
+// index. The * wildcard matches any characters within single segment and ?
+// matches single character, the dataset without wildcards is matched exactly.
+func datasetSpec(pattern string) any {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern
+	}
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return bson.M{"$regex": sb.String()}
+}
+
+// ParseSort parses sort parameters in key:asc or key:desc form and returns
+// list of sort keys where descending keys are prefixed with minus sign, the
+// keys should be part of our schemas
+func ParseSort(params []string) ([]string, error) {
+	var skeys []string
+	for _, param := range params {
+		for _, item := range strings.Split(param, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			arr := strings.Split(item, separator)
+			order := "asc"
+			if len(arr) == 2 {
+				order = strings.ToLower(arr[1])
+			} else if len(arr) > 2 {
+				return nil, fmt.Errorf("invalid sort parameter '%s'", item)
+			}
+			key, ok := _schemaKeys[strings.ToLower(arr[0])]
+			if !ok {
+				return nil, fmt.Errorf("unknown sort key '%s'", arr[0])
+			}
+			switch order {
+			case "asc":
+				skeys = append(skeys, key)
+			case "desc":
+				skeys = append(skeys, "-"+key)
+			default:
+				return nil, fmt.Errorf("invalid sort order '%s', should be asc or desc", arr[1])
+			}
+		}
+	}
+	return skeys, nil
+}
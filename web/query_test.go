@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -55,3 +57,54 @@ func TestQuery3(t *testing.T) {
 		t.Error("Fail TestQuery keys parsing")
 	}
 }
+
+// TestParseSort
+func TestParseSort(t *testing.T) {
+	initMetaDataService()
+	skeys, err := ParseSort([]string{"stringkey:desc,FloatKey", "BoolKey:asc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"-StringKey", "FloatKey", "BoolKey"}
+	if strings.Join(skeys, " ") != strings.Join(expect, " ") {
+		t.Errorf("wrong sort keys %v, expect %v", skeys, expect)
+	}
+	for _, param := range []string{"bla:asc", "StringKey:up", "StringKey:asc:desc"} {
+		if _, err := ParseSort([]string{param}); err == nil {
+			t.Errorf("no error for invalid sort parameter %s", param)
+		}
+	}
+}
+
+// TestDatasetQuery
+func TestDatasetQuery(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var records []Record
+	for _, dataset := range []string{"/2022-3/3A/123/Ti-1", "/2022-3/3A/123/Al-1", "/2022-3/3B/456/Ti-2", "/2022-3/3A/1/2/Ti-3"} {
+		records = append(records, Record{"dataset": dataset})
+	}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, records); err != nil {
+		t.Fatal(err)
+	}
+	for query, expect := range map[string]int{
+		"dataset:/2022-3/3A/123/Ti-1": 1,
+		"dataset:/2022-3/3A/*/Ti*":    1,
+		"dataset:/2022-3/*/*/Ti*":     2,
+		"dataset:/2022-?/3?/*/*":      3,
+		"dataset:/2022.3/*/*/*":       0,
+	} {
+		spec, err := ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := MongoGet(ctx, Config.DBName, Config.DBColl, spec, 0, -1)
+		if err != nil || len(records) != expect {
+			t.Errorf("query %s with spec %v returns %d records, expect %d, error %v", query, spec, len(records), expect, err)
+		}
+	}
+	if spec, _ := ParseQuery("dataset:/2022-3/3A/123/Ti-1"); spec["dataset"] != "/2022-3/3A/123/Ti-1" {
+		t.Errorf("dataset without wildcards is not matched exactly %v", spec)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+// replicas module provides catalog of dataset files replicated to several
+// sites, e.g. beamline buffer and central archive
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// replica states
+const (
+	ReplicaAvailable = "available" // replica can be accessed
+	ReplicaPending   = "pending"   // replica is being transferred to the site
+	ReplicaLost      = "lost"      // replica is no longer accessible
+)
+
+// ReplicaStates lists supported replica states
+var ReplicaStates = []string{ReplicaAvailable, ReplicaPending, ReplicaLost}
+
+// Site represents configuration of storage site
+type Site struct {
+	URL string `json:"url"` // access URL template, e.g. root://host//{path} or file://{path}
+}
+
+// Replica represents copy of a file at a site
+type Replica struct {
+	Site   string `json:"site"`
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	URL    string `json:"url"`
+}
+
+// FileReplicas represents file along with its replicas, the first replica
+// is the registered file at the primary site
+type FileReplicas struct {
+	File     string    `json:"file"`
+	Tier     string    `json:"tier,omitempty"`
+	Replicas []Replica `json:"replicas"`
+}
+
+// helper function to get name of the site where files are registered
+func primarySite() string {
+	if Config.Site == "" {
+		return "local"
+	}
+	return Config.Site
+}
+
+// accessURL builds access URL of given path at given site using site URL
+// template, {site} and {path} placeholders of the template are replaced
+// with site name and path, sites without template provide file:// URLs
+func accessURL(site, path string) string {
+	tmpl := "file://{path}"
+	if s, ok := Config.Sites[site]; ok && s.URL != "" {
+		tmpl = s.URL
+	}
+	return strings.NewReplacer("{site}", site, "{path}", path).Replace(tmpl)
+}
+
+// helper function to get common directory of dataset files
+func commonDir(files []FileInfo) string {
+	if len(files) == 0 {
+		return ""
+	}
+	common := strings.Split(files[0].Name, "/")
+	for _, f := range files[1:] {
+		parts := strings.Split(f.Name, "/")
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	dir := strings.Join(common, "/")
+	// single file dataset
+	if len(files) == 1 && files[0].Type != EntryDir {
+		dir = filepath.Dir(dir)
+	}
+	if dir == "" {
+		dir = "/"
+	}
+	return dir
+}
+
+// replicaPath returns path of a file at the site, the prefix of the file
+// name is replaced with site root
+func replicaPath(name, prefix, root string) (string, error) {
+	if name != prefix && !strings.HasPrefix(name, strings.TrimSuffix(prefix, "/")+"/") {
+		return "", fmt.Errorf("file %s is not located under %s", name, prefix)
+	}
+	return filepath.Join(root, strings.TrimPrefix(name, prefix)), nil
+}
+
+// addReplicas registers replicas of valid files of given did at the site,
+// the replica path of every file is site root followed by file name with
+// stripped prefix, the prefix defaults to common directory of dataset files.
+// The replicas can be restricted to single file. Existing replicas are
+// updated. Sequences of the did are expanded into individual files since
+// replicas refer to individual files. It returns number of added or updated
+// replicas.
+func addReplicas(did, site, root, prefix, status, file string) (int, error) {
+	if site == "" || site == primarySite() {
+		return 0, fmt.Errorf("invalid replica site '%s'", site)
+	}
+	if !filepath.IsAbs(root) {
+		return 0, fmt.Errorf("replica root '%s' should be absolute path", root)
+	}
+	if status == "" {
+		status = ReplicaAvailable
+	}
+	if !InList(status, ReplicaStates) {
+		return 0, fmt.Errorf("unsupported replica status '%s', should be one of %s", status, strings.Join(ReplicaStates, ", "))
+	}
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		log.Printf("ERROR: DB error %v\n", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+	metaId, datasetId, err := datasetIDs(tx, did)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := expandSequences(tx, metaId, datasetId); err != nil {
+		return 0, err
+	}
+	stmt := "SELECT file_id, file, entry_type FROM files WHERE meta_id=? AND is_file_valid=1 ORDER BY file"
+	res, err := tx.Query(rebind(stmt), metaId)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+		return 0, err
+	}
+	var ids []int64
+	var files []FileInfo
+	for res.Next() {
+		var id int64
+		var name string
+		var etype sql.NullString
+		if err := res.Scan(&id, &name, &etype); err != nil {
+			res.Close()
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return 0, err
+		}
+		ids = append(ids, id)
+		files = append(files, FileInfo{Name: name, Type: etype.String})
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return 0, err
+	}
+	if prefix == "" {
+		prefix = commonDir(files)
+	}
+	siteId, err := lookupID(tx, "sites", "site", site)
+	if err != nil {
+		return 0, err
+	}
+	modify_at := time.Now().Unix()
+	modify_by := "MetaData server"
+	istmt := "INSERT INTO replicas (file_id,site_id,path,status,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?,?,?,?)"
+	ustmt := "UPDATE replicas SET path=?,status=?,modify_at=?,modify_by=? WHERE file_id=? AND site_id=?"
+	var nrep int
+	for i, f := range files {
+		if file != "" && f.Name != file {
+			continue
+		}
+		path, err := replicaPath(f.Name, prefix, root)
+		if err != nil {
+			return 0, err
+		}
+		added, err := insertUnique(tx, istmt, ids[i], siteId, path, status, modify_at, modify_by, modify_at, modify_by)
+		if err != nil {
+			log.Printf("ERROR: unable to execute %s with file=%v, error=%v", istmt, f.Name, err)
+			return 0, err
+		}
+		if !added {
+			if _, err := tx.Exec(rebind(ustmt), path, status, modify_at, modify_by, ids[i], siteId); err != nil {
+				log.Printf("ERROR: unable to execute %s with file=%v, error=%v", ustmt, f.Name, err)
+				return 0, err
+			}
+		}
+		nrep++
+	}
+	if file != "" && nrep == 0 {
+		return 0, fmt.Errorf("file %s is not found in did %s", file, did)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: unable to commit, error=%v", err)
+		return 0, err
+	}
+	return nrep, nil
+}
+
+// removeReplicas removes replicas of files of given did at the site, the
+// removal can be restricted to single file. It returns number of removed
+// replicas.
+func removeReplicas(did, site, file string) (int64, error) {
+	stmt := "DELETE FROM replicas WHERE site_id IN (SELECT site_id FROM sites WHERE site=?) AND file_id IN (SELECT F.file_id FROM files F JOIN metadata M ON M.meta_id=F.meta_id WHERE M.did=?"
+	args := []any{site, did}
+	if file != "" {
+		stmt += " AND F.file=?"
+		args = append(args, file)
+	}
+	stmt += ")"
+	res, err := FilesDB.Exec(rebind(stmt), args...)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with did=%v, error=%v", stmt, did, err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// findReplicas returns replicas of files of given did or of single file,
+// the replicas can be restricted to given site
+func findReplicas(did, file, site string) ([]FileReplicas, error) {
+	var out []FileReplicas
+	stmt := "SELECT F.file, F.tier, F.is_file_valid, S.site, R.path, R.status FROM files F JOIN metadata M ON M.meta_id=F.meta_id LEFT JOIN replicas R ON R.file_id=F.file_id LEFT JOIN sites S ON S.site_id=R.site_id WHERE "
+	var args []any
+	var conds []string
+	if did != "" {
+		conds = append(conds, "M.did=?")
+		args = append(args, did)
+	}
+	if file != "" {
+		conds = append(conds, "F.file=?")
+		args = append(args, file)
+	}
+	if len(conds) == 0 {
+		return out, errors.New("either did or file should be provided")
+	}
+	stmt += strings.Join(conds, " AND ") + " ORDER BY F.file, S.site"
+	res, err := FilesDB.Query(rebind(stmt), args...)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return out, err
+	}
+	defer res.Close()
+	primary := primarySite()
+	for res.Next() {
+		var name string
+		var tier, rsite, rpath, rstatus sql.NullString
+		var valid sql.NullInt64
+		if err := res.Scan(&name, &tier, &valid, &rsite, &rpath, &rstatus); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return out, err
+		}
+		if len(out) == 0 || out[len(out)-1].File != name {
+			frep := FileReplicas{File: name, Tier: tier.String, Replicas: []Replica{}}
+			if site == "" || site == primary {
+				status := ReplicaAvailable
+				if valid.Valid && valid.Int64 != 1 {
+					status = ReplicaLost
+				}
+				frep.Replicas = append(frep.Replicas, Replica{Site: primary, Path: name, Status: status, URL: accessURL(primary, name)})
+			}
+			out = append(out, frep)
+		}
+		if !rsite.Valid || (site != "" && rsite.String != site) {
+			continue
+		}
+		frep := &out[len(out)-1]
+		frep.Replicas = append(frep.Replicas, Replica{
+			Site:   rsite.String,
+			Path:   rpath.String,
+			Status: rstatus.String,
+			URL:    accessURL(rsite.String, rpath.String),
+		})
+	}
+	if err := res.Err(); err != nil {
+		return out, err
+	}
+	// frames of sequences are available only at the primary site
+	if len(out) == 0 && file != "" && (site == "" || site == primary) {
+		seq, ok, err := findSequence(did, "", file)
+		if err != nil || !ok {
+			return out, err
+		}
+		rep := Replica{Site: primary, Path: file, Status: ReplicaAvailable, URL: accessURL(primary, file)}
+		out = append(out, FileReplicas{File: file, Tier: seq.Tier, Replicas: []Replica{rep}})
+	}
+	return out, nil
+}
+
+// helper function to check that user is allowed to manage replicas of did
+func checkReplicasUser(ctx context.Context, did, user string) error {
+	records, err := MongoGet(ctx, Config.DBName, Config.DBColl, bson.M{"did": did}, 0, 1)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no record found for did %s", did)
+	}
+	if !canManage(user, records[0]) {
+		return fmt.Errorf("user %s is not allowed to manage replicas of did %s", user, did)
+	}
+	return nil
+}
+
+// ReplicasHandler provides replica catalog of dataset files:
+// GET /replicas?did=123 or /replicas?file=/path/file lists replicas,
+// POST /replicas with did, site, root and optional prefix, status and file
+// parameters adds or updates replicas, DELETE /replicas?did=123&site=archive
+// removes replicas, both optionally restricted to single file
+func ReplicasHandler(w http.ResponseWriter, r *http.Request) {
+	did := r.FormValue("did")
+	file := r.FormValue("file")
+	site := r.FormValue("site")
+	if r.Method == "GET" {
+		replicas, err := findReplicas(did, file, site)
+		if err != nil {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(replicas)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	user, _ := username(r)
+	if Config.TestMode && user == "" {
+		user = "test"
+	}
+	if did == "" || site == "" {
+		jsonResponse(w, errors.New("did and site parameters are required"), http.StatusBadRequest)
+		return
+	}
+	if err := checkReplicasUser(r.Context(), did, user); err != nil {
+		jsonResponse(w, err, http.StatusForbidden)
+		return
+	}
+	var nrep int64
+	if r.Method == "DELETE" {
+		n, err := removeReplicas(did, site, file)
+		if err != nil {
+			jsonResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		nrep = n
+	} else {
+		n, err := addReplicas(did, site, r.FormValue("root"), r.FormValue("prefix"), r.FormValue("status"), file)
+		if err != nil {
+			jsonResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		nrep = int64(n)
+	}
+	rec := Record{"status": http.StatusOK, "replicas": nrep}
+	w.WriteHeader(http.StatusOK)
+	if body, err := json.Marshal(rec); err == nil {
+		w.Write(body)
+	}
+}
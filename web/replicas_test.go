@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to place replicas request
+func replicasRequest(t *testing.T, method string, form url.Values) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "/replicas?"+form.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	Handlers().ServeHTTP(rr, req)
+	return rr
+}
+
+// TestReplicas
+func TestReplicas(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+	sites := Config.Sites
+	defer func() { Config.Sites = sites }()
+	Config.Sites = map[string]Site{"archive": {URL: "root://xrootd.chess.org//{path}"}}
+
+	if dir := commonDir([]FileInfo{{Name: "/a/b/c.h5", Type: EntryFile}}); dir != "/a/b" {
+		t.Errorf("wrong common directory %s", dir)
+	}
+	if dir := commonDir([]FileInfo{{Name: "/a/b", Type: EntryDir}, {Name: "/a/b/c"}, {Name: "/a/b/d/e"}}); dir != "/a/b" {
+		t.Errorf("wrong common directory %s", dir)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.tiff", "b.tiff"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	did := "replicas-did"
+	dataset := "/replicas/a"
+	if err := InsertFiles(did, dataset, dir, DiscoveryRules{SkipDirs: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+	rec := Record{"did": did, "dataset": dataset, "path": dir, "User": "test"}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, []Record{rec}); err != nil {
+		t.Fatal(err)
+	}
+	fileA := filepath.Join(dir, "a.tiff")
+
+	// invalid requests
+	for _, form := range []url.Values{
+		{"did": {did}, "site": {"archive"}, "root": {"relative"}},
+		{"did": {did}, "site": {"archive"}, "root": {"/archive"}, "status": {"unknown"}},
+		{"did": {did}, "site": {primarySite()}, "root": {"/archive"}},
+		{"did": {did}, "root": {"/archive"}},
+	} {
+		if rr := replicasRequest(t, "POST", form); rr.Code != http.StatusBadRequest {
+			t.Errorf("wrong status %d for %v", rr.Code, form)
+		}
+	}
+
+	// replicate dataset to archive
+	rr := replicasRequest(t, "POST", url.Values{"did": {did}, "site": {"archive"}, "root": {"/archive/replicas"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"replicas":2`) {
+		t.Fatalf("wrong response %d %s", rr.Code, rr.Body.String())
+	}
+	// mark single replica as pending
+	rr = replicasRequest(t, "POST", url.Values{"did": {did}, "site": {"archive"}, "root": {"/archive/replicas"}, "status": {ReplicaPending}, "file": {fileA}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"replicas":1`) {
+		t.Fatalf("wrong response %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = replicasRequest(t, "GET", url.Values{"did": {did}})
+	var replicas []FileReplicas
+	if err := json.Unmarshal(rr.Body.Bytes(), &replicas); err != nil {
+		t.Fatalf("unable to parse %s, error %v", rr.Body.String(), err)
+	}
+	if len(replicas) != 2 || len(replicas[0].Replicas) != 2 {
+		t.Fatalf("wrong replicas %+v", replicas)
+	}
+	local, archive := replicas[0].Replicas[0], replicas[0].Replicas[1]
+	if local.Site != primarySite() || local.Path != fileA || local.URL != "file://"+fileA || local.Status != ReplicaAvailable {
+		t.Errorf("wrong primary replica %+v", local)
+	}
+	if archive.Path != "/archive/replicas/a.tiff" || archive.URL != "root://xrootd.chess.org///archive/replicas/a.tiff" || archive.Status != ReplicaPending {
+		t.Errorf("wrong archive replica %+v", archive)
+	}
+
+	// replicas of single file at given site
+	replicas, err = findReplicas("", fileA, "archive")
+	if err != nil || len(replicas) != 1 || len(replicas[0].Replicas) != 1 || replicas[0].Replicas[0].Site != "archive" {
+		t.Errorf("wrong file replicas %+v, error %v", replicas, err)
+	}
+
+	// remove replica of single file and then all replicas of the site
+	rr = replicasRequest(t, "DELETE", url.Values{"did": {did}, "site": {"archive"}, "file": {fileA}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"replicas":1`) {
+		t.Errorf("wrong response %d %s", rr.Code, rr.Body.String())
+	}
+	rr = replicasRequest(t, "DELETE", url.Values{"did": {did}, "site": {"archive"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"replicas":1`) {
+		t.Errorf("wrong response %d %s", rr.Code, rr.Body.String())
+	}
+	replicas, err = findReplicas(did, "", "archive")
+	if err != nil || len(replicas) != 2 || len(replicas[0].Replicas) != 0 {
+		t.Errorf("replicas are not removed %+v, error %v", replicas, err)
+	}
+
+	// other users can't manage replicas
+	if _, err := Update(ctx, Config.DBName, Config.DBColl, bson.M{"did": did}, bson.M{"$set": bson.M{"owner": "other"}}); err != nil {
+		t.Fatal(err)
+	}
+	rr = replicasRequest(t, "POST", url.Values{"did": {did}, "site": {"archive"}, "root": {"/archive"}})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("wrong status %d for other user", rr.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+// rescan module synchronizes FilesDB entries of datasets with content of
+// their directories, e.g. to pick up files written after dataset registration
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// RescanBatchSize defines number of records we read at once during rescan
+// of all datasets
+var RescanBatchSize = 1000
+
+// RescanReport represents changes of dataset files found by rescan
+type RescanReport struct {
+	Did       string   `json:"did"`
+	Dataset   string   `json:"dataset"`
+	Path      string   `json:"path"`
+	Added     []string `json:"added"`    // new files
+	Updated   []string `json:"updated"`  // files with changed size or modification time
+	Removed   []string `json:"removed"`  // vanished files which are marked as invalid
+	Restored  []string `json:"restored"` // invalid files which appeared again
+	Skipped   []string `json:"skipped"`  // new files registered with other dataset
+	Unchanged int      `json:"unchanged"`
+	Error     string   `json:"error,omitempty"`
+	Timestamp int64    `json:"timestamp"`
+	Elapsed   string   `json:"elapsed"`
+}
+
+// Changed checks if rescan found any changes
+func (r RescanReport) Changed() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed)+len(r.Restored) > 0
+}
+
+// String returns summary of the report
+func (r RescanReport) String() string {
+	return fmt.Sprintf("did=%s dataset=%s added=%d updated=%d removed=%d restored=%d skipped=%d unchanged=%d",
+		r.Did, r.Dataset, len(r.Added), len(r.Updated), len(r.Removed), len(r.Restored), len(r.Skipped), r.Unchanged)
+}
+
+// rescanRecord rescans directory of given meta-data record
+func rescanRecord(ctx context.Context, rec Record) (RescanReport, error) {
+	time0 := time.Now()
+	report := RescanReport{
+		Did:       fmt.Sprintf("%v", rec["did"]),
+		Dataset:   fmt.Sprintf("%v", rec["dataset"]),
+		Timestamp: time0.Unix(),
+	}
+	if path, ok := rec["path"].(string); ok {
+		report.Path = path
+	}
+	if isDeleted(rec) {
+		return report, fmt.Errorf("record of did %s is deleted", report.Did)
+	}
+	if report.Path == "" {
+		return report, fmt.Errorf("record of did %s has no path", report.Did)
+	}
+	// we do not invalidate files of directory which is not accessible, e.g.
+	// when file system is not mounted
+	if _, err := os.Stat(report.Path); err != nil {
+		return report, fmt.Errorf("unable to access %s, error %v", report.Path, err)
+	}
+	// rescan applies the same discovery rules which were used at registration
+	rules, err := recordDiscoveryRules(rec)
+	if err != nil {
+		return report, err
+	}
+	files, tiers, err := discoverLocations(recordLocations(rec), rules, false)
+	if err != nil {
+		return report, err
+	}
+	if err := syncFiles(report.Did, tiers, files, &report); err != nil {
+		return report, err
+	}
+	report.Elapsed = time.Since(time0).String()
+	if report.Changed() {
+		log.Printf("rescan %s", report.String())
+	}
+	if len(report.Added) > 0 || len(report.Restored) > 0 {
+		evt := recordEvent(EventFilesRegistered, rec)
+		evt.Path = report.Path
+		emitEvents(ctx, evt)
+	}
+	return report, nil
+}
+
+// rescanDID rescans directory of the record with given did, the user
+// should be allowed to manage the record
+func rescanDID(ctx context.Context, did, user string) (RescanReport, error) {
+	records, err := MongoGet(ctx, Config.DBName, Config.DBColl, bson.M{"did": did}, 0, 1)
+	if err != nil {
+		return RescanReport{Did: did}, err
+	}
+	if len(records) == 0 {
+		return RescanReport{Did: did}, fmt.Errorf("no record found for did %s", did)
+	}
+	if !canManage(user, records[0]) {
+		msg := fmt.Sprintf("user %s is not allowed to rescan did %s", user, did)
+		return RescanReport{Did: did}, errors.New(msg)
+	}
+	return rescanRecord(ctx, records[0])
+}
+
+// rescanRecords rescans directories of all active records, failures of
+// individual records are reported in their reports
+func rescanRecords(ctx context.Context) ([]RescanReport, error) {
+	var reports []RescanReport
+	spec := activeSpec(bson.M{"did": bson.M{"$exists": true}})
+	for idx := 0; ; idx += RescanBatchSize {
+		records, err := GetSorted(ctx, Config.DBName, Config.DBColl, spec, []string{"_id"}, idx, RescanBatchSize)
+		if err != nil {
+			return reports, err
+		}
+		for _, rec := range records {
+			report, err := rescanRecord(ctx, rec)
+			if err != nil {
+				log.Printf("ERROR: unable to rescan did %s, error %v", report.Did, err)
+				report.Error = err.Error()
+			}
+			reports = append(reports, report)
+		}
+		if len(records) < RescanBatchSize {
+			break
+		}
+	}
+	return reports, nil
+}
+
+// rescanFiles periodically rescans directories of all datasets, it is
+// enabled by rescanInterval configuration parameter
+func rescanFiles() {
+	interval := time.Duration(Config.RescanInterval) * time.Second
+	for {
+		time.Sleep(interval)
+		time0 := time.Now()
+		reports, err := rescanRecords(context.Background())
+		if err != nil {
+			log.Printf("ERROR: unable to rescan datasets, error %v", err)
+			continue
+		}
+		var changed, failed int
+		for _, r := range reports {
+			if r.Error != "" {
+				failed++
+			} else if r.Changed() {
+				changed++
+			}
+		}
+		log.Printf("rescan of %d datasets, %d changed, %d failed, elapsed time %v", len(reports), changed, failed, time.Since(time0))
+	}
+}
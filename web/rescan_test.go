@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRescan
+func TestRescan(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("f1", "data")
+	write("f2", "data")
+	did := "rescan-did"
+	dataset := "/rescan/a"
+	if err := InsertFiles(did, dataset, dir, DiscoveryRules{}); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+	rec := Record{"did": did, "dataset": dataset, "path": dir, "User": "test"}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, []Record{rec}); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is changed since registration
+	report, err := rescanDID(ctx, did, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Changed() || report.Unchanged != 3 {
+		t.Errorf("wrong rescan report %+v", report)
+	}
+
+	// new file, vanished file and changed file
+	write("f3", "data")
+	os.Remove(filepath.Join(dir, "f1"))
+	write("f2", "more data")
+	report, err = rescanDID(ctx, did, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || !strings.HasSuffix(report.Added[0], "f3") ||
+		len(report.Removed) != 1 || !strings.HasSuffix(report.Removed[0], "f1") ||
+		len(report.Updated) != 1 || !strings.HasSuffix(report.Updated[0], "f2") {
+		t.Errorf("wrong rescan report %+v", report)
+	}
+	var nvalid int
+	stmt := "SELECT COUNT(*) FROM files F JOIN metadata M ON M.meta_id=F.meta_id WHERE M.did=? AND F.is_file_valid=1"
+	if err := FilesDB.QueryRow(stmt, did).Scan(&nvalid); err != nil || nvalid != 3 {
+		t.Errorf("wrong number of valid files %d, error %v", nvalid, err)
+	}
+	files, _ := getFileInfos(did, "", false)
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, "f2") && f.Size != 9 {
+			t.Errorf("size of changed file is not updated %+v", f)
+		}
+	}
+
+	// vanished file appears again
+	write("f1", "data")
+	report, err = rescanDID(ctx, did, "test")
+	if err != nil || len(report.Restored) != 1 {
+		t.Errorf("wrong rescan report %+v, error %v", report, err)
+	}
+
+	// users may rescan only their records
+	if _, err := rescanDID(ctx, did, "other"); err == nil {
+		t.Error("no error for rescan of record of other user")
+	}
+
+	// rescan via HTTP
+	form := url.Values{"did": {did}}
+	if _, err := respRecorder("POST", "/rescan?"+form.Encode(), nil, RescanHandler); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+// revision module provides optimistic concurrency control of meta-data
+// records, every record carries revision counter which is incremented on
+// each update and updates based on stale revision are rejected
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// ConflictError represents update of a record based on stale revision
+type ConflictError struct {
+	RecordID string      `json:"record_id"`
+	Dataset  string      `json:"dataset"`
+	Revision int         `json:"revision"` // revision the update is based on
+	Current  int         `json:"current"`  // current revision of the record
+	Changes  []FieldDiff `json:"changes"`  // changes made since given revision
+}
+
+// Error implements error interface
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("record %s of dataset %s was modified, update is based on revision %d while current revision is %d", e.RecordID, e.Dataset, e.Revision, e.Current)
+}
+
+// helper function to get revision of given record, the records created
+// before revisions were introduced have revision 0
+func recordRevision(rec Record) int {
+	return intValue(rec["revision"])
+}
+
+// helper function to parse revision provided by the client either as
+// a number or as an ETag, e.g. "3" or W/"3"
+func parseRevision(val string) (int, error) {
+	val = strings.TrimPrefix(strings.TrimSpace(val), "W/")
+	val = strings.Trim(val, "\"")
+	rev, err := strconv.Atoi(val)
+	if err != nil || rev < 0 {
+		return 0, fmt.Errorf("invalid revision '%s'", val)
+	}
+	return rev, nil
+}
+
+// helper function to build ETag of given record
+func recordETag(rec Record) string {
+	return fmt.Sprintf("\"%d\"", recordRevision(rec))
+}
+
+// helper function to find snapshot of the record with given revision in
+// history collection
+func recordAtRevision(ctx context.Context, rid string, revision int) (Record, error) {
+	spec := bson.M{"record_id": rid}
+	hrecords, err := MongoGet(ctx, Config.DBName, Config.HistoryColl, spec, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hrecords, func(i, j int) bool {
+		return intValue(hrecords[i]["version"]) > intValue(hrecords[j]["version"])
+	})
+	for _, hrec := range hrecords {
+		snapshot := historySnapshot(hrec)
+		if recordRevision(snapshot) == revision {
+			return snapshot, nil
+		}
+	}
+	return nil, nil
+}
+
+// helper function to build conflict error for given current record and
+// revision the update is based on, the error lists changes made since that
+// revision if its snapshot is found in history
+func conflictError(ctx context.Context, current Record, revision int) error {
+	rid := recordID(current)
+	cerr := &ConflictError{
+		RecordID: rid,
+		Dataset:  fmt.Sprintf("%v", current["dataset"]),
+		Revision: revision,
+		Current:  recordRevision(current),
+	}
+	base, err := recordAtRevision(ctx, rid, revision)
+	if err != nil {
+		return err
+	}
+	if base != nil {
+		for _, d := range recordDiff(base, current) {
+			if d.Key != "revision" {
+				cerr.Changes = append(cerr.Changes, d)
+			}
+		}
+	}
+	return cerr
+}
+
+// helper function to update existing record only if it still has given
+// revision, otherwise conflict error is returned. If replace is set the keys
+// of existing record which are missing in new record are removed.
+func updateRevision(ctx context.Context, prev, rec Record, revision int, replace bool) error {
+	spec := bson.M{"_id": prev["_id"], "revision": revision}
+	if revision == 0 {
+		spec["revision"] = bson.M{"$exists": false}
+	}
+	data := make(Record)
+	for k, v := range rec {
+		if k != "_id" {
+			data[k] = v
+		}
+	}
+	update := bson.M{"$set": data}
+	if replace {
+		unset := bson.M{}
+		for k := range prev {
+			if _, ok := rec[k]; !ok && k != "_id" {
+				unset[k] = ""
+			}
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+	}
+	matched, err := Update(ctx, Config.DBName, Config.DBColl, spec, update)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		current, err := findRecord(ctx, recordID(prev))
+		if err != nil {
+			return err
+		}
+		return conflictError(ctx, current, revision)
+	}
+	return nil
+}
+
+// updateRecord updates existing record with given id using data provided by
+// the client, the server assigned keys are preserved and the update should
+// be based on current revision of the record
+func updateRecord(ctx context.Context, rid string, rec Record, revision int) (Record, error) {
+	current, err := findRecord(ctx, rid)
+	if err != nil {
+		return nil, err
+	}
+	if isDeleted(current) {
+		return nil, fmt.Errorf("record %s is deleted", rid)
+	}
+	for _, k := range []string{"did", "dataset", "path", "SchemaFile", "Schema"} {
+		if v, ok := current[k]; ok {
+			rec[k] = v
+		}
+	}
+	// updates are merged into existing record, therefore we validate the result
+	merged := copyRecord(current)
+	for k, v := range rec {
+		merged[k] = v
+	}
+	if err := validateRecord(merged); err != nil {
+		return nil, err
+	}
+	_, hasParent := rec["Parent"]
+	_, hasProcessing := rec["Processing"]
+	parent, processing, err := lineageKeys(merged)
+	if err != nil {
+		return nil, err
+	}
+	dataset := fmt.Sprintf("%v", current["dataset"])
+	if hasParent && parent != "" {
+		if err := checkParent(dataset, parent); err != nil {
+			return nil, err
+		}
+	}
+	rec["revision"] = revision
+	if err := upsertRecord(ctx, rec); err != nil {
+		return nil, err
+	}
+	if hasParent || hasProcessing {
+		if err := setLineage(dataset, parent, processing); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+// helper function to check if given error is a conflict error
+func isConflict(err error) (*ConflictError, bool) {
+	var cerr *ConflictError
+	if errors.As(err, &cerr) {
+		return cerr, true
+	}
+	return nil, false
+}
+
+// helper function to get revision the client based its update on, it can be
+// provided either via If-Match HTTP header or revision form value
+func requestRevision(r *http.Request) (int, bool, error) {
+	if val := r.Header.Get("If-Match"); val != "" {
+		rev, err := parseRevision(val)
+		return rev, true, err
+	}
+	if val := r.FormValue("revision"); val != "" {
+		rev, err := parseRevision(val)
+		return rev, true, err
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to insert test record and return its id
+func insertTestRecord(t *testing.T, ctx context.Context, dataset string) string {
+	rec := Record{
+		"StringKey":            "test",
+		"StrKeyMultipleValues": "bla",
+		"ListKey":              []string{"3A"},
+		"FloatKey":             1.1,
+		"BoolKey":              true,
+		"SchemaFile":           fullPath("schemas/test.json"),
+		"Schema":               "test",
+		"dataset":              dataset,
+		"User":                 "test",
+		"Description":          "first version",
+	}
+	if err := upsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	records, err := MongoGet(ctx, Config.DBName, Config.DBColl, bson.M{"dataset": dataset}, 0, 1)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("unable to find inserted record, error %v", err)
+	}
+	if rev := recordRevision(records[0]); rev != 1 {
+		t.Fatalf("wrong revision %d of new record", rev)
+	}
+	return recordID(records[0])
+}
+
+// TestParseRevision
+func TestParseRevision(t *testing.T) {
+	for val, expect := range map[string]int{"3": 3, "\"4\"": 4, "W/\"5\"": 5} {
+		rev, err := parseRevision(val)
+		if err != nil || rev != expect {
+			t.Errorf("wrong revision %d for %s, error %v", rev, val, err)
+		}
+	}
+	for _, val := range []string{"", "abc", "-1"} {
+		if _, err := parseRevision(val); err == nil {
+			t.Errorf("no error for invalid revision '%s'", val)
+		}
+	}
+}
+
+// TestRecordConflict
+func TestRecordConflict(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	rid := insertTestRecord(t, ctx, "/a/b/c/conflict")
+
+	// first update based on current revision succeeds
+	rec := Record{"StringKey": "alice", "User": "alice", "Description": "alice update"}
+	if _, err := updateRecord(ctx, rid, rec, 1); err != nil {
+		t.Fatal(err)
+	}
+	current, err := findRecord(ctx, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recordRevision(current) != 2 || current["dataset"] != "/a/b/c/conflict" {
+		t.Fatalf("wrong updated record %+v", current)
+	}
+
+	// second update based on the same revision is rejected
+	rec = Record{"StringKey": "bob", "User": "bob", "Description": "bob update"}
+	_, err = updateRecord(ctx, rid, rec, 1)
+	cerr, ok := isConflict(err)
+	if !ok {
+		t.Fatalf("no conflict error, error %v", err)
+	}
+	if cerr.Revision != 1 || cerr.Current != 2 {
+		t.Errorf("wrong conflict revisions %+v", cerr)
+	}
+	changes := make(map[string]FieldDiff)
+	for _, d := range cerr.Changes {
+		changes[d.Key] = d
+	}
+	if d, ok := changes["StringKey"]; !ok || d.Old != "test" || d.New != "alice" {
+		t.Errorf("wrong conflict changes %+v", cerr.Changes)
+	}
+	if _, ok := changes["revision"]; ok {
+		t.Errorf("revision should not be reported as a change %+v", cerr.Changes)
+	}
+	if status := errorStatus(err, http.StatusBadRequest); status != http.StatusConflict {
+		t.Errorf("wrong status %d of conflict error", status)
+	}
+	current, err = findRecord(ctx, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current["StringKey"] != "alice" {
+		t.Errorf("stale update is applied %+v", current)
+	}
+
+	// concurrent write between read and update of the record is detected
+	prev := copyRecord(current)
+	if _, err := Update(ctx, Config.DBName, Config.DBColl, recordSpec(rid), bson.M{"$set": bson.M{"revision": 3}}); err != nil {
+		t.Fatal(err)
+	}
+	err = updateRevision(ctx, prev, Record{"StringKey": "carol"}, 2, false)
+	if _, ok := isConflict(err); !ok {
+		t.Errorf("no conflict error for concurrent write, error %v", err)
+	}
+}
+
+// TestHTTPRecordETag
+func TestHTTPRecordETag(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	rid := insertTestRecord(t, ctx, "/a/b/c/etag")
+	router := Handlers()
+
+	req := httptest.NewRequest("GET", "/record/"+rid, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != "\"1\"" {
+		t.Fatalf("wrong response %d, etag %s", rr.Code, rr.Header().Get("ETag"))
+	}
+	var rec Record
+	if err := json.Unmarshal(rr.Body.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+
+	// update without revision is rejected
+	rec["StringKey"] = "new"
+	data, _ := json.Marshal(Record{"StringKey": "new", "StrKeyMultipleValues": "bla", "ListKey": []string{"3A"}, "FloatKey": 1.1, "BoolKey": true})
+	req = httptest.NewRequest("PUT", "/record/"+rid, strings.NewReader(string(data)))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("wrong status %d of update without revision", rr.Code)
+	}
+
+	// update with current revision succeeds and provides new ETag
+	data, _ = json.Marshal(rec)
+	req = httptest.NewRequest("PUT", "/record/"+rid, strings.NewReader(string(data)))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != "\"2\"" {
+		t.Fatalf("wrong response %d, etag %s, body %s", rr.Code, rr.Header().Get("ETag"), rr.Body.String())
+	}
+
+	// update with stale revision is rejected with conflict details
+	req = httptest.NewRequest("PUT", "/record/"+rid, strings.NewReader(string(data)))
+	req.Header.Set("If-Match", "\"1\"")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("wrong status %d of stale update", rr.Code)
+	}
+	var resp struct {
+		Conflict ConflictError `json:"conflict"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Conflict.Current != 2 || len(resp.Conflict.Changes) == 0 {
+		t.Errorf("wrong conflict response %s", rr.Body.String())
+	}
+}
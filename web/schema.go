@@ -20,7 +20,7 @@ import (
 )
 
 // skip keys
-var _skipKeys = []string{"User", "Date", "Description", "SchemaName", "SchemaFile", "Schema"}
+var _skipKeys = []string{"User", "Date", "Description", "SchemaName", "SchemaFile", "Schema", "Parent", "Processing"}
 
 // SchemaKeys represents full collection of schema keys across all schemas
 type SchemaKeys map[string]string
@@ -95,11 +95,16 @@ type SchemaRecord struct {
 	Value       any    `json:"value"`
 	Placeholder string `json:"placeholder"`
 	Description string `json:"description"`
+	Index       string `json:"index"`     // name of (compound) index the key belongs to
+	Unique      bool   `json:"unique"`    // index should be unique
+	TextIndex   int    `json:"textIndex"` // weight of the key in text index
+	Facet       bool   `json:"facet"`     // key is used as facet of search results
+	Position    int    `json:"-"`         // position of the key in schema file
 }
 
 // Schema provides structure of schema file
 type Schema struct {
-	FileName       string                  `json:"fileName`
+	FileName       string                  `json:"fileName"`
 	Map            map[string]SchemaRecord `json:"map"`
 	WebSectionKeys map[string][]string     `json:"webSectionKeys"`
 }
@@ -158,6 +163,14 @@ func (s *Schema) Load() error {
 					smap.Description = v.(string)
 				} else if k == "placeholder" {
 					smap.Placeholder = v.(string)
+				} else if k == "index" {
+					smap.Index = v.(string)
+				} else if k == "unique" {
+					smap.Unique = v.(bool)
+				} else if k == "textIndex" {
+					smap.TextIndex = v.(int)
+				} else if k == "facet" {
+					smap.Facet = v.(bool)
 				}
 			}
 			records = append(records, smap)
@@ -169,7 +182,8 @@ func (s *Schema) Load() error {
 	}
 	s.FileName = fname
 	smap := make(map[string]SchemaRecord)
-	for _, r := range records {
+	for idx, r := range records {
+		r.Position = idx
 		smap[r.Key] = r
 	}
 	// update schema map
@@ -0,0 +1,710 @@
+package main
+
+// sequences module provides compact representation of numbered files, e.g.
+// detector frames scan_000001.tif ... scan_100000.tif, which are stored in
+// FilesDB as single sequence with printf pattern, range of frame numbers and
+// its gaps instead of one row per file
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frameRegexp splits base name of a file into prefix, frame number and suffix
+var frameRegexp = regexp.MustCompile(`^(.*?)([0-9]{1,18})([^0-9]*)$`)
+
+// FileSequence represents sequence of numbered files of a dataset
+type FileSequence struct {
+	Pattern   string `json:"pattern"` // printf pattern of file names, e.g. /data/scan_%06d.tif
+	First     int64  `json:"first"`
+	Last      int64  `json:"last"`
+	Gaps      string `json:"gaps,omitempty"` // missing frames, e.g. 5-7,10
+	Frames    int64  `json:"frames"`         // number of frames
+	Size      int64  `json:"size"`           // total size of frames
+	FrameSize int64  `json:"frame_size"`     // size of every frame, 0 if frames differ in size
+	Mtime     int64  `json:"mtime"`          // latest modification time of frames
+	Tier      string `json:"tier,omitempty"`
+}
+
+// helper function to get minimal number of files of a sequence, sequences
+// are opt-in since checksums of their frames are not stored, they are
+// disabled unless sequenceMinFiles configuration parameter is positive
+func sequenceMinFiles() int {
+	return Config.SequenceMinFiles
+}
+
+// parseFrame splits file name into sequence pattern and frame number, the
+// frame number is the last group of digits of the file base name
+func parseFrame(name string) (string, int64, bool) {
+	dir, base := filepath.Split(name)
+	m := frameRegexp.FindStringSubmatch(base)
+	if m == nil {
+		return "", 0, false
+	}
+	n, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	esc := func(s string) string { return strings.ReplaceAll(s, "%", "%%") }
+	pattern := fmt.Sprintf("%s%%0%dd%s", esc(dir+m[1]), len(m[2]), esc(m[3]))
+	return pattern, n, true
+}
+
+// helper function to parse ranges, e.g. 1-3,5, into list of [first, last] pairs
+func parseRanges(ranges string) ([][2]int64, error) {
+	var out [][2]int64
+	if ranges == "" {
+		return out, nil
+	}
+	for _, r := range strings.Split(ranges, ",") {
+		first, last, found := strings.Cut(r, "-")
+		a, err := strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return out, fmt.Errorf("invalid range '%s'", r)
+		}
+		b := a
+		if found {
+			if b, err = strconv.ParseInt(last, 10, 64); err != nil || b < a {
+				return out, fmt.Errorf("invalid range '%s'", r)
+			}
+		}
+		out = append(out, [2]int64{a, b})
+	}
+	return out, nil
+}
+
+// helper function to create sequence of given frames, the numbers are
+// frame numbers of the files
+func newSequence(pattern, tier string, numbers []int64, files []FileInfo) FileSequence {
+	seq := FileSequence{Pattern: pattern, Tier: tier, Frames: int64(len(files))}
+	sorted := append([]int64{}, numbers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	seq.First, seq.Last = sorted[0], sorted[len(sorted)-1]
+	var gaps []string
+	for i := 1; i < len(sorted); i++ {
+		first, last := sorted[i-1]+1, sorted[i]-1
+		if first == last {
+			gaps = append(gaps, fmt.Sprintf("%d", first))
+		} else if first < last {
+			gaps = append(gaps, fmt.Sprintf("%d-%d", first, last))
+		}
+	}
+	seq.Gaps = strings.Join(gaps, ",")
+	seq.FrameSize = files[0].Size
+	for _, f := range files {
+		seq.Size += f.Size
+		if f.Size != seq.FrameSize {
+			seq.FrameSize = 0
+		}
+		if f.Mtime > seq.Mtime {
+			seq.Mtime = f.Mtime
+		}
+	}
+	return seq
+}
+
+// helper structure to collect frames of a sequence
+type frameGroup struct {
+	Pattern string
+	Tier    string
+	Numbers []int64
+	Files   []FileInfo
+}
+
+// helper function to add file to the group of its sequence
+func addFrame(groups map[string]*frameGroup, keys *[]string, f FileInfo) bool {
+	if f.Type != EntryFile {
+		return false
+	}
+	pattern, n, ok := parseFrame(f.Name)
+	if !ok {
+		return false
+	}
+	key := f.Tier + ":" + pattern
+	group, ok := groups[key]
+	if !ok {
+		group = &frameGroup{Pattern: pattern, Tier: f.Tier}
+		groups[key] = group
+		*keys = append(*keys, key)
+	}
+	group.Numbers = append(group.Numbers, n)
+	group.Files = append(group.Files, f)
+	return true
+}
+
+// splitSequences detects sequences of numbered regular files of the same
+// data tier which have at least minFiles frames, it returns found sequences
+// along with remaining files
+func splitSequences(files []FileInfo, minFiles int) ([]FileSequence, []FileInfo) {
+	if minFiles <= 0 {
+		return nil, files
+	}
+	groups := make(map[string]*frameGroup)
+	var keys []string
+	for _, f := range files {
+		addFrame(groups, &keys, f)
+	}
+	var seqs []FileSequence
+	frames := make(map[string]bool)
+	for _, key := range keys {
+		group := groups[key]
+		if len(group.Files) < minFiles {
+			continue
+		}
+		seqs = append(seqs, newSequence(group.Pattern, group.Tier, group.Numbers, group.Files))
+		for _, f := range group.Files {
+			frames[f.Name] = true
+		}
+	}
+	if len(frames) == 0 {
+		return seqs, files
+	}
+	var rest []FileInfo
+	for _, f := range files {
+		if !frames[f.Name] {
+			rest = append(rest, f)
+		}
+	}
+	return seqs, rest
+}
+
+// Ranges returns ranges of existing frames of the sequence, e.g. 1-4,8-10
+func (s FileSequence) Ranges() string {
+	gaps, err := parseRanges(s.Gaps)
+	if err != nil {
+		log.Printf("WARNING: sequence %s, error %v", s.Pattern, err)
+	}
+	var out []string
+	next := s.First
+	for _, g := range append(gaps, [2]int64{s.Last + 1, s.Last + 1}) {
+		if g[0] > next {
+			if g[0]-1 == next {
+				out = append(out, fmt.Sprintf("%d", next))
+			} else {
+				out = append(out, fmt.Sprintf("%d-%d", next, g[0]-1))
+			}
+		}
+		next = g[1] + 1
+	}
+	return strings.Join(out, ",")
+}
+
+// Contains checks if sequence contains frame with given number
+func (s FileSequence) Contains(n int64) bool {
+	if n < s.First || n > s.Last {
+		return false
+	}
+	gaps, _ := parseRanges(s.Gaps)
+	for _, g := range gaps {
+		if n >= g[0] && n <= g[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// FrameName returns file name of frame with given number
+func (s FileSequence) FrameName(n int64) string {
+	return fmt.Sprintf(s.Pattern, n)
+}
+
+// Info returns summary of the sequence as single file entry
+func (s FileSequence) Info() FileInfo {
+	return FileInfo{
+		Name:   s.Pattern,
+		Size:   s.Size,
+		Mtime:  s.Mtime,
+		Type:   EntrySequence,
+		Tier:   s.Tier,
+		Frames: s.Ranges(),
+	}
+}
+
+// helper function to count files, summary entries of sequences are counted
+// by number of their frames
+func numberOfFiles(files []FileInfo) int64 {
+	var nfiles int64
+	for _, f := range files {
+		if f.Type != EntrySequence {
+			nfiles++
+			continue
+		}
+		ranges, _ := parseRanges(f.Frames)
+		for _, r := range ranges {
+			nfiles += r[1] - r[0] + 1
+		}
+	}
+	return nfiles
+}
+
+// helper function to build file entry of frame with given number, size of
+// the frame is known only if all frames have the same size
+func (s FileSequence) frameInfo(n int64) FileInfo {
+	return FileInfo{
+		Name:  s.FrameName(n),
+		Size:  s.FrameSize,
+		Mtime: s.Mtime,
+		Type:  EntryFile,
+		Tier:  s.Tier,
+	}
+}
+
+// Expand returns individual frames of the sequence, size of frames is known
+// only if all frames have the same size
+func (s FileSequence) Expand() []FileInfo {
+	var files []FileInfo
+	gaps, _ := parseRanges(s.Gaps)
+	for n := s.First; n <= s.Last; n++ {
+		if len(gaps) > 0 && n >= gaps[0][0] {
+			n = gaps[0][1]
+			gaps = gaps[1:]
+			continue
+		}
+		files = append(files, s.frameInfo(n))
+	}
+	return files
+}
+
+// seqPatternRegexp splits printf pattern of a sequence into prefix, width of
+// frame number and suffix, the suffix of frame names has no digits
+var seqPatternRegexp = regexp.MustCompile(`^(.*)%0([0-9]+)d([^0-9]*)$`)
+
+// helper function to get prefix, width of frame number and suffix of frame
+// names of the sequence
+func (s FileSequence) patternParts() (string, int, string, bool) {
+	m := seqPatternRegexp.FindStringSubmatch(s.Pattern)
+	if m == nil {
+		return "", 0, "", false
+	}
+	width, err := strconv.Atoi(m[2])
+	if err != nil || width <= 0 || width > 18 {
+		return "", 0, "", false
+	}
+	unesc := func(s string) string { return strings.ReplaceAll(s, "%%", "%") }
+	return unesc(m[1]), width, unesc(m[3]), true
+}
+
+// frameBlock represents frames whose numbers start with given digits
+// followed by any free digits, e.g. digits 0012 with 2 free digits are
+// frames 001200-001299
+type frameBlock struct {
+	Digits string
+	Free   int
+}
+
+// helper function to split existing frames of the sequence into blocks in
+// order of frame names, frame numbers wider than pattern width have more
+// digits and are split separately
+func (s FileSequence) frameBlocks(width int) []frameBlock {
+	var blocks []frameBlock
+	ranges, _ := parseRanges(s.Ranges())
+	for _, r := range ranges {
+		var low, high int64 = 0, 1
+		for w := 1; w <= 18; w++ {
+			high *= 10
+			if w < width {
+				continue
+			}
+			// numbers of w digits are within [low, high)
+			first, last := r[0], r[1]
+			if first < low {
+				first = low
+			}
+			if last > high-1 {
+				last = high - 1
+			}
+			for first <= last {
+				free, size := 0, int64(1)
+				for free < w && first%(size*10) == 0 && first+size*10-1 <= last {
+					size *= 10
+					free++
+				}
+				digits := fmt.Sprintf("%0*d", w, first)
+				blocks = append(blocks, frameBlock{Digits: digits[:w-free], Free: free})
+				first += size
+			}
+			low = high
+		}
+	}
+	return blocks
+}
+
+// helper structure to count frames of a sequence which match glob pattern,
+// numbers of matching frames are memorized by states of the pattern and
+// number of free digits
+type frameMatcher struct {
+	Seq    FileSequence
+	Glob   globMatcher
+	Suffix string
+	counts map[string]int64
+}
+
+// helper function to count frames which match the pattern, the set of
+// pattern states is reached by name of the frame up to its free digits
+func (m *frameMatcher) count(set string, free int) int64 {
+	if m.Glob.dead(set) {
+		return 0
+	}
+	if free == 0 {
+		if m.Glob.accepts(m.Glob.feed(set, m.Suffix)) {
+			return 1
+		}
+		return 0
+	}
+	key := fmt.Sprintf("%d:%s", free, set)
+	if n, ok := m.counts[key]; ok {
+		return n
+	}
+	var n int64
+	for d := '0'; d <= '9'; d++ {
+		n += m.count(m.Glob.step(set, d), free-1)
+	}
+	m.counts[key] = n
+	return n
+}
+
+// helper function to collect matching frames of given block, the first skip
+// matching frames are skipped and at most limit frames are collected
+func (m *frameMatcher) frames(digits, set string, free int, skip, limit *int64, out *[]FileInfo) {
+	if *limit <= 0 {
+		return
+	}
+	n := m.count(set, free)
+	if n <= *skip {
+		*skip -= n
+		return
+	}
+	if free == 0 {
+		if num, err := strconv.ParseInt(digits, 10, 64); err == nil {
+			*out = append(*out, m.Seq.frameInfo(num))
+			*limit--
+		}
+		return
+	}
+	for d := '0'; d <= '9'; d++ {
+		m.frames(digits+string(d), m.Glob.step(set, d), free-1, skip, limit, out)
+	}
+}
+
+// MatchFrames returns number of frames of the sequence which match given glob
+// pattern along with at most limit matching frames which follow the first
+// skip matches. The frames are counted over ranges of frame numbers and only
+// returned frames are built.
+func (s FileSequence) MatchFrames(pattern string, skip, limit int64) (int64, []FileInfo) {
+	var out []FileInfo
+	prefix, width, suffix, ok := s.patternParts()
+	if !ok {
+		// unknown pattern, match every frame of the sequence
+		log.Printf("WARNING: unsupported sequence pattern %s", s.Pattern)
+		re := globToRegexp(pattern)
+		var total int64
+		for _, f := range s.Expand() {
+			if re.MatchString(f.Name) {
+				if total >= skip && int64(len(out)) < limit {
+					out = append(out, f)
+				}
+				total++
+			}
+		}
+		return total, out
+	}
+	m := &frameMatcher{Seq: s, Glob: globMatcher(pattern), Suffix: suffix, counts: make(map[string]int64)}
+	start := m.Glob.feed(m.Glob.start(), prefix)
+	var total int64
+	for _, b := range s.frameBlocks(width) {
+		set := m.Glob.feed(start, b.Digits)
+		total += m.count(set, b.Free)
+		m.frames(b.Digits, set, b.Free, &skip, &limit, &out)
+	}
+	return total, out
+}
+
+// helper function to insert sequence within transaction, it returns false
+// if sequence is already registered. Frames of sequence of replicated
+// dataset are inserted as individual files.
+func insertSequence(tx *sql.Tx, s FileSequence, metaId, datasetId int64) (bool, error) {
+	replicated, err := hasReplicas(tx, metaId)
+	if err != nil {
+		return false, err
+	}
+	if replicated {
+		frames := s.Expand()
+		setChecksums(frames)
+		added, err := insertFilesBulk(tx, nil, frames, metaId, datasetId)
+		return added > 0, err
+	}
+	create_at := time.Now().Unix()
+	create_by := "MetaData server"
+	tier := s.Tier
+	if tier == "" {
+		tier = TierRaw
+	}
+	stmt := "INSERT INTO sequences (pattern,first_frame,last_frame,gaps,frames,size,frame_size,mtime,tier,meta_id,dataset_id,create_at,create_by,modify_at,modify_by) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+	added, err := insertUnique(tx, stmt, s.Pattern, s.First, s.Last, s.Gaps, s.Frames, s.Size, s.FrameSize, s.Mtime, tier, metaId, datasetId, create_at, create_by, create_at, create_by)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with meta_id=%v pattern=%s error=%v", stmt, metaId, s.Pattern, err)
+	}
+	return added, err
+}
+
+// helper function to scan pattern, first_frame, last_frame, gaps, frames,
+// size, frame_size, mtime and tier rows
+func scanSequences(res *sql.Rows) ([]FileSequence, error) {
+	var seqs []FileSequence
+	defer res.Close()
+	for res.Next() {
+		var s FileSequence
+		var gaps, tier sql.NullString
+		var frames, size, frameSize, mtime sql.NullInt64
+		if err := res.Scan(&s.Pattern, &s.First, &s.Last, &gaps, &frames, &size, &frameSize, &mtime, &tier); err != nil {
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return seqs, err
+		}
+		s.Gaps = gaps.String
+		s.Frames = frames.Int64
+		s.Size = size.Int64
+		s.FrameSize = frameSize.Int64
+		s.Mtime = mtime.Int64
+		s.Tier = tier.String
+		seqs = append(seqs, s)
+	}
+	return seqs, res.Err()
+}
+
+// helper function to get sequences of given did, sequences of all data
+// tiers are returned if tier is empty
+func getSequences(did, tier string) ([]FileSequence, error) {
+	stmt := "SELECT S.pattern, S.first_frame, S.last_frame, S.gaps, S.frames, S.size, S.frame_size, S.mtime, S.tier FROM sequences S JOIN metadata M ON M.meta_id=S.meta_id WHERE M.did=?"
+	args := []any{did}
+	if tier != "" {
+		stmt += " AND S.tier=?"
+		args = append(args, tier)
+	}
+	stmt += " ORDER BY S.pattern"
+	res, err := FilesDB.Query(rebind(stmt), args...)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return nil, err
+	}
+	return scanSequences(res)
+}
+
+// helper function to find valid sequence which contains file with given
+// name, the look-up can be restricted to given did and data tier
+func findSequence(did, tier, name string) (FileSequence, bool, error) {
+	pattern, n, ok := parseFrame(name)
+	if !ok {
+		return FileSequence{}, false, nil
+	}
+	stmt := "SELECT S.pattern, S.first_frame, S.last_frame, S.gaps, S.frames, S.size, S.frame_size, S.mtime, S.tier FROM sequences S JOIN metadata M ON M.meta_id=S.meta_id WHERE S.pattern=? AND S.is_file_valid=1"
+	args := []any{pattern}
+	if did != "" {
+		stmt += " AND M.did=?"
+		args = append(args, did)
+	}
+	if tier != "" {
+		stmt += " AND S.tier=?"
+		args = append(args, tier)
+	}
+	res, err := FilesDB.Query(rebind(stmt), args...)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return FileSequence{}, false, err
+	}
+	seqs, err := scanSequences(res)
+	if err != nil {
+		return FileSequence{}, false, err
+	}
+	for _, s := range seqs {
+		if s.Contains(n) {
+			return s, true, nil
+		}
+	}
+	return FileSequence{}, false, nil
+}
+
+// helper function to check if files of given meta-data record have
+// replicas, such files are never stored as sequences since replicas refer
+// to individual files
+func hasReplicas(tx *sql.Tx, metaId int64) (bool, error) {
+	var nrep int
+	stmt := "SELECT COUNT(*) FROM replicas R JOIN files F ON F.file_id=R.file_id WHERE F.meta_id=?"
+	if err := tx.QueryRow(rebind(stmt), metaId).Scan(&nrep); err != nil {
+		log.Printf("ERROR: unable to execute %s with meta_id=%v, error=%v", stmt, metaId, err)
+		return false, err
+	}
+	return nrep > 0, nil
+}
+
+// expandSequences stores frames of sequences of given meta-data record as
+// individual files within transaction and removes the sequences, the frames
+// keep validity of their sequence and checksums of valid frames are
+// computed. It returns number of stored frames.
+func expandSequences(tx *sql.Tx, metaId, datasetId int64) (int, error) {
+	var nframes int
+	stmt := "SELECT pattern, first_frame, last_frame, gaps, frames, size, frame_size, mtime, tier FROM sequences WHERE meta_id=? AND is_file_valid=?"
+	vstmt := "UPDATE files SET is_file_valid=? WHERE meta_id=? AND file=?"
+	for _, valid := range []int{FileValid, FileInvalid, FileDeleted} {
+		res, err := tx.Query(rebind(stmt), metaId, valid)
+		if err != nil {
+			log.Printf("ERROR: unable to execute %s with meta_id=%v, error=%v", stmt, metaId, err)
+			return nframes, err
+		}
+		seqs, err := scanSequences(res)
+		if err != nil {
+			return nframes, err
+		}
+		for _, s := range seqs {
+			frames := s.Expand()
+			if valid == FileValid {
+				setChecksums(frames)
+			}
+			if _, err := insertFilesBulk(tx, nil, frames, metaId, datasetId); err != nil {
+				return nframes, err
+			}
+			if valid != FileValid {
+				for _, f := range frames {
+					if _, err := tx.Exec(rebind(vstmt), valid, metaId, f.Name); err != nil {
+						log.Printf("ERROR: unable to execute %s with file=%v, error=%v", vstmt, f.Name, err)
+						return nframes, err
+					}
+				}
+			}
+			nframes += len(frames)
+		}
+	}
+	stmt = "DELETE FROM sequences WHERE meta_id=?"
+	if _, err := tx.Exec(rebind(stmt), metaId); err != nil {
+		log.Printf("ERROR: unable to execute %s with meta_id=%v, error=%v", stmt, metaId, err)
+		return nframes, err
+	}
+	return nframes, nil
+}
+
+// helper function to get valid sequences of given dataset
+func datasetSequences(dataset string) ([]FileSequence, error) {
+	stmt := "SELECT S.pattern, S.first_frame, S.last_frame, S.gaps, S.frames, S.size, S.frame_size, S.mtime, S.tier FROM sequences S JOIN datasets D ON D.dataset_id=S.dataset_id WHERE D.dataset=? AND S.is_file_valid=1 ORDER BY S.pattern"
+	res, err := FilesDB.Query(rebind(stmt), dataset)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s, error=%v", stmt, err)
+		return nil, err
+	}
+	return scanSequences(res)
+}
+
+// syncSequences synchronizes sequences of given did with files found on
+// disk within transaction of syncFiles: frames of registered sequences
+// update their sequences, sequences without frames in scanned tiers are
+// invalidated and new sequences are detected among given new files. It
+// returns new files which do not belong to any sequence.
+func syncSequences(tx *sql.Tx, metaId, datasetId int64, tiers []string, files []FileInfo, report *RescanReport) ([]FileInfo, error) {
+	type seqRow struct {
+		ID    int64
+		Seq   FileSequence
+		Valid bool
+	}
+	rows := make(map[string]seqRow)
+	stmt := "SELECT sequence_id, is_file_valid, pattern, first_frame, last_frame, gaps, frames, size, frame_size, mtime, tier FROM sequences WHERE meta_id=?"
+	res, err := tx.Query(rebind(stmt), metaId)
+	if err != nil {
+		log.Printf("ERROR: unable to execute %s with meta_id=%v, error=%v", stmt, metaId, err)
+		return files, err
+	}
+	for res.Next() {
+		var row seqRow
+		var valid sql.NullInt64
+		var gaps, tier sql.NullString
+		var frames, size, frameSize, mtime sql.NullInt64
+		s := &row.Seq
+		if err := res.Scan(&row.ID, &valid, &s.Pattern, &s.First, &s.Last, &gaps, &frames, &size, &frameSize, &mtime, &tier); err != nil {
+			res.Close()
+			log.Printf("ERROR: unable to scan error=%v", err)
+			return files, err
+		}
+		row.Valid = valid.Int64 == 1
+		s.Gaps, s.Tier = gaps.String, tier.String
+		s.Frames, s.Size, s.FrameSize, s.Mtime = frames.Int64, size.Int64, frameSize.Int64, mtime.Int64
+		rows[s.Tier+":"+s.Pattern] = row
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return files, err
+	}
+
+	// frames of registered sequences
+	groups := make(map[string]*frameGroup)
+	var newFiles []FileInfo
+	var gkeys []string
+	for _, f := range files {
+		if pattern, _, ok := parseFrame(f.Name); ok && f.Type == EntryFile {
+			if _, ok := rows[f.Tier+":"+pattern]; ok {
+				addFrame(groups, &gkeys, f)
+				continue
+			}
+		}
+		newFiles = append(newFiles, f)
+	}
+
+	modify_at := time.Now().Unix()
+	modify_by := "MetaData server"
+	ustmt := "UPDATE sequences SET first_frame=?,last_frame=?,gaps=?,frames=?,size=?,frame_size=?,mtime=?,is_file_valid=1,modify_at=?,modify_by=? WHERE sequence_id=?"
+	for _, key := range SortedKeys(rows) {
+		row := rows[key]
+		group, ok := groups[key]
+		if !ok {
+			if row.Valid && InList(row.Seq.Tier, tiers) {
+				stmt := "UPDATE sequences SET is_file_valid=0,modify_at=?,modify_by=? WHERE sequence_id=?"
+				if _, err := tx.Exec(rebind(stmt), modify_at, modify_by, row.ID); err != nil {
+					log.Printf("ERROR: unable to execute %s with pattern=%v, error=%v", stmt, row.Seq.Pattern, err)
+					return files, err
+				}
+				report.Removed = append(report.Removed, row.Seq.Pattern)
+			}
+			continue
+		}
+		s := newSequence(group.Pattern, group.Tier, group.Numbers, group.Files)
+		old := row.Seq
+		if row.Valid && s.First == old.First && s.Last == old.Last && s.Gaps == old.Gaps && s.Size == old.Size && s.Mtime == old.Mtime {
+			report.Unchanged++
+			continue
+		}
+		if _, err := tx.Exec(rebind(ustmt), s.First, s.Last, s.Gaps, s.Frames, s.Size, s.FrameSize, s.Mtime, modify_at, modify_by, row.ID); err != nil {
+			log.Printf("ERROR: unable to execute %s with pattern=%v, error=%v", ustmt, s.Pattern, err)
+			return files, err
+		}
+		if row.Valid {
+			report.Updated = append(report.Updated, s.Pattern)
+		} else {
+			report.Restored = append(report.Restored, s.Pattern)
+		}
+	}
+
+	// new sequences, files of replicated datasets are stored individually
+	replicated, err := hasReplicas(tx, metaId)
+	if err != nil || replicated {
+		return newFiles, err
+	}
+	seqs, rest := splitSequences(newFiles, sequenceMinFiles())
+	for _, s := range seqs {
+		added, err := insertSequence(tx, s, metaId, datasetId)
+		if err != nil {
+			return files, err
+		}
+		if added {
+			report.Added = append(report.Added, s.Pattern)
+		} else {
+			report.Skipped = append(report.Skipped, s.Pattern)
+		}
+	}
+	return rest, nil
+}
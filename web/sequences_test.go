@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFileSequence
+func TestFileSequence(t *testing.T) {
+	pattern, n, ok := parseFrame("/data/scan_%1_000042.tif")
+	if !ok || pattern != "/data/scan_%%1_%06d.tif" || n != 42 {
+		t.Errorf("wrong frame %s %d", pattern, n)
+	}
+	if _, _, ok := parseFrame("/data1/scan.tif"); ok {
+		t.Error("frame is found in name without digits")
+	}
+
+	var files []FileInfo
+	for _, n := range []int{1, 2, 3, 4, 8, 9, 10} {
+		files = append(files, FileInfo{Name: fmt.Sprintf("/data/scan_%03d.tif", n), Size: 10, Mtime: int64(n), Type: EntryFile})
+	}
+	files = append(files, FileInfo{Name: "/data/scan.log", Type: EntryFile}, FileInfo{Name: "/data/run_1.h5", Type: EntryFile})
+	seqs, rest := splitSequences(files, 5)
+	if len(seqs) != 1 || len(rest) != 2 {
+		t.Fatalf("wrong sequences %+v, files %+v", seqs, rest)
+	}
+	s := seqs[0]
+	if s.Pattern != "/data/scan_%03d.tif" || s.First != 1 || s.Last != 10 || s.Gaps != "5-7" || s.Frames != 7 || s.Size != 70 || s.FrameSize != 10 || s.Mtime != 10 {
+		t.Errorf("wrong sequence %+v", s)
+	}
+	if s.Ranges() != "1-4,8-10" || !s.Contains(8) || s.Contains(6) || s.Contains(11) {
+		t.Errorf("wrong frames of sequence %+v", s)
+	}
+	frames := s.Expand()
+	if len(frames) != 7 || frames[4].Name != "/data/scan_008.tif" {
+		t.Errorf("wrong expanded frames %+v", frames)
+	}
+	if n := numberOfFiles([]FileInfo{s.Info(), rest[0]}); n != 8 {
+		t.Errorf("wrong number of files %d", n)
+	}
+	if seqs, rest := splitSequences(files, -1); len(seqs) != 0 || len(rest) != len(files) {
+		t.Errorf("sequences are not disabled %+v", seqs)
+	}
+	if Config.SequenceMinFiles == 0 && sequenceMinFiles() > 0 {
+		t.Error("sequences are enabled by default")
+	}
+}
+
+// TestMatchFrames
+func TestMatchFrames(t *testing.T) {
+	s := FileSequence{Pattern: "/data/100%%/scan_%03d.tif", First: 7, Last: 1234, Gaps: "10-19,95,200-998"}
+	frames := s.Expand()
+	patterns := []string{"*", "*_0?5.tif", "*1*", "/data/100%/scan_1???.tif", "*_12*.tif", "*.h5", "*%*9.tif"}
+	for _, pattern := range patterns {
+		re := globToRegexp(pattern)
+		var matched []FileInfo
+		for _, f := range frames {
+			if re.MatchString(f.Name) {
+				matched = append(matched, f)
+			}
+		}
+		for _, page := range [][2]int64{{0, 0}, {0, 5}, {3, 10}, {int64(len(matched)) - 2, 5}} {
+			skip, limit := page[0], page[1]
+			if skip < 0 {
+				skip = 0
+			}
+			total, found := s.MatchFrames(pattern, skip, limit)
+			var expect []FileInfo
+			for i := skip; i < int64(len(matched)) && i < skip+limit; i++ {
+				expect = append(expect, matched[i])
+			}
+			if total != int64(len(matched)) || len(found) != len(expect) {
+				t.Errorf("pattern %s, page %v, wrong total %d or frames %+v", pattern, page, total, found)
+				continue
+			}
+			for i := range found {
+				if found[i].Name != expect[i].Name {
+					t.Errorf("pattern %s, page %v, wrong frame %s, expect %s", pattern, page, found[i].Name, expect[i].Name)
+				}
+			}
+		}
+	}
+}
+
+// TestSequencesFilesDB
+func TestSequencesFilesDB(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+	minFiles := Config.SequenceMinFiles
+	defer func() { Config.SequenceMinFiles = minFiles }()
+	Config.SequenceMinFiles = 5
+
+	dir := t.TempDir()
+	write := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n := 1; n <= 10; n++ {
+		write(fmt.Sprintf("frame_%04d.tif", n))
+	}
+	write("notes.txt")
+	did := "sequences-did"
+	dataset := "/2024-1/seq/btr/sample"
+	if err := InsertFiles(did, dataset, dir, DiscoveryRules{SkipDirs: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+
+	// sequence is stored as single row
+	var nfiles, nseqs int
+	if err := FilesDB.QueryRow("SELECT COUNT(*) FROM files WHERE dataset_id IN (SELECT dataset_id FROM datasets WHERE dataset=?)", dataset).Scan(&nfiles); err != nil || nfiles != 1 {
+		t.Errorf("wrong number of files %d, error %v", nfiles, err)
+	}
+	if err := FilesDB.QueryRow("SELECT COUNT(*) FROM sequences WHERE dataset_id IN (SELECT dataset_id FROM datasets WHERE dataset=?)", dataset).Scan(&nseqs); err != nil || nseqs != 1 {
+		t.Errorf("wrong number of sequences %d, error %v", nseqs, err)
+	}
+
+	// summary and expanded files
+	files, err := getFileInfos(did, "", false)
+	if err != nil || len(files) != 2 || files[0].Type != EntrySequence || files[0].Frames != "1-10" {
+		t.Errorf("wrong summary of files %+v, error %v", files, err)
+	}
+	files, err = getFileInfos(did, "", true)
+	if err != nil || len(files) != 11 {
+		t.Errorf("wrong expanded files %+v, error %v", files, err)
+	}
+	names, err := getFiles(did)
+	if err != nil || len(names) != 11 {
+		t.Errorf("wrong files %v, error %v", names, err)
+	}
+	form := url.Values{"did": {did}}
+	rr, err := respRecorder("GET", "/files?"+form.Encode(), nil, FilesHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "frame_%04d.tif [1-10]") || !strings.Contains(body, "number of files: 11") {
+		t.Errorf("wrong files page %s", body)
+	}
+
+	// individual frames are matched by queries
+	frame := filepath.Join(dir, "frame_0007.tif")
+	found, total, err := findFiles(dataset, "*_000?.tif", 0, 5)
+	if err != nil || total != 9 || len(found) != 5 || found[0].Name != filepath.Join(dir, "frame_0001.tif") {
+		t.Errorf("wrong found files %+v, total %d, error %v", found, total, err)
+	}
+	info, err := getDatasetInfo(dataset)
+	if err != nil || info.Files != 11 || info.Size != 44 {
+		t.Errorf("wrong dataset info %+v, error %v", info, err)
+	}
+	replicas, err := findReplicas("", frame, "")
+	if err != nil || len(replicas) != 1 || replicas[0].Replicas[0].Path != frame {
+		t.Errorf("wrong frame replicas %+v, error %v", replicas, err)
+	}
+
+	// rescan updates gaps of the sequence and registers new sequence
+	rec := Record{"did": did, "dataset": dataset, "path": dir, "User": "test"}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, []Record{rec}); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(frame)
+	for n := 1; n <= 5; n++ {
+		write(fmt.Sprintf("dark_%02d.tif", n))
+	}
+	report, err := rescanDID(ctx, did, "test")
+	if err != nil || len(report.Updated) != 1 || len(report.Added) != 1 || report.Unchanged != 1 {
+		t.Errorf("wrong rescan report %+v, error %v", report, err)
+	}
+	seqs, err := getSequences(did, "")
+	if err != nil || len(seqs) != 2 || seqs[1].Gaps != "7" || seqs[1].Frames != 9 {
+		t.Errorf("wrong sequences %+v, error %v", seqs, err)
+	}
+	if _, ok, err := findSequence(did, "", frame); err != nil || ok {
+		t.Errorf("removed frame is found, error %v", err)
+	}
+	frame = filepath.Join(dir, "frame_0008.tif")
+	if _, ok, err := findSequence(did, TierRaw, frame); err != nil || !ok {
+		t.Errorf("frame is not found, error %v", err)
+	}
+	if _, ok, err := findSequence("other-did", "", frame); err != nil || ok {
+		t.Errorf("frame of other did is found, error %v", err)
+	}
+	if _, ok, err := findSequence(did, TierReduced, frame); err != nil || ok {
+		t.Errorf("frame of other tier is found, error %v", err)
+	}
+
+	// sequences of replicated dataset are expanded into individual files
+	nrep, err := addReplicas(did, "archive", "/archive", "", "", "")
+	if err != nil || nrep != 15 {
+		t.Errorf("wrong number of replicas %d, error %v", nrep, err)
+	}
+	if seqs, err := getSequences(did, ""); err != nil || len(seqs) != 0 {
+		t.Errorf("sequences of replicated dataset %+v, error %v", seqs, err)
+	}
+	replicas, err = findReplicas("", frame, "archive")
+	if err != nil || len(replicas) != 1 || replicas[0].Replicas[0].Path != "/archive/frame_0008.tif" {
+		t.Errorf("wrong frame replicas %+v, error %v", replicas, err)
+	}
+	files, err = getFileInfos(did, "", false)
+	if err != nil || len(files) != 15 || !strings.HasPrefix(files[0].Checksum, "adler32:") {
+		t.Errorf("wrong files of replicated dataset %+v, error %v", files, err)
+	}
+	for n := 1; n <= 5; n++ {
+		write(fmt.Sprintf("flat_%02d.tif", n))
+	}
+	if _, err := rescanDID(ctx, did, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, err := getSequences(did, ""); err != nil || len(seqs) != 0 {
+		t.Errorf("replicated dataset is compacted %+v, error %v", seqs, err)
+	}
+}
@@ -6,6 +6,7 @@ package main
 //
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -95,16 +96,37 @@ func Handlers() *mux.Router {
 	router.StrictSlash(true) // to allow /route and /route/ end-points
 	router.HandleFunc(basePath("/auth"), KAuthHandler).Methods("GET", "POST")
 	router.HandleFunc(basePath("/api"), APIHandler).Methods("POST")
+	router.HandleFunc(basePath("/api/bulk"), BulkHandler).Methods("POST")
 	router.HandleFunc(basePath("/search"), SearchHandler).Methods("GET", "POST")
 	router.HandleFunc(basePath("/files"), FilesHandler).Methods("GET", "POST")
 	router.HandleFunc(basePath("/faq"), FAQHandler)
 	router.HandleFunc(basePath("/status"), StatusHandler)
+	router.HandleFunc(basePath("/stats"), StatsHandler).Methods("GET")
 	router.HandleFunc(basePath("/schemas"), SchemasHandler)
 	router.HandleFunc(basePath("/server"), SettingsHandler)
 	router.HandleFunc(basePath("/data"), DataHandler)
 	router.HandleFunc(basePath("/process"), ProcessHandler)
+	router.HandleFunc(basePath("/update"), UpdateHandler).Methods("POST")
 	router.HandleFunc(basePath("/updateRecord"), UpdateRecordHandler)
 	router.HandleFunc(basePath("/json"), JsonHandler)
+	router.HandleFunc(basePath("/record/{id}"), RecordHandler).Methods("GET", "PUT")
+	router.HandleFunc(basePath("/record/{id}/history"), RecordHistoryHandler).Methods("GET")
+	router.HandleFunc(basePath("/record/{id}/revert"), RecordRevertHandler).Methods("POST")
+	router.HandleFunc(basePath("/record/{id}/delete"), RecordDeleteHandler).Methods("POST")
+	router.HandleFunc(basePath("/record/{id}/restore"), RecordRestoreHandler).Methods("POST")
+	router.HandleFunc(basePath("/record/{id}/purge"), RecordPurgeHandler).Methods("POST")
+	router.HandleFunc(basePath("/trash"), TrashHandler).Methods("GET")
+	router.HandleFunc(basePath("/admin/indexes"), IndexesHandler).Methods("GET", "POST")
+	router.HandleFunc(basePath("/admin/consistency"), ConsistencyHandler).Methods("GET", "POST")
+	router.HandleFunc(basePath("/events"), EventsHandler).Methods("GET")
+	router.HandleFunc(basePath("/lineage"), LineageHandler).Methods("GET", "POST")
+	router.HandleFunc(basePath("/rescan"), RescanHandler).Methods("POST")
+	router.HandleFunc(basePath("/jobs/{id}"), JobHandler).Methods("GET", "POST")
+	router.HandleFunc(basePath("/datasets"), DatasetsHandler).Methods("GET")
+	router.HandleFunc(basePath("/datasets/info"), DatasetHandler).Methods("GET")
+	router.HandleFunc(basePath("/datasets/files"), DatasetFilesHandler).Methods("GET")
+	router.HandleFunc(basePath("/replicas"), ReplicasHandler).Methods("GET", "POST", "DELETE")
+	router.HandleFunc(basePath("/events/{id}/deliveries"), DeliveriesHandler).Methods("GET")
 	router.HandleFunc(basePath("/"), AuthHandler).Methods("GET", "POST")
 
 	// common middleware
@@ -112,6 +134,50 @@ func Handlers() *mux.Router {
 	return router
 }
 
+// Migrate applies pending FilesDB migrations
+func Migrate(configFile string) {
+	ParseConfig(configFile)
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	db, err := InitFilesDB()
+	if err != nil {
+		log.Fatalf("FilesDB error: %v", err)
+	}
+	defer db.Close()
+	migrations, err := MigrateFilesDB(db, true)
+	if err != nil {
+		log.Fatalf("FilesDB migration error: %v", err)
+	}
+	version, _ := schemaVersion(db)
+	log.Printf("applied %d migrations, FilesDB version %d", len(migrations), version)
+}
+
+// Consistency checks consistency of MongoDB and FilesDB, prints report of
+// found problems and repairs them if requested
+func Consistency(configFile string, repair, dryRun bool) {
+	ParseConfig(configFile)
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	InitMetadataStore(Config.URI)
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Fatalf("FilesDB error: %v", err)
+	}
+	defer FilesDB.Close()
+	if _, err := MigrateFilesDB(FilesDB, false); err != nil {
+		log.Fatalf("FilesDB schema error: %v", err)
+	}
+	var report ConsistencyReport
+	if repair {
+		report, err = repairConsistency(context.Background(), dryRun)
+	} else {
+		report, err = checkConsistency(context.Background())
+	}
+	if err != nil {
+		log.Fatalf("consistency check error: %v", err)
+	}
+	fmt.Println(report.String())
+}
+
 // Server code
 func Server(configFile string) {
 	Time0 = time.Now()
@@ -149,8 +215,8 @@ func Server(configFile string) {
 	// dump server configuration
 	log.Printf("Configuration:\n%s", Config.String())
 
-	// initialize MongoDB
-	InitMongoDB(Config.URI)
+	// initialize meta-data store
+	InitMetadataStore(Config.URI)
 
 	// initialize FilesDB connection
 	FilesDB, err = InitFilesDB()
@@ -158,6 +224,10 @@ func Server(configFile string) {
 	if err != nil {
 		log.Printf("FilesDB error: %v\n", err)
 	}
+	// check FilesDB schema version and apply pending migrations if allowed
+	if _, err := MigrateFilesDB(FilesDB, Config.MigrateFilesDB); err != nil {
+		log.Fatalf("FilesDB schema error: %v", err)
+	}
 	// initialize schema manager
 	_smgr = SchemaManager{}
 	for _, fname := range Config.SchemaFiles {
@@ -169,6 +239,30 @@ func Server(configFile string) {
 	}
 	log.Println("Schema", _smgr.String())
 
+	// create or reconcile meta-data store indexes defined by our schemas
+	if _, err := EnsureIndexes(context.Background()); err != nil {
+		log.Printf("ERROR: unable to ensure meta-data store indexes, error %v", err)
+	}
+
+	// periodically refresh aggregation statistics
+	go refreshStats()
+
+	// register files of datasets in background and resume jobs which were
+	// interrupted by server restart
+	startJobWorkers()
+	if err := resumeJobs(context.Background()); err != nil {
+		log.Printf("ERROR: unable to resume jobs, error %v", err)
+	}
+	// resume webhook deliveries which were interrupted by server restart
+	if err := resumeDeliveries(context.Background()); err != nil {
+		log.Printf("ERROR: unable to resume webhook deliveries, error %v", err)
+	}
+
+	// periodically rescan dataset directories
+	if Config.RescanInterval > 0 {
+		go rescanFiles()
+	}
+
 	var templates Templates
 	tmplData := makeTmplData()
 	tmplData["Time"] = time.Now()
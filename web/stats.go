@@ -0,0 +1,210 @@
+package main
+
+// stats module provides aggregation statistics of meta-data records and
+// their files grouped by schema keys
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// AggregateStore defines aggregation operation of meta-data store
+type AggregateStore interface {
+	Aggregate(ctx context.Context, dbname, collname string, pipeline []bson.M) ([]Record, error)
+}
+
+// StatsGroup represents statistics of records with the same values of group keys
+type StatsGroup struct {
+	Keys    Record `json:"keys"`
+	Records int    `json:"records"`
+	Files   int    `json:"files"`
+}
+
+// StatsReport represents statistics of meta-data records grouped by given keys
+type StatsReport struct {
+	Keys      []string     `json:"keys"`
+	Groups    []StatsGroup `json:"groups"`
+	Records   int          `json:"records"`
+	Files     int          `json:"files"`
+	Timestamp int64        `json:"timestamp"`
+	Elapsed   float64      `json:"elapsed"` // time spent to build the report in seconds
+}
+
+// helper function to return default group keys of statistics
+func statsKeys() []string {
+	if len(Config.StatsKeys) > 0 {
+		return Config.StatsKeys
+	}
+	return []string{"Cycle", "Beamline", "Schema"}
+}
+
+// helper function to return refresh interval of statistics cache
+func statsInterval() time.Duration {
+	if Config.StatsInterval > 0 {
+		return time.Duration(Config.StatsInterval) * time.Second
+	}
+	return 10 * time.Minute
+}
+
+// parseStatsKeys parses group keys of statistics, the keys can be provided
+// as comma separated list and should be either schema keys or Schema
+func parseStatsKeys(params []string) ([]string, error) {
+	var keys []string
+	for _, param := range params {
+		for _, item := range strings.Split(param, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			key, ok := _schemaKeys[strings.ToLower(item)]
+			if strings.ToLower(item) == "schema" {
+				key, ok = "Schema", true
+			}
+			if !ok {
+				return nil, fmt.Errorf("unknown stats key '%s'", item)
+			}
+			if !InList(key, keys) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return statsKeys(), nil
+	}
+	return keys, nil
+}
+
+// statsPipeline returns aggregation pipeline which groups active records by
+// given keys, list values (e.g. ExperimentType) are unwound such that record
+// is counted in group of every its value
+func statsPipeline(keys []string) []bson.M {
+	pipeline := []bson.M{{"$match": activeSpec(bson.M{})}}
+	gid := bson.M{}
+	for _, k := range keys {
+		unwind := bson.M{"path": "$" + k, "preserveNullAndEmptyArrays": true}
+		pipeline = append(pipeline, bson.M{"$unwind": unwind})
+		gid[k] = "$" + k
+	}
+	group := bson.M{
+		"_id":      gid,
+		"records":  bson.M{"$sum": 1},
+		"datasets": bson.M{"$push": "$dataset"},
+	}
+	return append(pipeline, bson.M{"$group": group})
+}
+
+// computeStats runs aggregation pipeline for given group keys and joins
+// number of files of every dataset from FilesDB
+func computeStats(ctx context.Context, keys []string) (StatsReport, error) {
+	time0 := time.Now()
+	report := StatsReport{Keys: keys, Groups: []StatsGroup{}}
+	store, ok := MetaStore.(AggregateStore)
+	if !ok {
+		return report, errors.New("meta-data store does not support aggregation")
+	}
+	records, err := store.Aggregate(ctx, Config.DBName, Config.DBColl, statsPipeline(keys))
+	if err != nil {
+		return report, err
+	}
+	var counts map[string]int
+	if FilesDB != nil {
+		counts, err = datasetFileCounts()
+		if err != nil {
+			log.Printf("ERROR: unable to get number of files per dataset, error %v", err)
+		}
+	}
+	for _, rec := range records {
+		group := StatsGroup{Keys: make(Record), Records: intValue(rec["records"])}
+		gid, _ := toMap(rec["_id"])
+		for _, k := range keys {
+			group.Keys[k] = gid[k]
+		}
+		datasets, _ := toList(rec["datasets"])
+		for _, d := range datasets {
+			group.Files += counts[fmt.Sprintf("%v", d)]
+		}
+		report.Records += group.Records
+		report.Files += group.Files
+		report.Groups = append(report.Groups, group)
+	}
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		for _, k := range keys {
+			if c := compareValues(report.Groups[i].Keys[k], report.Groups[j].Keys[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	report.Timestamp = time.Now().Unix()
+	report.Elapsed = time.Since(time0).Seconds()
+	return report, nil
+}
+
+// StatsCache keeps statistics reports for different group keys
+type StatsCache struct {
+	mutex   sync.RWMutex
+	Reports map[string]StatsReport
+}
+
+// _statsCache holds our statistics reports
+var _statsCache = StatsCache{Reports: make(map[string]StatsReport)}
+
+// Get returns statistics report for given keys, the report is computed if it
+// is not yet cached or cached report is older than refresh interval
+func (c *StatsCache) Get(ctx context.Context, keys []string) (StatsReport, error) {
+	ckey := strings.Join(keys, ",")
+	c.mutex.RLock()
+	report, ok := c.Reports[ckey]
+	c.mutex.RUnlock()
+	if ok && time.Since(time.Unix(report.Timestamp, 0)) < statsInterval() {
+		return report, nil
+	}
+	report, err := computeStats(ctx, keys)
+	if err != nil {
+		return report, err
+	}
+	c.mutex.Lock()
+	c.Reports[ckey] = report
+	c.mutex.Unlock()
+	return report, nil
+}
+
+// Refresh re-computes all cached reports along with report of default keys
+func (c *StatsCache) Refresh(ctx context.Context) {
+	c.mutex.RLock()
+	ckeys := SortedKeys(c.Reports)
+	c.mutex.RUnlock()
+	if ckey := strings.Join(statsKeys(), ","); !InList(ckey, ckeys) {
+		ckeys = append(ckeys, ckey)
+	}
+	for _, ckey := range ckeys {
+		keys := strings.Split(ckey, ",")
+		report, err := computeStats(ctx, keys)
+		if err != nil {
+			log.Printf("ERROR: unable to compute stats for %v, error %v", keys, err)
+			continue
+		}
+		c.mutex.Lock()
+		c.Reports[ckey] = report
+		c.mutex.Unlock()
+	}
+}
+
+// refreshStats periodically refreshes statistics cache
+func refreshStats() {
+	for {
+		_statsCache.Refresh(context.Background())
+		time.Sleep(statsInterval())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"testing"
+)
+
+// TestParseStatsKeys
+func TestParseStatsKeys(t *testing.T) {
+	initMetaDataService()
+	keys, err := parseStatsKeys([]string{"stringkey, schema", "ListKey,StringKey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0] != "StringKey" || keys[1] != "Schema" || keys[2] != "ListKey" {
+		t.Errorf("wrong stats keys %v", keys)
+	}
+	if keys, err := parseStatsKeys(nil); err != nil || len(keys) == 0 {
+		t.Errorf("no default stats keys %v, error %v", keys, err)
+	}
+	if _, err := parseStatsKeys([]string{"UnknownKey"}); err == nil {
+		t.Error("no error for unknown stats key")
+	}
+}
+
+// TestStats
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+	did := "/beamline=stats/btr=1/cycle=1/sample_name=s1"
+	if err := insertStatsFiles(did, "/stats/a", []string{"/stats/a/f1", "/stats/a/f2"}); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+
+	records := []Record{
+		{"dataset": "/stats/a", "StringKey": "a", "ListKey": []string{"3A", "3B"}, "Schema": "test"},
+		{"dataset": "/stats/b", "StringKey": "a", "ListKey": []string{"3A"}, "Schema": "test"},
+		{"dataset": "/stats/c", "StringKey": "b", "ListKey": []string{"3B"}, "Schema": "test"},
+		{"dataset": "/stats/d", "StringKey": "b", "ListKey": []string{"3B"}, "Schema": "test", "deleted": true},
+	}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, records); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := computeStats(ctx, []string{"StringKey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 3 || report.Files != 2 || len(report.Groups) != 2 {
+		t.Fatalf("wrong stats report %+v", report)
+	}
+	if g := report.Groups[0]; g.Keys["StringKey"] != "a" || g.Records != 2 || g.Files != 2 {
+		t.Errorf("wrong stats group %+v", g)
+	}
+
+	// list values are counted in group of every their value
+	report, err = computeStats(ctx, []string{"ListKey", "Schema"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, g := range report.Groups {
+		counts[g.Keys["ListKey"].(string)] = g.Records
+	}
+	if len(counts) != 2 || counts["3A"] != 2 || counts["3B"] != 2 {
+		t.Errorf("wrong stats groups %+v", report.Groups)
+	}
+
+	// cached report is used until it is refreshed
+	cache := StatsCache{Reports: make(map[string]StatsReport)}
+	if _, err := cache.Get(ctx, []string{"StringKey"}); err != nil {
+		t.Fatal(err)
+	}
+	extra := Record{"dataset": "/stats/e", "StringKey": "c", "Schema": "test"}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, []Record{extra}); err != nil {
+		t.Fatal(err)
+	}
+	if report, _ := cache.Get(ctx, []string{"StringKey"}); report.Records != 3 {
+		t.Errorf("cached report is not used %+v", report)
+	}
+	cache.Refresh(ctx)
+	if report, _ := cache.Get(ctx, []string{"StringKey"}); report.Records != 4 {
+		t.Errorf("report is not refreshed %+v", report)
+	}
+}
+
+// helper function to insert files of given dataset within transaction
+func insertStatsFiles(did, dataset string, files []string) error {
+	tx, err := FilesDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var infos []FileInfo
+	for _, f := range files {
+		infos = append(infos, FileInfo{Name: f, Type: EntryFile})
+	}
+	if _, err := insertFiles(tx, did, dataset, DiscoveryRules{}, nil, infos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,109 @@
+package main
+
+// metadata store module
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// MetadataStore defines set of operations our server performs on meta-data records
+type MetadataStore interface {
+	Insert(ctx context.Context, dbname, collname string, records []Record) error
+	Upsert(ctx context.Context, dbname, collname, attr string, records []Record) error
+	Get(ctx context.Context, dbname, collname string, spec bson.M, idx, limit int) ([]Record, error)
+	GetSorted(ctx context.Context, dbname, collname string, spec bson.M, skeys []string, idx, limit int) ([]Record, error)
+	Update(ctx context.Context, dbname, collname string, spec, newdata bson.M) (int, error)
+	Count(ctx context.Context, dbname, collname string, spec bson.M) (int, error)
+	Remove(ctx context.Context, dbname, collname string, spec bson.M) error
+}
+
+// BulkError represents failures of individual records of bulk operation,
+// the Errors map is keyed by index of the record in given list of records
+type BulkError struct {
+	Errors map[int]error
+}
+
+// Error implements error interface
+func (e *BulkError) Error() string {
+	var indexes []int
+	for idx := range e.Errors {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	var out []string
+	for _, idx := range indexes {
+		out = append(out, fmt.Sprintf("record %d: %v", idx, e.Errors[idx]))
+	}
+	return strings.Join(out, "; ")
+}
+
+// helper function to get error of given record from error of bulk operation
+func recordError(err error, idx int) error {
+	var berr *BulkError
+	if errors.As(err, &berr) {
+		return berr.Errors[idx]
+	}
+	return err
+}
+
+// MetaStore holds meta-data store used by the server
+var MetaStore MetadataStore
+
+// InitMetadataStore initializes meta-data store based on given uri, the
+// memory:// scheme provides in-memory store, all other schemes use MongoDB
+func InitMetadataStore(uri string) {
+	if strings.HasPrefix(uri, "memory://") {
+		log.Println("MetadataStore: in-memory")
+		MetaStore = NewMemoryStore()
+		return
+	}
+	InitMongoDB(uri)
+	MetaStore = &Mongo
+}
+
+// Insert records into meta-data store
+func Insert(ctx context.Context, dbname, collname string, records []Record) error {
+	return MetaStore.Insert(ctx, dbname, collname, records)
+}
+
+// MongoUpsert records into meta-data store
+func MongoUpsert(ctx context.Context, dbname, collname, attr string, records []Record) error {
+	return MetaStore.Upsert(ctx, dbname, collname, attr, records)
+}
+
+// MongoGet records from meta-data store
+func MongoGet(ctx context.Context, dbname, collname string, spec bson.M, idx, limit int) ([]Record, error) {
+	return MetaStore.Get(ctx, dbname, collname, spec, idx, limit)
+}
+
+// GetSorted records from meta-data store sorted by given keys, the key
+// prefixed with minus sign is sorted in descending order
+func GetSorted(ctx context.Context, dbname, collname string, spec bson.M, skeys []string, idx, limit int) ([]Record, error) {
+	return MetaStore.GetSorted(ctx, dbname, collname, spec, skeys, idx, limit)
+}
+
+// Update inplace first record matching given spec, it returns number of
+// matched records
+func Update(ctx context.Context, dbname, collname string, spec, newdata bson.M) (int, error) {
+	return MetaStore.Update(ctx, dbname, collname, spec, newdata)
+}
+
+// MongoCount gets number records from meta-data store
+func MongoCount(ctx context.Context, dbname, collname string, spec bson.M) (int, error) {
+	return MetaStore.Count(ctx, dbname, collname, spec)
+}
+
+// Remove records from meta-data store
+func Remove(ctx context.Context, dbname, collname string, spec bson.M) error {
+	return MetaStore.Remove(ctx, dbname, collname, spec)
+}
@@ -0,0 +1,98 @@
+package main
+
+// tiers module defines data tiers of registered files, every DataLocation
+// key of meta-data record is registered under its own tier
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// data tiers of registered files
+const (
+	TierRaw     = "RAW"
+	TierMeta    = "META"
+	TierReduced = "REDUCED"
+	TierScratch = "SCRATCH"
+	TierNotes   = "NOTES"
+)
+
+// DataTiers defines order in which data tiers are registered, a file found
+// in locations of several tiers belongs to the first one
+var DataTiers = []string{TierRaw, TierMeta, TierReduced, TierScratch, TierNotes}
+
+// DataLocationKeys maps data tiers to DataLocation keys of meta-data records
+var DataLocationKeys = map[string]string{
+	TierRaw:     "DataLocationRaw",
+	TierMeta:    "DataLocationMeta",
+	TierReduced: "DataLocationReduced",
+	TierScratch: "DataLocationScratch",
+	TierNotes:   "DataLocationBeamtimeNotes",
+}
+
+// DataLocation represents directory of data tier
+type DataLocation struct {
+	Tier string `json:"tier"`
+	Path string `json:"path"`
+}
+
+// parseTier returns data tier of given value, empty value means all tiers
+func parseTier(tier string) (string, error) {
+	tier = strings.ToUpper(tier)
+	if tier == "" || InList(tier, DataTiers) {
+		return tier, nil
+	}
+	return "", fmt.Errorf("unsupported data tier '%s', should be one of %s", tier, strings.Join(DataTiers, ", "))
+}
+
+// recordLocations returns data locations of meta-data record, the RAW
+// location is record path and other locations are taken from DataLocation
+// keys of the record
+func recordLocations(rec Record) []DataLocation {
+	var out []DataLocation
+	paths := make(map[string]bool)
+	for _, tier := range DataTiers {
+		var path string
+		if tier == TierRaw {
+			path, _ = rec["path"].(string)
+		} else {
+			path, _ = rec[DataLocationKeys[tier]].(string)
+		}
+		if path == "" || paths[path] {
+			continue
+		}
+		paths[path] = true
+		out = append(out, DataLocation{Tier: tier, Path: path})
+	}
+	return out
+}
+
+// discoverLocations finds files of given data locations which satisfy
+// discovery rules, missing locations of tiers other than RAW are skipped
+// since they can be created later. It returns found files along with tiers
+// whose locations were scanned.
+func discoverLocations(locations []DataLocation, rules DiscoveryRules, checksum bool) ([]FileInfo, []string, error) {
+	var files []FileInfo
+	var tiers []string
+	for _, loc := range locations {
+		if _, err := os.Stat(loc.Path); err != nil && loc.Tier != TierRaw {
+			log.Printf("WARNING: skip %s location %s, error %v", loc.Tier, loc.Path, err)
+			continue
+		}
+		found, err := DiscoverFiles(loc.Path, rules, checksum)
+		if err != nil {
+			return files, tiers, err
+		}
+		for i := range found {
+			found[i].Tier = loc.Tier
+		}
+		files = append(files, found...)
+		tiers = append(tiers, loc.Tier)
+	}
+	return files, tiers, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDataTiers
+func TestDataTiers(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var err error
+	FilesDB, err = InitFilesDB()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+	defer FilesDB.Close()
+
+	if tier, err := parseTier("reduced"); err != nil || tier != TierReduced {
+		t.Errorf("wrong tier %s, error %v", tier, err)
+	}
+	if _, err := parseTier("processed"); err == nil {
+		t.Error("no error for unsupported tier")
+	}
+
+	raw := t.TempDir()
+	reduced := t.TempDir()
+	for _, fname := range []string{filepath.Join(raw, "raw.tiff"), filepath.Join(reduced, "reduced.h5")} {
+		if err := os.WriteFile(fname, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	did := "tiers-did"
+	dataset := "/tiers/a"
+	rec := Record{
+		"did":                 did,
+		"dataset":             dataset,
+		"path":                raw,
+		"User":                "test",
+		"DataLocationRaw":     raw,
+		"DataLocationReduced": reduced,
+		"DataLocationMeta":    filepath.Join(raw, "missing"),
+		"DataLocationScratch": "",
+	}
+	locations := recordLocations(rec)
+	if len(locations) != 3 || locations[0].Tier != TierRaw || locations[1].Tier != TierMeta || locations[2].Tier != TierReduced {
+		t.Fatalf("wrong data locations %+v", locations)
+	}
+
+	// missing META location is skipped
+	if err := InsertLocations(did, dataset, locations, DiscoveryRules{SkipDirs: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteDID(did)
+	files, err := getFileInfos(did, "", false)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("wrong files %+v, error %v", files, err)
+	}
+	files, err = getFileInfos(did, TierReduced, false)
+	if err != nil || len(files) != 1 || files[0].Tier != TierReduced || filepath.Base(files[0].Name) != "reduced.h5" {
+		t.Errorf("wrong reduced files %+v, error %v", files, err)
+	}
+
+	// files page filtered by tier
+	form := url.Values{"did": {did}, "tier": {"reduced"}}
+	rr, err := respRecorder("GET", "/files?"+form.Encode(), nil, FilesHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "reduced.h5") || strings.Contains(body, "raw.tiff") {
+		t.Errorf("wrong files page %s", body)
+	}
+
+	// rescan picks up files of all tiers and does not invalidate files of
+	// missing locations
+	if err := os.WriteFile(filepath.Join(reduced, "more.h5"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Insert(ctx, Config.DBName, Config.DBColl, []Record{rec}); err != nil {
+		t.Fatal(err)
+	}
+	delete(rec, "DataLocationReduced")
+	report, err := rescanRecord(ctx, rec)
+	if err != nil || report.Changed() {
+		t.Errorf("wrong rescan report %+v, error %v", report, err)
+	}
+	rec["DataLocationReduced"] = reduced
+	report, err = rescanRecord(ctx, rec)
+	if err != nil || len(report.Added) != 1 || len(report.Removed) != 0 {
+		t.Errorf("wrong rescan report %+v, error %v", report, err)
+	}
+}
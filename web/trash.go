@@ -0,0 +1,178 @@
+package main
+
+// trash module provides soft deletion of meta-data records
+//
+// Copyright (c) 2024 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to check if given user is server admin
+func isAdmin(user string) bool {
+	return InList(user, Config.Admins)
+}
+
+// helper function to get owner of the record, i.e. user who created it,
+// records created before owners were stored are owned by their last editor
+func recordOwner(rec Record) string {
+	for _, key := range []string{"owner", "User"} {
+		if v, ok := rec[key]; ok && v != nil {
+			return fmt.Sprintf("%v", v)
+		}
+	}
+	return ""
+}
+
+// helper function to check if given user can manage given record
+func canManage(user string, rec Record) bool {
+	if isAdmin(user) {
+		return true
+	}
+	return user != "" && recordOwner(rec) == user
+}
+
+// helper function to check if record is tombstoned
+func isDeleted(rec Record) bool {
+	return rec["deleted"] == true
+}
+
+// activeSpec adds to given spec condition which excludes deleted records
+func activeSpec(spec bson.M) bson.M {
+	if spec == nil {
+		return spec
+	}
+	if _, ok := spec["deleted"]; !ok {
+		spec["deleted"] = bson.M{"$ne": true}
+	}
+	return spec
+}
+
+// helper function to check that dataset does not belong to deleted record
+func checkActiveDataset(ctx context.Context, dataset string) error {
+	ndel, err := MongoCount(ctx, Config.DBName, Config.DBColl, bson.M{"dataset": dataset, "deleted": true})
+	if err != nil {
+		return err
+	}
+	if ndel > 0 {
+		return fmt.Errorf("dataset %s is deleted", dataset)
+	}
+	return nil
+}
+
+// helper function to find record which given user may manage
+func managedRecord(ctx context.Context, rid, user string) (Record, error) {
+	rec, err := findRecord(ctx, rid)
+	if err != nil {
+		return nil, err
+	}
+	if !canManage(user, rec) {
+		msg := fmt.Sprintf("user %s is not allowed to manage record %s", user, rid)
+		return nil, errors.New(msg)
+	}
+	return rec, nil
+}
+
+// deleteRecord tombstones meta-data record and invalidates its files
+func deleteRecord(ctx context.Context, rid, user string) error {
+	rec, err := managedRecord(ctx, rid, user)
+	if err != nil {
+		return err
+	}
+	if isDeleted(rec) {
+		msg := fmt.Sprintf("record %s is already deleted", rid)
+		return errors.New(msg)
+	}
+	if did, ok := rec["did"]; ok {
+		if err := setDatasetDeleted(fmt.Sprintf("%v", did), true); err != nil {
+			return err
+		}
+	}
+	data := bson.M{"deleted": true, "deleted_by": user, "deleted_at": time.Now().Unix()}
+	if _, err := Update(ctx, Config.DBName, Config.DBColl, recordSpec(rid), bson.M{"$set": data}); err != nil {
+		return err
+	}
+	log.Printf("record %s is deleted by %s", rid, user)
+	evt := recordEvent(EventRecordDeleted, rec)
+	evt.User = user
+	emitEvents(ctx, evt)
+	return nil
+}
+
+// restoreRecord restores tombstoned meta-data record and its files
+func restoreRecord(ctx context.Context, rid, user string) error {
+	rec, err := managedRecord(ctx, rid, user)
+	if err != nil {
+		return err
+	}
+	if !isDeleted(rec) {
+		msg := fmt.Sprintf("record %s is not deleted", rid)
+		return errors.New(msg)
+	}
+	if did, ok := rec["did"]; ok {
+		if err := setDatasetDeleted(fmt.Sprintf("%v", did), false); err != nil {
+			return err
+		}
+	}
+	data := bson.M{"deleted": "", "deleted_by": "", "deleted_at": ""}
+	if _, err := Update(ctx, Config.DBName, Config.DBColl, recordSpec(rid), bson.M{"$unset": data}); err != nil {
+		return err
+	}
+	log.Printf("record %s is restored by %s", rid, user)
+	evt := recordEvent(EventRecordRestored, rec)
+	evt.User = user
+	emitEvents(ctx, evt)
+	return nil
+}
+
+// purgeRecord permanently removes tombstoned record from meta-data store
+// along with its history and FilesDB entries
+func purgeRecord(ctx context.Context, rid, user string) error {
+	rec, err := managedRecord(ctx, rid, user)
+	if err != nil {
+		return err
+	}
+	if !isDeleted(rec) {
+		msg := fmt.Sprintf("record %s should be deleted before it can be purged", rid)
+		return errors.New(msg)
+	}
+	if did, ok := rec["did"]; ok {
+		if err := deleteDID(fmt.Sprintf("%v", did)); err != nil {
+			return err
+		}
+	}
+	if err := Remove(ctx, Config.DBName, Config.HistoryColl, bson.M{"record_id": rid}); err != nil {
+		return err
+	}
+	if err := Remove(ctx, Config.DBName, Config.DBColl, recordSpec(rid)); err != nil {
+		return err
+	}
+	log.Printf("record %s is purged by %s", rid, user)
+	evt := recordEvent(EventRecordPurged, rec)
+	evt.User = user
+	emitEvents(ctx, evt)
+	return nil
+}
+
+// trashRecords returns deleted records visible to given user, i.e. records
+// the user can manage
+func trashRecords(ctx context.Context, user string) ([]Record, error) {
+	records, err := MongoGet(ctx, Config.DBName, Config.DBColl, bson.M{"deleted": true}, 0, -1)
+	if err != nil || isAdmin(user) {
+		return records, err
+	}
+	var out []Record
+	for _, rec := range records {
+		if canManage(user, rec) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
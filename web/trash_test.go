@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bson "go.mongodb.org/mongo-driver/bson"
+)
+
+// helper function to count valid files of given did
+func countValidFiles(t *testing.T, did string) int {
+	var count int
+	stmt := "SELECT COUNT(*) FROM files F JOIN metadata M ON M.meta_id=F.meta_id WHERE M.did=? AND F.is_file_valid=1"
+	if err := FilesDB.QueryRow(stmt, did).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// TestTrash
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	initMetaDataService()
+	MetaStore = NewMemoryStore()
+	var err error
+	FilesDB, err = InitFilesDB()
+	defer FilesDB.Close()
+	if err != nil {
+		log.Printf("FilesDB error: %v\n", err)
+	}
+
+	// prepare dataset with a file and a file which is gone from disk
+	path := t.TempDir()
+	for _, name := range []string{"file.txt", "gone.txt"} {
+		if err := os.WriteFile(filepath.Join(path, name), []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	did := "trash-did"
+	dataset := "/trash/beamline/btr/sample"
+	if err := InsertFiles(did, dataset, path, DiscoveryRules{}); err != nil {
+		t.Fatal(err)
+	}
+	// the file is invalidated by rescan before record is deleted
+	stmt := "UPDATE files SET is_file_valid=0 WHERE file=?"
+	if _, err := FilesDB.Exec(stmt, filepath.Join(path, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	nvalid := countValidFiles(t, did)
+	rec := Record{"dataset": dataset, "did": did, "User": "owner"}
+	if err := upsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	records, err := MongoGet(ctx, Config.DBName, Config.DBColl, bson.M{"did": did}, 0, 1)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("unable to find inserted record, error %v", err)
+	}
+	rid := recordID(records[0])
+
+	// only owner or admin can delete the record
+	if err := deleteRecord(ctx, rid, "stranger"); err == nil {
+		t.Error("stranger was able to delete the record")
+	}
+	if err := deleteRecord(ctx, rid, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := MongoCount(ctx, Config.DBName, Config.DBColl, activeSpec(bson.M{"did": did})); n != 0 {
+		t.Error("deleted record is visible in search")
+	}
+	if n := countValidFiles(t, did); n != 0 {
+		t.Errorf("deleted record has %d valid files", n)
+	}
+	if records, _ := trashRecords(ctx, "owner"); len(records) != 1 {
+		t.Errorf("wrong trash records %+v", records)
+	}
+	if dsets, total, err := findDatasets(dataset, 0, 10); err != nil || total != 0 || len(dsets) != 0 {
+		t.Errorf("deleted dataset is listed %+v, error %v", dsets, err)
+	}
+	form := url.Values{"dataset": {dataset}}
+	rr := httptest.NewRecorder()
+	DatasetHandler(rr, httptest.NewRequest("GET", "/datasets/info?"+form.Encode(), nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("wrong status of deleted dataset info %d", rr.Code)
+	}
+
+	// restore the record
+	if err := restoreRecord(ctx, rid, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := MongoCount(ctx, Config.DBName, Config.DBColl, activeSpec(bson.M{"did": did})); n != 1 {
+		t.Error("restored record is not visible in search")
+	}
+	if n := countValidFiles(t, did); n != nvalid {
+		t.Errorf("restored record has %d valid files instead of %d", n, nvalid)
+	}
+	if dsets, total, err := findDatasets(dataset, 0, 10); err != nil || total != 1 || len(dsets) != 1 {
+		t.Errorf("restored dataset is not listed %+v, error %v", dsets, err)
+	}
+
+	// purge the record as admin
+	Config.Admins = []string{"admin"}
+	defer func() { Config.Admins = nil }()
+	if err := purgeRecord(ctx, rid, "admin"); err == nil {
+		t.Error("record is purged without deletion")
+	}
+	if err := deleteRecord(ctx, rid, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := purgeRecord(ctx, rid, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := MongoCount(ctx, Config.DBName, Config.DBColl, bson.M{"did": did}); n != 0 {
+		t.Error("purged record still exists")
+	}
+	files, err := getFiles(did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("purged record still has files %v", files)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -36,26 +37,6 @@ func fileName(fname string) string {
 	return arr[0]
 }
 
-// FindFiles find files in given path
-func FindFiles(root string) []string {
-	var files []string
-	if root == "" {
-		return files
-	}
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("WARNING: unable to access %s/%s, error %v", root, path, err)
-		}
-//         log.Printf("dir: %v: name: %s\n", info.IsDir(), path)
-		files = append(files, path)
-		return nil
-	})
-	if err != nil {
-		log.Printf("FindFiles root %v, error %v\n", root, err)
-	}
-	return files
-}
-
 // Stack helper function to return Stack
 func Stack() string {
 	trace := make([]byte, 2048)
@@ -132,6 +113,16 @@ func MapKeys(rec Record) []string {
 	return keys
 }
 
+// SortedKeys helper function to return sorted keys of any map
+func SortedKeys[T any](dict map[string]T) []string {
+	keys := make([]string, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // EqualLists helper function to compare list of strings
 func EqualLists(list1, list2 []string) bool {
 	count := 0
@@ -450,9 +441,8 @@ const BOLD = "\x1b[1m"
 const PLAIN = "\x1b[0m"
 
 // helper function to provide pagination
-func pagination(query string, nres, startIdx, limit int) string {
+func pagination(query, sort string, filters []string, nres, startIdx, limit int) string {
 	var templates Templates
-	url := fmt.Sprintf("/search?query=%s", query)
 	tmplData := makeTmplData()
 	if nres > 0 {
 		tmplData["StartIndex"] = fmt.Sprintf("%d", startIdx+1)
@@ -465,39 +455,52 @@ func pagination(query string, nres, startIdx, limit int) string {
 		tmplData["EndIndex"] = fmt.Sprintf("%d", nres)
 	}
 	tmplData["Total"] = fmt.Sprintf("%d", nres)
-	tmplData["FirstUrl"] = makeURL(url, "first", startIdx, limit, nres)
-	tmplData["PrevUrl"] = makeURL(url, "prev", startIdx, limit, nres)
-	tmplData["NextUrl"] = makeURL(url, "next", startIdx, limit, nres)
-	tmplData["LastUrl"] = makeURL(url, "last", startIdx, limit, nres)
+	tmplData["FirstUrl"] = makeURL(query, sort, filters, "first", startIdx, limit, nres)
+	tmplData["PrevUrl"] = makeURL(query, sort, filters, "prev", startIdx, limit, nres)
+	tmplData["NextUrl"] = makeURL(query, sort, filters, "next", startIdx, limit, nres)
+	tmplData["LastUrl"] = makeURL(query, sort, filters, "last", startIdx, limit, nres)
 	page := templates.Tmpl(Config.Templates, "pagination.tmpl", tmplData)
 	return fmt.Sprintf("%s<br>", page)
 }
 
-func makeURL(url, urlType string, startIdx, limit, nres int) string {
-	var out string
+func makeURL(query, sort string, filters []string, urlType string, startIdx, limit, nres int) string {
 	var idx int
 	if urlType == "first" {
 		idx = 0
 	} else if urlType == "prev" {
-		if startIdx != 0 {
+		if startIdx > limit {
 			idx = startIdx - limit
 		} else {
 			idx = 0
 		}
 	} else if urlType == "next" {
 		idx = startIdx + limit
+		if idx >= nres {
+			idx = startIdx
+		}
 	} else if urlType == "last" {
 		j := 0
 		for i := 0; i < nres; i = i + limit {
-			if i > nres {
-				break
-			}
 			j = i
 		}
 		idx = j
 	}
-	out = fmt.Sprintf("%s&amp;idx=%d&&amp;limit=%d", url, idx, limit)
-	return out
+	return searchURL(query, sort, filters, idx, limit)
+}
+
+// helper function to build search URL for given query parameters
+func searchURL(query, sort string, filters []string, idx, limit int) string {
+	params := url.Values{}
+	params.Set("query", query)
+	if sort != "" {
+		params.Set("sort", sort)
+	}
+	for _, f := range filters {
+		params.Add("filter", f)
+	}
+	params.Set("idx", fmt.Sprintf("%d", idx))
+	params.Set("limit", fmt.Sprintf("%d", limit))
+	return fmt.Sprintf("%s/search?%s", Config.Base, params.Encode())
 }
 
 // helper function to return current stack of functions calls
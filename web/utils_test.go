@@ -15,8 +15,10 @@ func initMetaDataService() {
 	// initialize schema manager
 	initSchemaManager()
 
-	// init MongoDB
-	InitMongoDB(Config.URI)
+	// init meta-data store
+	InitMetadataStore(Config.URI)
+	// tests read events right after they are emitted
+	EventDelay = 0
 }
 
 // helper function to initialize SchemaManager